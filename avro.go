@@ -0,0 +1,683 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/hamba/avro/v2"
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+)
+
+// ParseAvroSchema parses an Avro schema using a fresh schema cache, so that named types
+// from previously parsed schemas never leak into the result.
+func ParseAvroSchema(schema string) (avro.Schema, error) {
+	return avro.ParseWithCache(schema, "", &avro.SchemaCache{})
+}
+
+// DecodeAvro decodes a single Avro datum into a generic value following the Avro JSON
+// encoding: records and maps become map[string]any, arrays []any, enums and strings
+// string, bytes and fixed []byte, int and long int64, float and double float64, and
+// non-null union values are wrapped as map[string]any{branchName: value}.
+func DecodeAvro(schema avro.Schema, data []byte) (any, error) {
+	r := avro.NewReader(nil, 0).Reset(data)
+	value := readAvroValue(schema, r)
+	if r.Error != nil {
+		return nil, fmt.Errorf("failed to decode avro message: %w", r.Error)
+	}
+	if r.Peek(); r.Error == nil {
+		return nil, fmt.Errorf("failed to decode avro message: unexpected trailing bytes")
+	}
+	return value, nil
+}
+
+func readAvroValue(schema avro.Schema, r *avro.Reader) any {
+	if r.Error != nil {
+		return nil
+	}
+
+	switch s := schema.(type) {
+	case *avro.NullSchema:
+		return nil
+	case *avro.PrimitiveSchema:
+		switch s.Type() {
+		case avro.Boolean:
+			return r.ReadBool()
+		case avro.Int:
+			return int64(r.ReadInt())
+		case avro.Long:
+			return r.ReadLong()
+		case avro.Float:
+			return float64(r.ReadFloat())
+		case avro.Double:
+			return r.ReadDouble()
+		case avro.Bytes:
+			return r.ReadBytes()
+		case avro.String:
+			return r.ReadString()
+		}
+	case *avro.RecordSchema:
+		record := make(map[string]any, len(s.Fields()))
+		for _, field := range s.Fields() {
+			record[field.Name()] = readAvroValue(field.Type(), r)
+		}
+		return record
+	case *avro.EnumSchema:
+		idx := int(r.ReadInt())
+		symbol, ok := s.Symbol(idx)
+		if !ok {
+			r.ReportError("decode enum", fmt.Sprintf("unknown enum symbol index %d", idx))
+			return nil
+		}
+		return symbol
+	case *avro.FixedSchema:
+		buf := make([]byte, s.Size())
+		r.Read(buf)
+		return buf
+	case *avro.ArraySchema:
+		items := make([]any, 0)
+		for {
+			count, _ := r.ReadBlockHeader()
+			if count == 0 || r.Error != nil {
+				break
+			}
+			for i := int64(0); i < count && r.Error == nil; i++ {
+				items = append(items, readAvroValue(s.Items(), r))
+			}
+		}
+		return items
+	case *avro.MapSchema:
+		values := make(map[string]any)
+		for {
+			count, _ := r.ReadBlockHeader()
+			if count == 0 || r.Error != nil {
+				break
+			}
+			for i := int64(0); i < count && r.Error == nil; i++ {
+				key := r.ReadString()
+				values[key] = readAvroValue(s.Values(), r)
+			}
+		}
+		return values
+	case *avro.UnionSchema:
+		idx := int(r.ReadLong())
+		types := s.Types()
+		if idx < 0 || idx >= len(types) {
+			r.ReportError("decode union", fmt.Sprintf("unknown union branch index %d", idx))
+			return nil
+		}
+		branch := types[idx]
+		if branch.Type() == avro.Null {
+			return nil
+		}
+		return map[string]any{AvroTypeName(branch): readAvroValue(branch, r)}
+	case *avro.RefSchema:
+		return readAvroValue(s.Schema(), r)
+	}
+
+	r.ReportError("decode", fmt.Sprintf("unsupported avro schema type %s", schema.Type()))
+	return nil
+}
+
+// AvroTypeName returns the name used to identify a schema as a union branch.
+func AvroTypeName(schema avro.Schema) string {
+	switch s := schema.(type) {
+	case avro.NamedSchema:
+		return s.FullName()
+	case *avro.RefSchema:
+		return s.Schema().FullName()
+	}
+	return string(schema.Type())
+}
+
+// unionBranch returns the union member selected by a decoded union value.
+func unionBranch(schema *avro.UnionSchema, value any) (avro.Schema, any, bool) {
+	if value == nil {
+		for _, typ := range schema.Types() {
+			if typ.Type() == avro.Null {
+				return typ, nil, true
+			}
+		}
+		return nil, nil, false
+	}
+
+	wrapped, ok := value.(map[string]any)
+	if !ok || len(wrapped) != 1 {
+		return nil, nil, false
+	}
+	for name, v := range wrapped {
+		for _, typ := range schema.Types() {
+			if AvroTypeName(typ) == name {
+				return typ, v, true
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+// derefAvroSchema resolves a reference to a named schema to its definition.
+func derefAvroSchema(schema avro.Schema) avro.Schema {
+	if ref, ok := schema.(*avro.RefSchema); ok {
+		return ref.Schema()
+	}
+	return schema
+}
+
+// EncodeAvro encodes a generic value, in the representation returned by DecodeAvro,
+// as a single Avro datum.
+func EncodeAvro(schema avro.Schema, value any) ([]byte, error) {
+	var buf bytes.Buffer
+	w := avro.NewWriter(&buf, 512)
+	if err := writeAvroValue(schema, value, w, "$"); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to encode avro message: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeAvroValue(schema avro.Schema, value any, w *avro.Writer, path string) error {
+	invalid := func() error {
+		return fmt.Errorf("%s: %s is not a valid %s value", path, renderValue(value), schema.Type())
+	}
+
+	switch s := schema.(type) {
+	case *avro.NullSchema:
+		if value != nil {
+			return invalid()
+		}
+	case *avro.PrimitiveSchema:
+		switch s.Type() {
+		case avro.Boolean:
+			v, ok := value.(bool)
+			if !ok {
+				return invalid()
+			}
+			w.WriteBool(v)
+		case avro.Int:
+			v, ok := value.(int64)
+			if !ok || v < math.MinInt32 || v > math.MaxInt32 {
+				return invalid()
+			}
+			w.WriteInt(int32(v))
+		case avro.Long:
+			v, ok := value.(int64)
+			if !ok {
+				return invalid()
+			}
+			w.WriteLong(v)
+		case avro.Float:
+			v, ok := value.(float64)
+			if !ok {
+				return invalid()
+			}
+			w.WriteFloat(float32(v))
+		case avro.Double:
+			v, ok := value.(float64)
+			if !ok {
+				return invalid()
+			}
+			w.WriteDouble(v)
+		case avro.Bytes:
+			v, ok := value.([]byte)
+			if !ok {
+				return invalid()
+			}
+			w.WriteBytes(v)
+		case avro.String:
+			v, ok := value.(string)
+			if !ok {
+				return invalid()
+			}
+			w.WriteString(v)
+		}
+	case *avro.RecordSchema:
+		record, ok := value.(map[string]any)
+		if !ok {
+			return invalid()
+		}
+		for _, field := range s.Fields() {
+			if err := writeAvroValue(field.Type(), record[field.Name()], w, PathField(path, field.Name())); err != nil {
+				return err
+			}
+		}
+	case *avro.EnumSchema:
+		v, ok := value.(string)
+		if !ok {
+			return invalid()
+		}
+		idx := slices.Index(s.Symbols(), v)
+		if idx < 0 {
+			return fmt.Errorf("%s: %q is not a symbol of enum %s", path, v, s.FullName())
+		}
+		w.WriteInt(int32(idx))
+	case *avro.FixedSchema:
+		v, ok := value.([]byte)
+		if !ok || len(v) != s.Size() {
+			return invalid()
+		}
+		_, _ = w.Write(v)
+	case *avro.ArraySchema:
+		items, ok := value.([]any)
+		if !ok {
+			return invalid()
+		}
+		if len(items) > 0 {
+			w.WriteBlockHeader(int64(len(items)), 0)
+			for i, item := range items {
+				if err := writeAvroValue(s.Items(), item, w, PathIndex(path, i)); err != nil {
+					return err
+				}
+			}
+		}
+		w.WriteBlockHeader(0, 0)
+	case *avro.MapSchema:
+		values, ok := value.(map[string]any)
+		if !ok {
+			return invalid()
+		}
+		if len(values) > 0 {
+			w.WriteBlockHeader(int64(len(values)), 0)
+			for _, key := range sortedKeys(values) {
+				w.WriteString(key)
+				if err := writeAvroValue(s.Values(), values[key], w, PathField(path, key)); err != nil {
+					return err
+				}
+			}
+		}
+		w.WriteBlockHeader(0, 0)
+	case *avro.UnionSchema:
+		branch, branchValue, ok := unionBranch(s, value)
+		if !ok {
+			return fmt.Errorf("%s: %s does not select a branch of union %s", path, renderValue(value), s.String())
+		}
+		w.WriteLong(int64(slices.IndexFunc(s.Types(), func(typ avro.Schema) bool {
+			return AvroTypeName(typ) == AvroTypeName(branch)
+		})))
+		return writeAvroValue(branch, branchValue, w, path)
+	case *avro.RefSchema:
+		return writeAvroValue(s.Schema(), value, w, path)
+	default:
+		return fmt.Errorf("%s: unsupported avro schema type %s", path, schema.Type())
+	}
+	return nil
+}
+
+// AvroFromJSON converts a plain JSON value into the generic representation used by
+// EncodeAvro. Union values may either use the Avro JSON encoding ({"string": "x"}) or
+// be given bare, in which case the first branch that accepts the value is selected.
+// Record fields that are missing take the default declared in the schema.
+func AvroFromJSON(schema avro.Schema, value any) (any, error) {
+	return (&jsonConverter{}).convert(schema, value, "$")
+}
+
+// ParseAvroMessage converts the JSON message of a contentsConfig like AvroFromJSON, but also accepts
+// matching rule definitions such as matching(type, 'Jane') in place of values. The example value
+// of each definition is used for the message, and its rules and generators are returned keyed by path.
+func ParseAvroMessage(schema avro.Schema, value any) (any, ruleSet, map[string]*pb.Generator, error) {
+	converter := &jsonConverter{rules: make(ruleSet), generators: make(map[string]*pb.Generator)}
+	native, err := converter.convert(schema, value, "$")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return native, converter.rules, converter.generators, nil
+}
+
+// jsonConverter converts JSON values into generic Avro values
+type jsonConverter struct {
+	// rules collects matching rules, when nil matching rule definitions are not parsed
+	rules ruleSet
+	// generators collects the generators declared by matching rule definitions
+	generators map[string]*pb.Generator
+}
+
+func (c *jsonConverter) convert(schema avro.Schema, value any, path string) (any, error) {
+	if s, ok := value.(string); ok && c.rules != nil && IsMatchingRuleDefinition(s) {
+		def, err := ParseMatchingRuleDefinition(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if len(def.Rules) > 0 {
+			c.rules.add(path, def.Rules...)
+		}
+		if def.Generator != nil {
+			c.generators[path] = def.Generator
+		}
+		value = def.Value
+	}
+
+	invalid := func() error {
+		return fmt.Errorf("%s: %s is not a valid %s value", path, renderValue(value), schema.Type())
+	}
+
+	// logical type values may be given in their readable form, such as an ISO 8601 date
+	if converted, ok, err := avroLogicalFromJSON(schema, value); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	} else if ok {
+		return converted, nil
+	}
+
+	switch s := schema.(type) {
+	case *avro.NullSchema:
+		if value != nil {
+			return nil, invalid()
+		}
+		return nil, nil
+	case *avro.PrimitiveSchema:
+		switch s.Type() {
+		case avro.Boolean:
+			if v, ok := value.(bool); ok {
+				return v, nil
+			}
+		case avro.Int, avro.Long:
+			v, ok := jsonInteger(value)
+			if !ok || (s.Type() == avro.Int && (v < math.MinInt32 || v > math.MaxInt32)) {
+				return nil, invalid()
+			}
+			return v, nil
+		case avro.Float, avro.Double:
+			if v, ok := jsonNumber(value); ok {
+				return v, nil
+			}
+		case avro.Bytes:
+			if v, ok := jsonBytes(value); ok {
+				return v, nil
+			}
+		case avro.String:
+			if v, ok := value.(string); ok {
+				return v, nil
+			}
+		}
+		return nil, invalid()
+	case *avro.RecordSchema:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, invalid()
+		}
+		record := make(map[string]any, len(s.Fields()))
+		for _, field := range s.Fields() {
+			fieldPath := PathField(path, field.Name())
+			fieldValue, ok := object[field.Name()]
+			if !ok {
+				if !field.HasDefault() {
+					return nil, fmt.Errorf("%s: field is required", fieldPath)
+				}
+				v, err := avroDefault(field.Type(), field.Default(), fieldPath)
+				if err != nil {
+					return nil, err
+				}
+				record[field.Name()] = v
+				continue
+			}
+			v, err := c.convert(field.Type(), fieldValue, fieldPath)
+			if err != nil {
+				return nil, err
+			}
+			record[field.Name()] = v
+		}
+		for name := range object {
+			if !slices.ContainsFunc(s.Fields(), func(f *avro.Field) bool { return f.Name() == name }) {
+				return nil, fmt.Errorf("%s: field is not defined in record %s", PathField(path, name), s.FullName())
+			}
+		}
+		return record, nil
+	case *avro.EnumSchema:
+		if v, ok := value.(string); ok && slices.Contains(s.Symbols(), v) {
+			return v, nil
+		}
+		return nil, invalid()
+	case *avro.FixedSchema:
+		if v, ok := jsonBytes(value); ok && len(v) == s.Size() {
+			return v, nil
+		}
+		return nil, invalid()
+	case *avro.ArraySchema:
+		if matcher, ok := c.collectionMatcher(value); ok {
+			return c.convertCollection(s, matcher, path)
+		}
+		items, ok := value.([]any)
+		if !ok {
+			return nil, invalid()
+		}
+		result := make([]any, len(items))
+		for i, item := range items {
+			v, err := c.convert(s.Items(), item, PathIndex(path, i))
+			if err != nil {
+				return nil, err
+			}
+			result[i] = v
+		}
+		return result, nil
+	case *avro.MapSchema:
+		if matcher, ok := c.collectionMatcher(value); ok {
+			return c.convertCollection(s, matcher, path)
+		}
+		values, ok := value.(map[string]any)
+		if !ok {
+			return nil, invalid()
+		}
+		result := make(map[string]any, len(values))
+		for key, item := range values {
+			v, err := c.convert(s.Values(), item, PathField(path, key))
+			if err != nil {
+				return nil, err
+			}
+			result[key] = v
+		}
+		return result, nil
+	case *avro.UnionSchema:
+		if value == nil {
+			if branch, _, ok := unionBranch(s, nil); ok {
+				return c.convert(branch, nil, path)
+			}
+			return nil, invalid()
+		}
+		// the Avro JSON encoding wraps union values in an object keyed by the branch name
+		if branch, branchValue, ok := unionBranch(s, value); ok {
+			v, err := c.convert(branch, branchValue, path)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{AvroTypeName(branch): v}, nil
+		}
+		for _, branch := range s.Types() {
+			if branch.Type() == avro.Null {
+				continue
+			}
+			// convert into a scratch rule set so failed branches leave no rules behind
+			trial := &jsonConverter{}
+			if c.rules != nil {
+				trial.rules = make(ruleSet)
+				trial.generators = make(map[string]*pb.Generator)
+			}
+			if v, err := trial.convert(branch, value, path); err == nil {
+				for rulePath, rules := range trial.rules {
+					c.rules.add(rulePath, rules.Rule...)
+				}
+				maps.Copy(c.generators, trial.generators)
+				return map[string]any{AvroTypeName(branch): v}, nil
+			}
+		}
+		return nil, fmt.Errorf("%s: %s does not match any branch of union %s", path, renderValue(value), s.String())
+	case *avro.RefSchema:
+		return c.convert(s.Schema(), value, path)
+	}
+	return nil, fmt.Errorf("%s: unsupported avro schema type %s", path, schema.Type())
+}
+
+// collectionMatcher returns the value as a collection matcher, if matching rule definitions are parsed
+// and it is an object with the COLLECTION_MATCHER_KEY
+func (c *jsonConverter) collectionMatcher(value any) (map[string]any, bool) {
+	matcher, ok := value.(map[string]any)
+	if !ok || c.rules == nil {
+		return nil, false
+	}
+	_, ok = matcher[COLLECTION_MATCHER_KEY]
+	return matcher, ok
+}
+
+// convertCollection converts the example value of a collection matcher for an array or map, recording
+// its rules. Elements and map values are converted under a wildcard path, such as $.items[*].sku, so
+// that the rules they declare apply to every element of the actual collection. The example elements of
+// arrayContains are converted separately, each becoming a variant with its own rules.
+func (c *jsonConverter) convertCollection(schema avro.Schema, matcher map[string]any, path string) (any, error) {
+	expr, _ := matcher[COLLECTION_MATCHER_KEY].(string)
+	rules, err := ParseCollectionDefinition(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for key := range matcher {
+		if key != COLLECTION_MATCHER_KEY && key != "value" {
+			return nil, fmt.Errorf("%s: unknown collection matcher field %s", path, key)
+		}
+	}
+	example, ok := matcher["value"]
+	if !ok {
+		return nil, fmt.Errorf("%s: collection matcher requires an example value", path)
+	}
+
+	array, isArray := schema.(*avro.ArraySchema)
+	for _, rule := range rules {
+		switch {
+		case rule.Type == "arrayContains" && !isArray:
+			return nil, fmt.Errorf("%s: arrayContains only applies to arrays", path)
+		case rule.Type == "arrayContains" && len(rules) > 1:
+			return nil, fmt.Errorf("%s: arrayContains can not be combined with other collection rules", path)
+		case rule.Type == "eachKey" && isArray:
+			return nil, fmt.Errorf("%s: eachKey only applies to maps", path)
+		}
+	}
+
+	if !isArray {
+		values, ok := example.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s is not a valid map example", path, renderValue(example))
+		}
+		c.rules.add(path, rules...)
+		result := make(map[string]any, len(values))
+		for key, item := range values {
+			v, err := c.convert(schema.(*avro.MapSchema).Values(), item, path+".*")
+			if err != nil {
+				return nil, err
+			}
+			result[key] = v
+		}
+		return result, nil
+	}
+
+	// eachLike and friends may be given the example element instead of an array of examples
+	items, ok := example.([]any)
+	if !ok {
+		items = []any{example}
+	}
+	result := make([]any, len(items))
+	if rules[0].Type != "arrayContains" {
+		c.rules.add(path, rules...)
+		for i, item := range items {
+			if result[i], err = c.convert(array.Items(), item, path+"[*]"); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+
+	variants := make([]any, len(items))
+	for i, item := range items {
+		variant := &jsonConverter{rules: make(ruleSet), generators: make(map[string]*pb.Generator)}
+		if result[i], err = variant.convert(array.Items(), item, "$"); err != nil {
+			return nil, fmt.Errorf("%s: arrayContains variant %d: %w", path, i, err)
+		}
+		variantRules := make(map[string]any, len(variant.rules))
+		for rulePath, rules := range variant.rules {
+			variantRules[rulePath] = map[string]any{"matchers": ruleValues(rules.Rule)}
+		}
+		variants[i] = map[string]any{"index": i, "rules": variantRules}
+		for generatorPath, generator := range variant.generators {
+			c.generators[PathIndex(path, i)+strings.TrimPrefix(generatorPath, "$")] = generator
+		}
+	}
+	c.rules.add(path, newMatchingRule("arrayContains", map[string]any{"variants": variants}))
+	return result, nil
+}
+
+// avroDefault converts a field default, as parsed by the avro library, into the generic representation.
+// Defaults of union fields always apply to the first branch of the union.
+func avroDefault(schema avro.Schema, def any, path string) (any, error) {
+	if union, ok := derefAvroSchema(schema).(*avro.UnionSchema); ok {
+		branch := union.Types()[0]
+		if branch.Type() == avro.Null {
+			return nil, nil
+		}
+		v, err := avroDefault(branch, def, path)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{AvroTypeName(branch): v}, nil
+	}
+	return (&jsonConverter{}).convert(schema, def, path)
+}
+
+// jsonInteger converts a JSON or Go numeric value into an int64 if it is integral.
+func jsonInteger(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// jsonNumber converts a JSON or Go numeric value into a float64.
+func jsonNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	if i, ok := jsonInteger(value); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
+
+// jsonBytes converts a bytes value from its Avro JSON encoding, a string whose code points
+// 0-255 map to byte values, into a byte slice.
+func jsonBytes(value any) ([]byte, bool) {
+	switch v := value.(type) {
+	case []byte:
+		return v, true
+	case string:
+		runes := []rune(v)
+		b := make([]byte, len(runes))
+		for i, r := range runes {
+			if r > 255 {
+				return nil, false
+			}
+			b[i] = byte(r)
+		}
+		return b, true
+	}
+
+	// fixed defaults are parsed by the avro library as byte arrays
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Array && rv.Type().Elem().Kind() == reflect.Uint8 {
+		b := make([]byte, rv.Len())
+		reflect.Copy(reflect.ValueOf(b), rv)
+		return b, true
+	}
+	return nil, false
+}
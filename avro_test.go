@@ -0,0 +1,131 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hamba/avro/v2"
+)
+
+// testAvroAPI encodes array and map blocks without a byte size, like the Java Avro library and EncodeAvro
+var testAvroAPI = avro.Config{DisableBlockSizeHeader: true}.Freeze()
+
+const testAllTypesSchema = `{
+  "type": "record",
+  "name": "AllTypes",
+  "fields": [
+    { "name": "null", "type": "null" },
+    { "name": "boolean", "type": "boolean" },
+    { "name": "int", "type": "int" },
+    { "name": "long", "type": "long" },
+    { "name": "float", "type": "float" },
+    { "name": "double", "type": "double" },
+    { "name": "bytes", "type": "bytes" },
+    { "name": "string", "type": "string" },
+    { "name": "enum", "type": { "type": "enum", "name": "Colour", "symbols": ["RED", "GREEN"] } },
+    { "name": "fixed", "type": { "type": "fixed", "name": "Pair", "size": 2 } },
+    { "name": "array", "type": { "type": "array", "items": "int" } },
+    { "name": "map", "type": { "type": "map", "values": "string" } },
+    { "name": "optional", "type": ["null", "string"] },
+    { "name": "choice", "type": ["null", "int", "Colour"] },
+    { "name": "withDefault", "type": "string", "default": "none" }
+  ]
+}`
+
+// TestAvroRoundTrip tests that JSON messages encode identically to the avro library and decode back
+func TestAvroRoundTrip(t *testing.T) {
+	schema, err := ParseAvroSchema(testAllTypesSchema)
+	if err != nil {
+		t.Fatalf("failed to parse schema: %v", err)
+	}
+
+	message := map[string]any{
+		"null":     nil,
+		"boolean":  true,
+		"int":      float64(-42),
+		"long":     float64(1 << 40),
+		"float":    1.5,
+		"double":   2.25,
+		"bytes":    "\u0000ÿ",
+		"string":   "jane",
+		"enum":     "GREEN",
+		"fixed":    "ab",
+		"array":    []any{float64(1), float64(2)},
+		"map":      map[string]any{"k": "v"},
+		"optional": "x",
+		"choice":   map[string]any{"Colour": "RED"},
+	}
+	native, err := AvroFromJSON(schema, message)
+	if err != nil {
+		t.Fatalf("AvroFromJSON() unexpected error: %v", err)
+	}
+	encoded, err := EncodeAvro(schema, native)
+	if err != nil {
+		t.Fatalf("EncodeAvro() unexpected error: %v", err)
+	}
+
+	want, err := testAvroAPI.Marshal(schema, map[string]any{
+		"null":        nil,
+		"boolean":     true,
+		"int":         -42,
+		"long":        int64(1 << 40),
+		"float":       float32(1.5),
+		"double":      2.25,
+		"bytes":       []byte{0x00, 0xff},
+		"string":      "jane",
+		"enum":        "GREEN",
+		"fixed":       [2]byte{'a', 'b'},
+		"array":       []int{1, 2},
+		"map":         map[string]any{"k": "v"},
+		"optional":    map[string]any{"string": "x"},
+		"choice":      map[string]any{"Colour": "RED"},
+		"withDefault": "none",
+	})
+	if err != nil {
+		t.Fatalf("avro.Marshal() unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, encoded); diff != "" {
+		t.Errorf("EncodeAvro() mismatch (-want +got):\n%s", diff)
+	}
+
+	decoded, err := DecodeAvro(schema, encoded)
+	if err != nil {
+		t.Fatalf("DecodeAvro() unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(native, decoded); diff != "" {
+		t.Errorf("DecodeAvro() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestAvroFromJSONErrors tests that JSON values which do not match the schema are rejected
+func TestAvroFromJSONErrors(t *testing.T) {
+	schema, err := ParseAvroSchema(`{"type": "record", "name": "R", "fields": [
+		{ "name": "int", "type": "int" },
+		{ "name": "enum", "type": { "type": "enum", "name": "E", "symbols": ["A"] } },
+		{ "name": "choice", "type": ["null", "long"] }
+	]}`)
+	if err != nil {
+		t.Fatalf("failed to parse schema: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		message any
+	}{
+		{name: "not a record", message: "x"},
+		{name: "missing field", message: map[string]any{"int": 1.0, "enum": "A"}},
+		{name: "unknown field", message: map[string]any{"int": 1.0, "enum": "A", "choice": nil, "other": 1.0}},
+		{name: "fractional int", message: map[string]any{"int": 1.5, "enum": "A", "choice": nil}},
+		{name: "int out of range", message: map[string]any{"int": float64(1 << 40), "enum": "A", "choice": nil}},
+		{name: "unknown enum symbol", message: map[string]any{"int": 1.0, "enum": "B", "choice": nil}},
+		{name: "no matching union branch", message: map[string]any{"int": 1.0, "enum": "A", "choice": "x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := AvroFromJSON(schema, tt.message); err == nil {
+				t.Errorf("AvroFromJSON() expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+
+	"github.com/hamba/avro/v2"
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var pathIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// PathField appends a field or map key to a Pact path expression
+func PathField(path, name string) string {
+	if pathIdentifier.MatchString(name) {
+		return path + "." + name
+	}
+	return fmt.Sprintf("%s['%s']", path, name)
+}
+
+// PathIndex appends an array index to a Pact path expression
+func PathIndex(path string, idx int) string {
+	return fmt.Sprintf("%s[%d]", path, idx)
+}
+
+// mismatches collects content mismatches keyed by their Pact path
+type mismatches map[string][]*pb.ContentMismatch
+
+func (m mismatches) add(path string, expected, actual any, format string, args ...any) {
+	expectedJSON := renderValue(expected)
+	actualJSON := renderValue(actual)
+	m[path] = append(m[path], &pb.ContentMismatch{
+		Expected: wrapperspb.Bytes(expectedJSON),
+		Actual:   wrapperspb.Bytes(actualJSON),
+		Mismatch: fmt.Sprintf(format, args...),
+		Path:     path,
+		Diff:     fmt.Sprintf("-%s\n+%s", expectedJSON, actualJSON),
+	})
+}
+
+// withType sets the mismatch type of the collected mismatches, such as "metadata"
+func (m mismatches) withType(mismatchType string) mismatches {
+	for _, items := range m {
+		for _, item := range items {
+			item.MismatchType = mismatchType
+		}
+	}
+	return m
+}
+
+// results converts the collected mismatches into the CompareContentsResponse representation
+func (m mismatches) results() map[string]*pb.ContentMismatches {
+	results := make(map[string]*pb.ContentMismatches, len(m))
+	for path, items := range m {
+		results[path] = &pb.ContentMismatches{Mismatches: items}
+	}
+	return results
+}
+
+// renderValue renders a decoded value as JSON for use in mismatch descriptions
+func renderValue(value any) []byte {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Appendf(nil, "%v", value)
+	}
+	return data
+}
+
+// CompareAvro compares the expected and actual decoded Avro values, walking the writer
+// schema and recording a mismatch for every differing field. Values at paths with matching
+// rules are checked against those rules instead of being compared for equality. Fields of actual
+// records that are not in the schema, such as fields added by the provider, are mismatches unless
+// unexpected keys are allowed.
+func CompareAvro(schema avro.Schema, expected, actual any, rules ruleSet, allowUnexpectedKeys bool) mismatches {
+	c := &comparator{rules: rules, results: make(mismatches), allowUnexpectedKeys: allowUnexpectedKeys}
+	c.compareAvro("$", schema, expected, actual)
+	return c.results
+}
+
+// CompareJSON compares expected and actual values in the generic representation of JSON
+// values, for content types whose messages have no unions to resolve. Keys of actual objects that
+// are not expected are mismatches unless unexpected keys are allowed.
+func CompareJSON(expected, actual any, rules ruleSet, allowUnexpectedKeys bool) mismatches {
+	c := &comparator{rules: rules, results: make(mismatches), allowUnexpectedKeys: allowUnexpectedKeys}
+	c.compareJSON("$", expected, actual)
+	return c.results
+}
+
+// comparator compares decoded values, collecting mismatches
+type comparator struct {
+	rules   ruleSet
+	results mismatches
+	// allowUnexpectedKeys allows record fields and object keys that are not expected
+	allowUnexpectedKeys bool
+}
+
+func (c *comparator) compareAvro(path string, schema avro.Schema, expected, actual any) {
+	switch s := derefAvroSchema(schema).(type) {
+	case *avro.RecordSchema:
+		expectedRecord, _ := expected.(map[string]any)
+		actualRecord, _ := actual.(map[string]any)
+		for _, field := range s.Fields() {
+			c.compareAvro(PathField(path, field.Name()), field.Type(), expectedRecord[field.Name()], actualRecord[field.Name()])
+		}
+		if c.allowUnexpectedKeys {
+			return
+		}
+		for _, key := range sortedKeys(actualRecord) {
+			if !slices.ContainsFunc(s.Fields(), func(field *avro.Field) bool { return field.Name() == key }) {
+				c.results.add(PathField(path, key), nil, actualRecord[key], "Unexpected field '%s', which is not in record %s", key, s.FullName())
+			}
+		}
+	case *avro.ArraySchema:
+		expectedItems, _ := expected.([]any)
+		actualItems, _ := actual.([]any)
+		c.compareItems(path, expectedItems, actualItems, func(itemPath string, e, a any) {
+			c.compareAvro(itemPath, s.Items(), e, a)
+		})
+	case *avro.MapSchema:
+		expectedValues, _ := expected.(map[string]any)
+		actualValues, _ := actual.(map[string]any)
+		c.compareEntries(path, expectedValues, actualValues, false, func(entryPath string, e, a any) {
+			c.compareAvro(entryPath, s.Values(), e, a)
+		})
+	case *avro.UnionSchema:
+		expectedBranch, expectedValue, _ := unionBranch(s, expected)
+		actualBranch, actualValue, _ := unionBranch(s, actual)
+		if expectedBranch == nil || actualBranch == nil || AvroTypeName(expectedBranch) != AvroTypeName(actualBranch) {
+			c.results.add(path, expected, actual, "Expected union branch %s but received %s", unionBranchName(expectedBranch), unionBranchName(actualBranch))
+			return
+		}
+		c.compareAvro(path, expectedBranch, expectedValue, actualValue)
+	default:
+		// logical type values are compared in their readable form, see readableLogicalValue
+		c.compareValue(path, readableLogicalValue(s, expected), readableLogicalValue(s, actual))
+	}
+}
+
+func (c *comparator) compareJSON(path string, expected, actual any) {
+	switch e := expected.(type) {
+	case map[string]any:
+		a, ok := actual.(map[string]any)
+		if !ok {
+			c.results.add(path, expected, actual, "Expected an object but received %s", renderValue(actual))
+			return
+		}
+		c.compareEntries(path, e, a, c.allowUnexpectedKeys, c.compareJSON)
+	case []any:
+		a, ok := actual.([]any)
+		if !ok {
+			c.results.add(path, expected, actual, "Expected an array but received %s", renderValue(actual))
+			return
+		}
+		c.compareItems(path, e, a, c.compareJSON)
+	default:
+		switch actual.(type) {
+		case map[string]any, []any:
+			c.results.add(path, expected, actual, "Expected %s but received %s", renderValue(expected), renderValue(actual))
+			return
+		}
+		c.compareValue(path, expected, actual)
+	}
+}
+
+// compareItems compares the elements of two arrays. If the array is matched by type, its length
+// may differ and any additional actual elements are compared with the first expected element. An
+// array matched with arrayContains must contain an element matching each expected element instead.
+func (c *comparator) compareItems(path string, expected, actual []any, compare func(path string, expected, actual any)) {
+	if variants := arrayContainsVariants(c.rules.rulesAt(path)); variants != nil {
+		for i, rules := range variants {
+			if i >= len(expected) {
+				break
+			}
+			found := slices.ContainsFunc(actual, func(item any) bool {
+				return c.matchesWith(rules, func() { compare("$", expected[i], item) })
+			})
+			if !found {
+				c.results.add(path, expected[i], actual, "Expected the array to contain an element matching %s", renderValue(expected[i]))
+			}
+		}
+		return
+	}
+
+	rules := c.rules.rulesFor(path)
+	if hasTypeRule(rules) {
+		c.checkLength(path, rules, expected, actual, len(actual))
+		for i, item := range actual {
+			if i < len(expected) {
+				compare(PathIndex(path, i), expected[i], item)
+			} else if len(expected) > 0 {
+				compare(PathIndex(path, i), expected[0], item)
+			}
+		}
+		return
+	}
+	if len(expected) != len(actual) {
+		c.results.add(path, expected, actual, "Expected an array of length %d but received %d", len(expected), len(actual))
+	}
+	for i := 0; i < len(expected) && i < len(actual); i++ {
+		compare(PathIndex(path, i), expected[i], actual[i])
+	}
+}
+
+// compareEntries compares the entries of two maps or objects. Unexpected keys are allowed if the
+// map is matched by type, or allowUnexpected is set for objects, and their values are compared with
+// the first expected value. The expected
+// keys are not required if the map is matched with eachKey or eachValue, and eachKey rules are
+// applied to every actual key.
+func (c *comparator) compareEntries(path string, expected, actual map[string]any, allowUnexpected bool, compare func(path string, expected, actual any)) {
+	rules := c.rules.rulesFor(path)
+	typeMatched := hasTypeRule(rules)
+	if typeMatched {
+		c.checkLength(path, rules, expected, actual, len(actual))
+	}
+	for _, rule := range rules {
+		if rule.Type != "eachKey" {
+			continue
+		}
+		for _, key := range sortedKeys(actual) {
+			for _, nested := range nestedRules(rule) {
+				if mismatch := matchRule(nested, nil, key); mismatch != "" {
+					c.results.add(PathField(path, key), nil, key, "Key '%s': %s", key, mismatch)
+				}
+			}
+		}
+	}
+	for _, key := range sortedKeys(expected) {
+		actualValue, ok := actual[key]
+		if !ok {
+			if !hasKeyRule(rules) {
+				c.results.add(PathField(path, key), expected[key], nil, "Expected key '%s' but it was missing", key)
+			}
+			continue
+		}
+		compare(PathField(path, key), expected[key], actualValue)
+	}
+	for _, key := range sortedKeys(actual) {
+		if _, ok := expected[key]; ok {
+			continue
+		}
+		switch keys := sortedKeys(expected); {
+		case typeMatched && len(keys) > 0:
+			compare(PathField(path, key), expected[keys[0]], actual[key])
+		case !typeMatched && !allowUnexpected:
+			c.results.add(PathField(path, key), nil, actual[key], "Unexpected key '%s'", key)
+		}
+	}
+}
+
+// compareValue compares a leaf value, applying the matching rules for its path if there are any
+func (c *comparator) compareValue(path string, expected, actual any) {
+	rules := c.rules.rulesFor(path)
+	if len(rules) == 0 {
+		if !valuesEqual(expected, actual) {
+			c.results.add(path, expected, actual, "Expected %s but received %s", renderValue(expected), renderValue(actual))
+		}
+		return
+	}
+	for _, rule := range rules {
+		if mismatch := matchRule(rule, expected, actual); mismatch != "" {
+			c.results.add(path, expected, actual, "%s", mismatch)
+		}
+	}
+}
+
+// checkLength applies the min and max length constraints of the rules to a collection
+func (c *comparator) checkLength(path string, rules []*pb.MatchingRule, expected, actual any, length int) {
+	for _, rule := range rules {
+		if min, ok := ruleLength(rule, "min"); ok && length < min {
+			c.results.add(path, expected, actual, "Expected at least %d elements but received %d", min, length)
+		}
+		if max, ok := ruleLength(rule, "max"); ok && length > max {
+			c.results.add(path, expected, actual, "Expected at most %d elements but received %d", max, length)
+		}
+		if rule.Type == "notEmpty" && length == 0 {
+			c.results.add(path, expected, actual, "Expected %s to not be empty", renderValue(actual))
+		}
+	}
+}
+
+// matchesWith returns true if the comparison finds no mismatches with the given rules, such as the
+// rules of an arrayContains variant, leaving the rules and results of the comparator unchanged
+func (c *comparator) matchesWith(rules ruleSet, compare func()) bool {
+	saved := *c
+	defer func() { *c = saved }()
+	c.rules, c.results = rules, make(mismatches)
+	compare()
+	return len(c.results) == 0
+}
+
+// arrayContainsVariants returns the rules of each variant of an arrayContains rule, relative to the
+// element, or nil if there is no arrayContains rule
+func arrayContainsVariants(rules []*pb.MatchingRule) []ruleSet {
+	for _, rule := range rules {
+		if rule.Type != "arrayContains" {
+			continue
+		}
+		values := rule.GetValues().GetFields()["variants"].GetListValue().GetValues()
+		variants := make([]ruleSet, len(values))
+		for i := range variants {
+			variants[i] = make(ruleSet)
+		}
+		for _, value := range values {
+			variant := value.GetStructValue().GetFields()
+			i := int(variant["index"].GetNumberValue())
+			if i < 0 || i >= len(variants) {
+				continue
+			}
+			for path, matchers := range variant["rules"].GetStructValue().GetFields() {
+				variants[i].add(path, rulesFromValues(matchers.GetStructValue().GetFields()["matchers"].GetListValue().GetValues())...)
+			}
+		}
+		return variants
+	}
+	return nil
+}
+
+func unionBranchName(schema avro.Schema) string {
+	if schema == nil {
+		return "<unknown>"
+	}
+	return AvroTypeName(schema)
+}
+
+// valuesEqual compares two decoded leaf values
+func valuesEqual(expected, actual any) bool {
+	switch e := expected.(type) {
+	case []byte:
+		a, ok := actual.([]byte)
+		return ok && bytes.Equal(e, a)
+	case float64:
+		a, ok := actual.(float64)
+		return ok && (e == a || (math.IsNaN(e) && math.IsNaN(a)))
+	}
+	return expected == actual
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/hamba/avro/v2"
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// compatibilityModes are the compatibility levels of the Confluent Schema Registry the plugin checks
+var compatibilityModes = []string{"BACKWARD", "BACKWARD_TRANSITIVE", "FORWARD", "FORWARD_TRANSITIVE", "FULL", "FULL_TRANSITIVE"}
+
+// SchemaIncompatibility is an incompatibility between a reader and a writer schema, at a Pact path
+// of the messages
+type SchemaIncompatibility struct {
+	Path    string
+	Message string
+}
+
+// CheckAvroCompatibility lists the incompatibilities that prevent data written with the writer
+// schema from being read with the reader schema, following Avro schema resolution
+func CheckAvroCompatibility(reader, writer avro.Schema) []SchemaIncompatibility {
+	c := &compatibilityChecker{checked: make(map[string]bool)}
+	c.check("$", reader, writer)
+	return c.incompatibilities
+}
+
+type compatibilityChecker struct {
+	incompatibilities []SchemaIncompatibility
+	// checked are the pairs of named reader and writer types already checked, for recursive types
+	checked map[string]bool
+}
+
+func (c *compatibilityChecker) add(path, format string, args ...any) {
+	c.incompatibilities = append(c.incompatibilities, SchemaIncompatibility{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *compatibilityChecker) check(path string, reader, writer avro.Schema) {
+	reader = derefAvroSchema(reader)
+	writer = derefAvroSchema(writer)
+
+	// every branch that may be written must be readable
+	if union, ok := writer.(*avro.UnionSchema); ok {
+		for _, branch := range union.Types() {
+			c.check(path, reader, branch)
+		}
+		return
+	}
+	if union, ok := reader.(*avro.UnionSchema); ok {
+		for _, branch := range union.Types() {
+			if avroSchemasMatch(writer, derefAvroSchema(branch)) {
+				c.check(path, branch, writer)
+				return
+			}
+		}
+		c.add(path, "the writer type %s is not a branch of the reader union %s", AvroTypeName(writer), union.String())
+		return
+	}
+	if !avroSchemasMatch(writer, reader) {
+		c.add(path, "the type changed from %s to %s", AvroTypeName(writer), AvroTypeName(reader))
+		return
+	}
+
+	switch r := reader.(type) {
+	case *avro.RecordSchema:
+		w := writer.(*avro.RecordSchema)
+		key := r.FullName() + "|" + w.FullName()
+		if c.checked[key] {
+			return
+		}
+		c.checked[key] = true
+		for _, field := range r.Fields() {
+			fieldPath := PathField(path, field.Name())
+			writerField := findWriterField(w, field)
+			if writerField == nil {
+				if !field.HasDefault() {
+					c.add(fieldPath, "the field %s is missing from the writer schema, and the reader field has no default", field.Name())
+				}
+				continue
+			}
+			c.check(fieldPath, field.Type(), writerField.Type())
+		}
+	case *avro.EnumSchema:
+		w := writer.(*avro.EnumSchema)
+		if r.Default() != "" {
+			return
+		}
+		for _, symbol := range w.Symbols() {
+			if !slices.Contains(r.Symbols(), symbol) {
+				c.add(path, "the symbol %s of enum %s is missing from the reader schema, which has no default", symbol, r.FullName())
+			}
+		}
+	case *avro.ArraySchema:
+		c.check(path+"[*]", r.Items(), writer.(*avro.ArraySchema).Items())
+	case *avro.MapSchema:
+		c.check(PathField(path, "*"), r.Values(), writer.(*avro.MapSchema).Values())
+	}
+}
+
+// CheckCompatibilityMode checks a new schema against the existing schemas, latest last, in a
+// compatibility mode of the Confluent Schema Registry. BACKWARD checks that the new schema can read
+// data written with the latest existing schema, FORWARD that the latest existing schema can read data
+// written with the new schema, and FULL both. The TRANSITIVE modes check against all existing schemas.
+func CheckCompatibilityMode(mode string, newSchema avro.Schema, existing []avro.Schema) ([]SchemaIncompatibility, error) {
+	if !slices.Contains(compatibilityModes, mode) {
+		return nil, fmt.Errorf("compatibility mode must be one of %s, got %q", strings.Join(compatibilityModes, ", "), mode)
+	}
+	if !strings.HasSuffix(mode, "_TRANSITIVE") && len(existing) > 1 {
+		existing = existing[len(existing)-1:]
+	}
+	base := strings.TrimSuffix(mode, "_TRANSITIVE")
+
+	var incompatibilities []SchemaIncompatibility
+	for _, old := range existing {
+		if base == "BACKWARD" || base == "FULL" {
+			for _, i := range CheckAvroCompatibility(newSchema, old) {
+				i.Message = "the new schema can not read data written with an existing schema: " + i.Message
+				incompatibilities = append(incompatibilities, i)
+			}
+		}
+		if base == "FORWARD" || base == "FULL" {
+			for _, i := range CheckAvroCompatibility(old, newSchema) {
+				i.Message = "an existing schema can not read data written with the new schema: " + i.Message
+				incompatibilities = append(incompatibilities, i)
+			}
+		}
+	}
+	return incompatibilities, nil
+}
+
+// parseCompatibility parses the compatibility field of the contentsConfig, either a mode or
+// {"mode": ..., "subject": ...}. The subject defaults to the subject the writer schema was looked up
+// with. It returns the interaction configuration persisted for the check during verification.
+func parseCompatibility(value *structpb.Value, source *schemaSource) (*structpb.Struct, error) {
+	var mode, subject string
+	switch value.Kind.(type) {
+	case *structpb.Value_StringValue:
+		mode = value.GetStringValue()
+	case *structpb.Value_StructValue:
+		fields := value.GetStructValue().GetFields()
+		mode = fields["mode"].GetStringValue()
+		subject = fields["subject"].GetStringValue()
+	default:
+		return nil, fmt.Errorf("compatibility field must be a string or an object")
+	}
+	mode = strings.ToUpper(mode)
+	if !slices.Contains(compatibilityModes, mode) {
+		return nil, fmt.Errorf("compatibility mode must be one of %s, got %q", strings.Join(compatibilityModes, ", "), mode)
+	}
+	if subject == "" && source != nil {
+		subject = source.subject
+	}
+	if subject == "" {
+		return nil, fmt.Errorf("compatibility field requires a subject, as the schema was not looked up in a schema registry")
+	}
+	return &structpb.Struct{Fields: map[string]*structpb.Value{
+		"mode":    structpb.NewStringValue(mode),
+		"subject": structpb.NewStringValue(subject),
+	}}, nil
+}
+
+// checkSchemaCompatibility checks the current writer schema of the provider, the latest version of
+// the subject of the compatibility check, against the schema the consumer reads with in the pact, with
+// the provider's schema as the new schema. So BACKWARD checks that the provider's schema can read data
+// written with the consumer's schema, and FORWARD that the consumer's schema can read the provider's
+// data, which is what catches a field the provider removed without a default. The contract is the
+// consumer's schema alone, so the TRANSITIVE modes check the same as their base mode, and earlier
+// versions of the subject no consumer reads with are not checked. Incompatibilities are returned as
+// mismatches of type "schema".
+func checkSchemaCompatibility(ctx context.Context, format *avroFormat, pluginConfig *pb.PluginConfiguration) mismatches {
+	results := make(mismatches)
+	compatibility := pluginConfig.GetInteractionConfiguration().GetFields()["compatibility"].GetStructValue().GetFields()
+	mode := compatibility["mode"].GetStringValue()
+	subject := compatibility["subject"].GetStringValue()
+
+	current, err := latestSubjectVersion(ctx, subject, pluginConfig.GetPactConfiguration().GetFields())
+	if err != nil {
+		results.add("$", nil, nil, "Failed to look up the current schema of subject %s: %v", subject, err)
+		return results.withType("schema")
+	}
+	if current.Type() != "AVRO" {
+		results.add("$", nil, nil, "The current schema of subject %s is a %s schema", subject, current.Type())
+		return results.withType("schema")
+	}
+	provider, err := ParseAvroSchema(current.Schema)
+	if err != nil {
+		results.add("$", nil, nil, "Failed to parse subject %s version %d: %v", subject, current.Version, err)
+		return results.withType("schema")
+	}
+
+	consumer := format.schema
+	if format.reader != nil {
+		consumer = format.reader
+	}
+	incompatibilities, err := CheckCompatibilityMode(mode, provider, []avro.Schema{consumer})
+	if err != nil {
+		results.add("$", nil, nil, "%v", err)
+		return results.withType("schema")
+	}
+	for _, i := range incompatibilities {
+		results.add(i.Path, nil, nil, "Subject %s version %d is not %s compatible with the consumer schema: %s", subject, current.Version, mode, i.Message)
+	}
+	return results.withType("schema")
+}
+
+// latestSubjectVersion returns the latest version of a subject, from the schemaDirectory snapshot of
+// the pact or the configured schema registry
+func latestSubjectVersion(ctx context.Context, subject string, pactFields map[string]*structpb.Value) (*RegistrySchema, error) {
+	if dir := pactFields["schemaDirectory"].GetStringValue(); dir != "" {
+		snapshot, err := LoadSchemaSnapshot(dir)
+		if err != nil {
+			return nil, err
+		}
+		versions := snapshot.SubjectVersions(subject)
+		if len(versions) == 0 {
+			return nil, fmt.Errorf("subject %s has no versions", subject)
+		}
+		return versions[len(versions)-1], nil
+	}
+
+	registry, err := schemaRegistries.client("")
+	if err != nil {
+		return nil, err
+	}
+	if registry == nil {
+		return nil, fmt.Errorf("there is no schema registry or schemaDirectory to look it up")
+	}
+	return registry.SubjectVersion(ctx, subject, "latest")
+}
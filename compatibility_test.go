@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hamba/avro/v2"
+)
+
+// TestCheckCompatibilityMode tests checking a new schema against existing schemas in the compatibility
+// modes of the Confluent Schema Registry
+func TestCheckCompatibilityMode(t *testing.T) {
+	const (
+		v1 = `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}, {"name": "name", "type": "string"}]}`
+		v2 = `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}, {"name": "name", "type": "string"}, {"name": "email", "type": ["null", "string"], "default": null}]}`
+		// addedRequired adds a field without a default
+		addedRequired = `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}, {"name": "name", "type": "string"}, {"name": "age", "type": "int"}]}`
+		// removedName removes a field without a default
+		removedName = `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}, {"name": "email", "type": ["null", "string"], "default": null}]}`
+		// requiredEmail drops the default of the email field
+		requiredEmail = `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}, {"name": "name", "type": "string"}, {"name": "email", "type": ["null", "string"]}]}`
+		// changedType changes the type of a field, and adds an array of them
+		changedType = `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "long"}, {"name": "name", "type": "string"}, {"name": "tags", "type": {"type": "array", "items": "string"}, "default": []}]}`
+	)
+
+	tests := []struct {
+		name      string
+		mode      string
+		newSchema string
+		existing  []string
+		want      []string
+	}{
+		{name: "backward added optional field", mode: "BACKWARD", newSchema: v2, existing: []string{v1}},
+		{name: "backward added required field", mode: "BACKWARD", newSchema: addedRequired, existing: []string{v1}, want: []string{"$.age"}},
+		{name: "forward added required field", mode: "FORWARD", newSchema: addedRequired, existing: []string{v1}},
+		{name: "forward removed field", mode: "FORWARD", newSchema: removedName, existing: []string{v2}, want: []string{"$.name"}},
+		{name: "backward removed field", mode: "BACKWARD", newSchema: removedName, existing: []string{v2}},
+		{name: "full removed field", mode: "FULL", newSchema: removedName, existing: []string{v2}, want: []string{"$.name"}},
+		{name: "full changed type", mode: "FULL", newSchema: changedType, existing: []string{v1}, want: []string{"$.id", "$.id"}},
+		{name: "backward checks the latest schema only", mode: "BACKWARD", newSchema: requiredEmail, existing: []string{v1, v2}},
+		{name: "backward transitive checks all schemas", mode: "BACKWARD_TRANSITIVE", newSchema: requiredEmail, existing: []string{v1, v2}, want: []string{"$.email"}},
+		{name: "forward transitive checks all schemas", mode: "FORWARD_TRANSITIVE", newSchema: removedName, existing: []string{v1, v2}, want: []string{"$.name", "$.name"}},
+		{name: "full transitive checks all schemas", mode: "FULL_TRANSITIVE", newSchema: v2, existing: []string{v1, removedName}, want: []string{"$.name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newSchema, err := ParseAvroSchema(tt.newSchema)
+			if err != nil {
+				t.Fatalf("failed to parse new schema: %v", err)
+			}
+			var existing []avro.Schema
+			for _, text := range tt.existing {
+				schema, err := ParseAvroSchema(text)
+				if err != nil {
+					t.Fatalf("failed to parse existing schema: %v", err)
+				}
+				existing = append(existing, schema)
+			}
+			incompatibilities, err := CheckCompatibilityMode(tt.mode, newSchema, existing)
+			if err != nil {
+				t.Fatalf("CheckCompatibilityMode() unexpected error: %v", err)
+			}
+			var got []string
+			for _, i := range incompatibilities {
+				got = append(got, i.Path)
+			}
+			if diff := cmp.Diff(tt.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("CheckCompatibilityMode() incompatible paths (-want +got):\n%s\n%v", diff, incompatibilities)
+			}
+		})
+	}
+
+	if _, err := CheckCompatibilityMode("NONE", nil, nil); err == nil {
+		t.Error("CheckCompatibilityMode() expected error for an unsupported mode")
+	}
+}
@@ -1,10 +1,9 @@
 package consumertest
 
 import (
-	"encoding/base64"
-	"fmt"
+	"encoding/binary"
+	"encoding/json"
 	"os"
-	"strconv"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -27,8 +26,8 @@ func init() {
 }
 
 func TestConsumer(t *testing.T) {
-	schemaID := int64(16)
-	magicByte := 0
+	schemaID := uint32(16)
+	magicByte := byte(0x0)
 
 	// nolint:errcheck
 	pactlog.SetLogLevel("DEBUG")
@@ -40,7 +39,11 @@ func TestConsumer(t *testing.T) {
 		t.Fatalf("Error creating Pact Provider: %v", err)
 	}
 
-	schema, err := avro.ParseFiles("./schema.avsc")
+	schemaText, err := os.ReadFile("./schema.avsc")
+	if err != nil {
+		t.Fatalf("Error reading Avro schema: %v", err)
+	}
+	schema, err := avro.ParseBytes(schemaText)
 	if err != nil {
 		t.Fatalf("Error parsing Avro schema: %v", err)
 	}
@@ -50,9 +53,13 @@ func TestConsumer(t *testing.T) {
 		LastName:  "Doe",
 		Email:     "jane.doe@example.com",
 	}
-	data, err := avro.Marshal(schema, expected)
+	contents, err := json.Marshal(map[string]any{
+		"schemaId": schemaID,
+		"schema":   json.RawMessage(schemaText),
+		"message":  expected,
+	})
 	if err != nil {
-		t.Fatalf("Error marshalling Avro message: %v", err)
+		t.Fatalf("Error building message contents: %v", err)
 	}
 
 	err = provider.
@@ -63,7 +70,7 @@ func TestConsumer(t *testing.T) {
 			Version: PLUGIN_VERSION,
 		}).
 		WithContents(
-			fmt.Sprintf(`{"schemaId": %d, "message": "%s"}`, schemaID, base64.StdEncoding.EncodeToString(data)),
+			string(contents),
 			AVRO_SCHEMA_CONTENT_TYPE,
 		).
 		ExecuteTest(t, func(m message.AsynchronousMessage) error {
@@ -75,24 +82,16 @@ func TestConsumer(t *testing.T) {
 }
 
 // verifyKafkaMessage checks the contents of the Kafka message.
-func verifyKafkaMessage(t *testing.T, m message.AsynchronousMessage, schema avro.Schema, expected User, schemaID int64, magicByte int) error {
+func verifyKafkaMessage(t *testing.T, m message.AsynchronousMessage, schema avro.Schema, expected User, schemaID uint32, magicByte byte) error {
 	t.Helper()
 	if l := len(m.Contents); l < 5 {
 		t.Fatalf("Message contents too short, expected at least 5 bytes, got %d", l)
 	}
-	if v, err := strconv.Atoi(string(m.Contents[0])); err != nil || v != magicByte {
-		if err != nil {
-			t.Fatalf("Error parsing magic byte: %v", err)
-		} else {
-			t.Errorf("Expected first byte to be a magicByte (0x0), got %d", v)
-		}
+	if v := m.Contents[0]; v != magicByte {
+		t.Errorf("Expected first byte to be a magicByte (0x0), got 0x%X", v)
 	}
-	if v, err := strconv.ParseInt(string(m.Contents[1:5]), 16, 64); err != nil || v != schemaID {
-		if err != nil {
-			t.Fatalf("Error parsing schema ID: %v", err)
-		} else {
-			t.Errorf("Expected bytes 1-4 to be schema ID 16, got %d", v)
-		}
+	if v := binary.BigEndian.Uint32(m.Contents[1:5]); v != schemaID {
+		t.Errorf("Expected bytes 1-4 to be schema ID %d, got %d", schemaID, v)
 	}
 	var actual User
 	if err := avro.Unmarshal(schema, m.Contents[5:], &actual); err != nil {
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// javaLayoutTokens maps Java DateTimeFormatter pattern letters, as used by Pact, to Go layouts.
+// Longer tokens must come before their prefixes.
+var javaLayoutTokens = []struct {
+	java   string
+	golang string
+}{
+	{"yyyy", "2006"},
+	{"yy", "06"},
+	{"MMMM", "January"},
+	{"MMM", "Jan"},
+	{"MM", "01"},
+	{"M", "1"},
+	{"dd", "02"},
+	{"d", "2"},
+	{"EEEE", "Monday"},
+	{"EEE", "Mon"},
+	{"HH", "15"},
+	{"hh", "03"},
+	{"h", "3"},
+	{"mm", "04"},
+	{"m", "4"},
+	{"ss", "05"},
+	{"s", "5"},
+	{"SSSSSSSSS", "000000000"},
+	{"SSSSSS", "000000"},
+	{"SSS", "000"},
+	{"a", "PM"},
+	{"XXX", "Z07:00"},
+	{"XX", "Z0700"},
+	{"X", "Z07"},
+	{"ZZZ", "-0700"},
+	{"Z", "-0700"},
+	{"z", "MST"},
+}
+
+// JavaDateLayout converts a Java date time pattern, such as yyyy-MM-dd'T'HH:mm:ss, into a Go time layout
+func JavaDateLayout(pattern string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); {
+		c := pattern[i]
+		if c == '\'' {
+			end := strings.IndexByte(pattern[i+1:], '\'')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated quote in date format %q", pattern)
+			}
+			if end == 0 {
+				sb.WriteByte('\'')
+			} else {
+				sb.WriteString(pattern[i+1 : i+1+end])
+			}
+			i += end + 2
+			continue
+		}
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			matched := false
+			for _, token := range javaLayoutTokens {
+				if strings.HasPrefix(pattern[i:], token.java) {
+					sb.WriteString(token.golang)
+					i += len(token.java)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return "", fmt.Errorf("unsupported pattern letter %q in date format %q", c, pattern)
+			}
+			continue
+		}
+		sb.WriteByte(c)
+		i++
+	}
+	return sb.String(), nil
+}
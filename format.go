@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+
+	"github.com/hamba/avro/v2"
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// messageFormat serializes, deserializes and compares the messages of a Schema Registry content type.
+// Decoded messages use the generic representation of JSON values, see DecodeAvro.
+type messageFormat interface {
+	// Frame prefixes the serialized payload with the Confluent wire format header
+	Frame(schemaID uint32, payload []byte) []byte
+	// Unframe strips the Confluent wire format header, returning the schema ID and payload
+	Unframe(data []byte) (uint32, []byte, error)
+	// ParseMessage converts the JSON message of a contentsConfig, collecting the matching rules
+	// and generators declared in it
+	ParseMessage(value any) (any, ruleSet, map[string]*pb.Generator, error)
+	// Decode deserializes a payload
+	Decode(payload []byte) (any, error)
+	// Encode serializes a decoded message
+	Encode(value any) ([]byte, error)
+	// Compare compares decoded expected and actual messages. Unless unexpected keys are allowed, the
+	// fields and keys of the actual message that are not in the expected message are mismatches.
+	Compare(expected, actual any, rules ruleSet, allowUnexpectedKeys bool) mismatches
+	// ApplyGenerators replaces the values of a decoded message that have generators
+	ApplyGenerators(value any, generators map[string]*pb.Generator, testContext map[string]any) (any, error)
+	// Configuration returns the interaction configuration needed to load the format again
+	Configuration() map[string]*structpb.Value
+}
+
+// loadMessageFormat loads the message format of a content type from the interaction configuration
+// persisted by ConfigureInteraction.
+func loadMessageFormat(contentType string, config *structpb.Struct) (messageFormat, error) {
+	fields := config.GetFields()
+	switch contentType {
+	case AVRO_SCHEMA_CONTENT_TYPE:
+		schemaText := fields["schema"].GetStringValue()
+		if schemaText == "" {
+			return nil, fmt.Errorf("no avro schema found in the interaction configuration")
+		}
+		return newAvroFormat(schemaText, fields["readerSchema"].GetStringValue())
+	case PROTOBUF_SCHEMA_CONTENT_TYPE:
+		return loadProtobufFormat(fields)
+	case JSON_SCHEMA_CONTENT_TYPE:
+		schemaText := fields["schema"].GetStringValue()
+		if schemaText == "" {
+			return nil, fmt.Errorf("no json schema found in the interaction configuration")
+		}
+		return newJSONSchemaFormat(schemaText)
+	}
+	return nil, fmt.Errorf("unsupported content type %s", contentType)
+}
+
+// schemaConfigurationKeys are the keys of a format configuration that hold the schema itself
+var schemaConfigurationKeys = []string{"schema", "descriptorSet"}
+
+// persistFormat splits the configuration of a message format into the interaction configuration and
+// the schema, which is shared by the interactions of the pact. The schema is keyed by its fingerprint,
+// which the interaction configuration refers to along with the schema ID, and the subject and version
+// if the schema was looked up in a registry. Avro schemas are fingerprinted by their canonical form,
+// so that equivalent schemas differing in formatting are shared, and other schemas by their keys and
+// text in key order.
+func persistFormat(format messageFormat, source *schemaSource, schemaID uint32) (map[string]*structpb.Value, string, *structpb.Struct) {
+	interaction := map[string]*structpb.Value{"schemaId": structpb.NewNumberValue(float64(schemaID))}
+	schema := &structpb.Struct{Fields: make(map[string]*structpb.Value)}
+	hash := sha256.New()
+	config := format.Configuration()
+	for _, key := range sortedKeys(config) {
+		if slices.Contains(schemaConfigurationKeys, key) {
+			schema.Fields[key] = config[key]
+			hash.Write([]byte(key))
+			hash.Write([]byte(config[key].GetStringValue()))
+		} else {
+			interaction[key] = config[key]
+		}
+	}
+	if schemaType, ok := interaction["schemaType"]; ok {
+		schema.Fields["schemaType"] = schemaType
+	}
+
+	sum := hash.Sum(nil)
+	if avroFormat, ok := format.(*avroFormat); ok {
+		canonical := avroFormat.schema.Fingerprint()
+		sum = canonical[:]
+	}
+	fingerprint := "sha256:" + hex.EncodeToString(sum)
+	interaction["schemaFingerprint"] = structpb.NewStringValue(fingerprint)
+	if source != nil && source.subject != "" {
+		interaction["subject"] = structpb.NewStringValue(source.subject)
+		interaction["version"] = structpb.NewNumberValue(float64(source.version))
+	}
+	return interaction, fingerprint, schema
+}
+
+// loadInteractionFormat loads the message format of an interaction. The schema is found in the
+// interaction configuration, in the pact configuration by its fingerprint (see persistFormat), or by
+// looking up the schema ID of the contents in the schemaDirectory of the pact configuration (see
+// SchemaSnapshot).
+func loadInteractionFormat(contentType string, pluginConfig *pb.PluginConfiguration, content []byte) (messageFormat, error) {
+	fields := maps.Clone(pluginConfig.GetInteractionConfiguration().GetFields())
+	if fields == nil {
+		fields = make(map[string]*structpb.Value)
+	}
+	pactFields := pluginConfig.GetPactConfiguration().GetFields()
+
+	hasSchema := func() bool {
+		return slices.ContainsFunc(schemaConfigurationKeys, func(key string) bool { return fields[key] != nil })
+	}
+	if fingerprint := fields["schemaFingerprint"].GetStringValue(); fingerprint != "" && !hasSchema() {
+		schema := pactFields[fingerprint].GetStructValue()
+		if schema == nil {
+			return nil, fmt.Errorf("schema %s not found in the pact configuration", fingerprint)
+		}
+		for key, value := range schema.GetFields() {
+			if _, ok := fields[key]; !ok {
+				fields[key] = value
+			}
+		}
+	}
+	if dir := pactFields["schemaDirectory"].GetStringValue(); dir != "" && !hasSchema() {
+		schemaID, _, err := DecodeWireFormat(content)
+		if err != nil {
+			return nil, err
+		}
+		snapshot, err := LoadSchemaSnapshot(dir)
+		if err != nil {
+			return nil, err
+		}
+		registered, err := snapshot.SchemaByID(schemaID)
+		if err != nil {
+			return nil, err
+		}
+		fields["schema"] = structpb.NewStringValue(registered.Schema)
+	}
+	return loadMessageFormat(contentType, &structpb.Struct{Fields: fields})
+}
+
+// decodeContents decodes framed contents to be compared. An Avro message whose schema ID is not the
+// schema ID of the pact is resolved from its writer schema, looked up in the schemaDirectory of the
+// pact or the schema registry, to the reader schema of the consumer or else the schema of the pact.
+// Fields the provider added are kept, see avroResolver.
+func decodeContents(ctx context.Context, format messageFormat, pluginConfig *pb.PluginConfiguration, content []byte) (any, error) {
+	schemaID, payload, err := format.Unframe(content)
+	if err != nil {
+		return nil, err
+	}
+	avroFormat, ok := format.(*avroFormat)
+	if !ok {
+		return format.Decode(payload)
+	}
+
+	writer := avroFormat.schema
+	if configured, ok := pluginConfig.GetInteractionConfiguration().GetFields()["schemaId"]; ok && uint32(configured.GetNumberValue()) != schemaID {
+		looked, err := lookupWriterSchema(ctx, schemaID, pluginConfig.GetPactConfiguration().GetFields())
+		switch {
+		case err == nil:
+			writer = looked
+		case avroFormat.reader != nil:
+			return nil, err
+		default:
+			// without a reader schema the message is read with the schema of the pact, as before
+			// schemas were looked up
+			slog.Debug("Reading the message with the schema of the pact", "schemaId", schemaID, "error", err)
+		}
+	}
+	if writer == avroFormat.schema && avroFormat.reader == nil {
+		return format.Decode(payload)
+	}
+	return avroFormat.resolve(writer, payload)
+}
+
+// lookupWriterSchema looks up the Avro writer schema of a schema ID, in the schemaDirectory of the
+// pact configuration or else the configured schema registry
+func lookupWriterSchema(ctx context.Context, schemaID uint32, pactFields map[string]*structpb.Value) (avro.Schema, error) {
+	var registered *RegistrySchema
+	if dir := pactFields["schemaDirectory"].GetStringValue(); dir != "" {
+		snapshot, err := LoadSchemaSnapshot(dir)
+		if err != nil {
+			return nil, err
+		}
+		if registered, err = snapshot.SchemaByID(schemaID); err != nil {
+			return nil, err
+		}
+	} else {
+		registry, err := schemaRegistries.client("")
+		if err != nil {
+			return nil, err
+		}
+		if registry == nil {
+			return nil, fmt.Errorf("the writer schema %d is not the schema of the pact, and there is no schema registry or schemaDirectory to look it up", schemaID)
+		}
+		if registered, err = registry.SchemaByID(ctx, schemaID); err != nil {
+			return nil, err
+		}
+	}
+	if registered.Type() != "AVRO" {
+		return nil, fmt.Errorf("the writer schema %d is a %s schema", schemaID, registered.Type())
+	}
+	schema, err := ParseAvroSchema(registered.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse writer schema %d: %w", schemaID, err)
+	}
+	return schema, nil
+}
+
+// avroFormat is the message format of application/vnd.kafka.avro.v2. Messages are written with the
+// writer schema, and compared as read with the reader schema of the consumer if there is one.
+type avroFormat struct {
+	schema avro.Schema
+	reader avro.Schema
+	// schemaText and readerText are the schemas as declared, as the canonical form drops their
+	// defaults and aliases
+	schemaText string
+	readerText string
+}
+
+// newAvroFormat parses the writer schema, and the reader schema unless it is empty
+func newAvroFormat(schemaText, readerText string) (*avroFormat, error) {
+	schema, err := ParseAvroSchema(schemaText)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse avro schema: %w", err)
+	}
+	format := &avroFormat{schema: schema, schemaText: schemaText, readerText: readerText}
+	if readerText != "" {
+		if format.reader, err = ParseAvroSchema(readerText); err != nil {
+			return nil, fmt.Errorf("failed to parse avro reader schema: %w", err)
+		}
+	}
+	return format, nil
+}
+
+// resolve decodes a payload written with the writer schema, resolved to the reader schema or else
+// the schema of the pact. The fields unknown to the reader are kept, see avroResolver.
+func (f *avroFormat) resolve(writer avro.Schema, payload []byte) (any, error) {
+	value, err := DecodeAvro(writer, payload)
+	if err != nil {
+		return nil, err
+	}
+	return avroResolver{keepUnknownFields: true}.resolve("$", writer, f.readSchema(), value)
+}
+
+// readSchema returns the schema messages are compared with, the reader schema of the consumer if
+// there is one
+func (f *avroFormat) readSchema() avro.Schema {
+	if f.reader != nil {
+		return f.reader
+	}
+	return f.schema
+}
+
+func (f *avroFormat) Frame(schemaID uint32, payload []byte) []byte {
+	return EncodeWireFormat(schemaID, payload)
+}
+
+func (f *avroFormat) Unframe(data []byte) (uint32, []byte, error) {
+	return DecodeWireFormat(data)
+}
+
+func (f *avroFormat) ParseMessage(value any) (any, ruleSet, map[string]*pb.Generator, error) {
+	return ParseAvroMessage(f.schema, value)
+}
+
+func (f *avroFormat) Decode(payload []byte) (any, error) {
+	return DecodeAvro(f.schema, payload)
+}
+
+func (f *avroFormat) Encode(value any) ([]byte, error) {
+	return EncodeAvro(f.schema, value)
+}
+
+func (f *avroFormat) Compare(expected, actual any, rules ruleSet, allowUnexpectedKeys bool) mismatches {
+	return CompareAvro(f.readSchema(), expected, actual, rules, allowUnexpectedKeys)
+}
+
+func (f *avroFormat) ApplyGenerators(value any, generators map[string]*pb.Generator, testContext map[string]any) (any, error) {
+	return ApplyGenerators(f.schema, value, generators, testContext)
+}
+
+func (f *avroFormat) Configuration() map[string]*structpb.Value {
+	schemaText := f.schemaText
+	if schemaText == "" {
+		schemaText = f.schema.String()
+	}
+	config := map[string]*structpb.Value{
+		"schema": structpb.NewStringValue(schemaText),
+	}
+	if f.reader != nil {
+		config["readerSchema"] = structpb.NewStringValue(f.readerText)
+	}
+	return config
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// MAGIC_BYTE is the first byte of every message written by a Confluent
+	// Schema Registry aware serializer.
+	MAGIC_BYTE byte = 0x0
+	// WIRE_HEADER_SIZE is the size of the magic byte plus the 4-byte schema ID.
+	WIRE_HEADER_SIZE = 5
+)
+
+// EncodeWireFormat frames the serialized payload using the Confluent wire format:
+// a 0x0 magic byte, the schema ID as a 4-byte big-endian integer and then the payload.
+func EncodeWireFormat(schemaID uint32, payload []byte) []byte {
+	buf := make([]byte, WIRE_HEADER_SIZE, WIRE_HEADER_SIZE+len(payload))
+	buf[0] = MAGIC_BYTE
+	binary.BigEndian.PutUint32(buf[1:WIRE_HEADER_SIZE], schemaID)
+	return append(buf, payload...)
+}
+
+// DecodeWireFormat splits a Confluent wire format message into its schema ID and payload.
+func DecodeWireFormat(data []byte) (uint32, []byte, error) {
+	if len(data) < WIRE_HEADER_SIZE {
+		return 0, nil, fmt.Errorf("message too short, expected at least %d bytes, got %d", WIRE_HEADER_SIZE, len(data))
+	}
+	if data[0] != MAGIC_BYTE {
+		return 0, nil, fmt.Errorf("unknown magic byte 0x%X, expected 0x%X", data[0], MAGIC_BYTE)
+	}
+	schemaID := binary.BigEndian.Uint32(data[1:WIRE_HEADER_SIZE])
+	return schemaID, data[WIRE_HEADER_SIZE:], nil
+}
+
+// EncodeMessageIndexes encodes the message indexes written by the Confluent Protobuf serializer after
+// the schema ID. They locate the message type within the schema: the index of a top-level message,
+// followed by the indexes of any nested messages. Each is a zig-zag encoded varint, preceded by the
+// number of indexes, except that the common case of the first message ([0]) is written as a single 0.
+func EncodeMessageIndexes(indexes []int) []byte {
+	if len(indexes) == 1 && indexes[0] == 0 {
+		return []byte{0}
+	}
+	buf := binary.AppendVarint(nil, int64(len(indexes)))
+	for _, idx := range indexes {
+		buf = binary.AppendVarint(buf, int64(idx))
+	}
+	return buf
+}
+
+// DecodeMessageIndexes reads the message indexes at the start of a Confluent Protobuf payload,
+// returning the indexes and the remaining protobuf message.
+func DecodeMessageIndexes(data []byte) ([]int, []byte, error) {
+	count, n := binary.Varint(data)
+	if n <= 0 {
+		return nil, nil, fmt.Errorf("failed to read message index count")
+	}
+	data = data[n:]
+	if count == 0 {
+		return []int{0}, data, nil
+	}
+	if count < 0 || count > int64(len(data)) {
+		return nil, nil, fmt.Errorf("invalid message index count %d", count)
+	}
+
+	indexes := make([]int, count)
+	for i := range indexes {
+		idx, n := binary.Varint(data)
+		if n <= 0 || idx < 0 {
+			return nil, nil, fmt.Errorf("failed to read message index %d", i)
+		}
+		indexes[i] = int(idx)
+		data = data[n:]
+	}
+	return indexes, data, nil
+}
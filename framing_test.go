@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"slices"
+	"testing"
+)
+
+// TestWireFormatRoundTrip tests encoding and decoding across the full 32-bit schema ID range
+func TestWireFormatRoundTrip(t *testing.T) {
+	payload := []byte{0x48, 0x39, 0x34, 0x61, 0x66}
+	schemaIDs := []uint32{0, 1, 16, 0xFF, 0x100, 0xFFFF, 0x10000, 0xFFFFFF, 0x1000000, math.MaxInt32, math.MaxInt32 + 1, math.MaxUint32}
+
+	for _, schemaID := range schemaIDs {
+		encoded := EncodeWireFormat(schemaID, payload)
+		if len(encoded) != WIRE_HEADER_SIZE+len(payload) {
+			t.Fatalf("EncodeWireFormat(%d) length = %d, want %d", schemaID, len(encoded), WIRE_HEADER_SIZE+len(payload))
+		}
+		if encoded[0] != MAGIC_BYTE {
+			t.Errorf("EncodeWireFormat(%d) magic byte = 0x%X, want 0x%X", schemaID, encoded[0], MAGIC_BYTE)
+		}
+
+		gotID, gotPayload, err := DecodeWireFormat(encoded)
+		if err != nil {
+			t.Fatalf("DecodeWireFormat(%d) unexpected error: %v", schemaID, err)
+		}
+		if gotID != schemaID {
+			t.Errorf("DecodeWireFormat() schema ID = %d, want %d", gotID, schemaID)
+		}
+		if !bytes.Equal(gotPayload, payload) {
+			t.Errorf("DecodeWireFormat() payload = %X, want %X", gotPayload, payload)
+		}
+	}
+}
+
+// TestEncodeWireFormat tests the exact bytes written for a schema ID
+func TestEncodeWireFormat(t *testing.T) {
+	got := EncodeWireFormat(0x01020304, []byte("abc"))
+	want := []byte{0x00, 0x01, 0x02, 0x03, 0x04, 'a', 'b', 'c'}
+	if !bytes.Equal(got, want) {
+		t.Errorf("EncodeWireFormat() = %X, want %X", got, want)
+	}
+}
+
+// TestDecodeWireFormatErrors tests that malformed framing is rejected
+func TestDecodeWireFormatErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: []byte{}},
+		{name: "truncated schema ID", data: []byte{0x00, 0x00, 0x00, 0x10}},
+		{name: "wrong magic byte", data: []byte{0x01, 0x00, 0x00, 0x00, 0x10}},
+		{name: "ascii hex framing", data: []byte("00010")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := DecodeWireFormat(tt.data); err == nil {
+				t.Errorf("DecodeWireFormat(%X) expected error", tt.data)
+			}
+		})
+	}
+}
+
+// TestMessageIndexesRoundTrip tests encoding and decoding the Protobuf message indexes
+func TestMessageIndexesRoundTrip(t *testing.T) {
+	tests := []struct {
+		indexes []int
+		want    []byte
+	}{
+		{indexes: []int{0}, want: []byte{0x00}},
+		{indexes: []int{1}, want: []byte{0x02, 0x02}},
+		{indexes: []int{0, 2}, want: []byte{0x04, 0x00, 0x04}},
+		{indexes: []int{3, 1, 64}, want: []byte{0x06, 0x06, 0x02, 0x80, 0x01}},
+	}
+
+	for _, tt := range tests {
+		encoded := EncodeMessageIndexes(tt.indexes)
+		if !bytes.Equal(encoded, tt.want) {
+			t.Errorf("EncodeMessageIndexes(%v) = %X, want %X", tt.indexes, encoded, tt.want)
+		}
+		indexes, rest, err := DecodeMessageIndexes(append(encoded, 0x08, 0x01))
+		if err != nil {
+			t.Fatalf("DecodeMessageIndexes(%X) unexpected error: %v", encoded, err)
+		}
+		if !slices.Equal(indexes, tt.indexes) {
+			t.Errorf("DecodeMessageIndexes() indexes = %v, want %v", indexes, tt.indexes)
+		}
+		if !bytes.Equal(rest, []byte{0x08, 0x01}) {
+			t.Errorf("DecodeMessageIndexes() rest = %X", rest)
+		}
+	}
+
+	if _, _, err := DecodeMessageIndexes([]byte{0x04, 0x02}); err == nil {
+		t.Error("DecodeMessageIndexes() expected error for truncated indexes")
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamba/avro/v2"
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// generatorAliases maps alternative generator names to the names Pact stores in the pact file
+var generatorAliases = map[string]string{
+	"RandomUUID":             "Uuid",
+	"UUID":                   "Uuid",
+	"ProviderStateGenerator": "ProviderState",
+}
+
+// supportedGenerators are the Pact generator types the plugin can apply
+var supportedGenerators = map[string]bool{
+	"Uuid":              true,
+	"RandomInt":         true,
+	"RandomDecimal":     true,
+	"RandomHexadecimal": true,
+	"RandomString":      true,
+	"RandomBoolean":     true,
+	"Date":              true,
+	"Time":              true,
+	"DateTime":          true,
+	"ProviderState":     true,
+}
+
+var providerStateExpression = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// parseGenerators parses the generators block of the contentsConfig, which maps Pact paths to
+// generator definitions such as {"type": "RandomInt", "min": 0, "max": 100}.
+func parseGenerators(value *structpb.Value) (map[string]*pb.Generator, error) {
+	config := value.GetStructValue().GetFields()
+	if config == nil {
+		return nil, fmt.Errorf("generators field must be an object")
+	}
+
+	generators := make(map[string]*pb.Generator, len(config))
+	for path, definition := range config {
+		if _, ok := parsePath(path); !ok {
+			return nil, fmt.Errorf("generators: invalid path %q", path)
+		}
+		fields := definition.GetStructValue().GetFields()
+		generatorType := fields["type"].GetStringValue()
+		if alias, ok := generatorAliases[generatorType]; ok {
+			generatorType = alias
+		}
+		if !supportedGenerators[generatorType] {
+			return nil, fmt.Errorf("generators: unsupported generator %q for path %s", fields["type"].GetStringValue(), path)
+		}
+
+		values := make(map[string]*structpb.Value, len(fields))
+		for key, v := range fields {
+			if key != "type" {
+				values[key] = v
+			}
+		}
+		generators[path] = &pb.Generator{Type: generatorType, Values: &structpb.Struct{Fields: values}}
+	}
+	return generators, nil
+}
+
+// ApplyGenerators walks a decoded Avro value and replaces every value with a generator declared
+// on its path with a newly generated value.
+func ApplyGenerators(schema avro.Schema, value any, generators map[string]*pb.Generator, testContext map[string]any) (any, error) {
+	g, err := newGeneratorApplier(generators, testContext)
+	if err != nil {
+		return nil, err
+	}
+	return g.apply("$", schema, value)
+}
+
+// ApplyJSONGenerators is ApplyGenerators for values in the generic representation of JSON values
+// that have no schema to follow. Generated values keep the type of the value they replace.
+func ApplyJSONGenerators(value any, generators map[string]*pb.Generator, testContext map[string]any) (any, error) {
+	g, err := newGeneratorApplier(generators, testContext)
+	if err != nil {
+		return nil, err
+	}
+	return g.applyJSON("$", value)
+}
+
+func newGeneratorApplier(generators map[string]*pb.Generator, testContext map[string]any) (*generatorApplier, error) {
+	g := &generatorApplier{generators: make(map[string][]pathToken, len(generators)), definitions: generators, context: testContext}
+	for path := range generators {
+		tokens, ok := parsePath(path)
+		if !ok {
+			return nil, fmt.Errorf("invalid generator path %q", path)
+		}
+		g.generators[path] = tokens
+	}
+	return g, nil
+}
+
+type generatorApplier struct {
+	generators  map[string][]pathToken
+	definitions map[string]*pb.Generator
+	context     map[string]any
+}
+
+// generatorFor returns the generator declared for exactly the given path, preferring exact paths over wildcards
+func (g *generatorApplier) generatorFor(path string) *pb.Generator {
+	actual, ok := parsePath(path)
+	if !ok {
+		return nil
+	}
+	var (
+		best       *pb.Generator
+		bestWeight int
+	)
+	for generatorPath, tokens := range g.generators {
+		if len(tokens) != len(actual) {
+			continue
+		}
+		if weight := pathWeight(tokens, actual); weight > bestWeight {
+			best, bestWeight = g.definitions[generatorPath], weight
+		}
+	}
+	return best
+}
+
+func (g *generatorApplier) apply(path string, schema avro.Schema, value any) (any, error) {
+	if generator := g.generatorFor(path); generator != nil {
+		generated, err := g.generate(generator, generatorTarget(schema, value))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return (&jsonConverter{}).convert(schema, generated, path)
+	}
+
+	switch s := derefAvroSchema(schema).(type) {
+	case *avro.RecordSchema:
+		record, ok := value.(map[string]any)
+		if !ok {
+			return value, nil
+		}
+		result := make(map[string]any, len(record))
+		for _, field := range s.Fields() {
+			v, err := g.apply(PathField(path, field.Name()), field.Type(), record[field.Name()])
+			if err != nil {
+				return nil, err
+			}
+			result[field.Name()] = v
+		}
+		return result, nil
+	case *avro.ArraySchema:
+		items, ok := value.([]any)
+		if !ok {
+			return value, nil
+		}
+		result := make([]any, len(items))
+		for i, item := range items {
+			v, err := g.apply(PathIndex(path, i), s.Items(), item)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = v
+		}
+		return result, nil
+	case *avro.MapSchema:
+		values, ok := value.(map[string]any)
+		if !ok {
+			return value, nil
+		}
+		result := make(map[string]any, len(values))
+		for key, item := range values {
+			v, err := g.apply(PathField(path, key), s.Values(), item)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = v
+		}
+		return result, nil
+	case *avro.UnionSchema:
+		branch, branchValue, ok := unionBranch(s, value)
+		if !ok || branchValue == nil {
+			return value, nil
+		}
+		v, err := g.apply(path, branch, branchValue)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{AvroTypeName(branch): v}, nil
+	}
+	return value, nil
+}
+
+func (g *generatorApplier) applyJSON(path string, value any) (any, error) {
+	if generator := g.generatorFor(path); generator != nil {
+		target := jsonValueSchema(value)
+		generated, err := g.generate(generator, target)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return (&jsonConverter{}).convert(target, generated, path)
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, item := range v {
+			generated, err := g.applyJSON(PathField(path, key), item)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = generated
+		}
+		return result, nil
+	case []any:
+		result := make([]any, len(v))
+		for i, item := range v {
+			generated, err := g.applyJSON(PathIndex(path, i), item)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = generated
+		}
+		return result, nil
+	}
+	return value, nil
+}
+
+// jsonValueSchema returns the primitive Avro schema matching the type of a generic leaf value,
+// so that generated values keep the type of the value they replace
+func jsonValueSchema(value any) avro.Schema {
+	switch value.(type) {
+	case int64:
+		return avro.NewPrimitiveSchema(avro.Long, nil)
+	case float64:
+		return avro.NewPrimitiveSchema(avro.Double, nil)
+	case bool:
+		return avro.NewPrimitiveSchema(avro.Boolean, nil)
+	case []byte:
+		return avro.NewPrimitiveSchema(avro.Bytes, nil)
+	}
+	return avro.NewPrimitiveSchema(avro.String, nil)
+}
+
+// generatorTarget returns the schema a generated value should conform to. For unions this is the
+// branch currently selected, or the first non-null branch if the value is null.
+func generatorTarget(schema avro.Schema, value any) avro.Schema {
+	union, ok := derefAvroSchema(schema).(*avro.UnionSchema)
+	if !ok {
+		return derefAvroSchema(schema)
+	}
+	if branch, branchValue, ok := unionBranch(union, value); ok && branchValue != nil {
+		return derefAvroSchema(branch)
+	}
+	for _, branch := range union.Types() {
+		if branch.Type() != avro.Null {
+			return derefAvroSchema(branch)
+		}
+	}
+	return union
+}
+
+// logicalType returns the logical type of a schema, or an empty string
+func logicalType(schema avro.Schema) avro.LogicalType {
+	if s, ok := schema.(avro.LogicalTypeSchema); ok && s.Logical() != nil {
+		return s.Logical().Type()
+	}
+	return ""
+}
+
+// generate produces a JSON compatible value for the generator, shaped for the target schema
+func (g *generatorApplier) generate(generator *pb.Generator, target avro.Schema) (any, error) {
+	values := generator.GetValues().GetFields()
+	intValue := func(key string, def int) int {
+		if v, ok := values[key]; ok {
+			return int(v.GetNumberValue())
+		}
+		return def
+	}
+
+	switch generator.Type {
+	case "Uuid":
+		id := uuid.New()
+		switch values["format"].GetStringValue() {
+		case "simple":
+			return strings.ReplaceAll(id.String(), "-", ""), nil
+		case "upper-case-hyphenated":
+			return strings.ToUpper(id.String()), nil
+		case "URN":
+			return id.URN(), nil
+		}
+		return id.String(), nil
+	case "RandomInt":
+		lower, upper := intValue("min", 0), intValue("max", 2147483647)
+		if upper < lower {
+			return nil, fmt.Errorf("RandomInt max %d is less than min %d", upper, lower)
+		}
+		n := int64(lower) + rand.Int64N(int64(upper)-int64(lower)+1)
+		if target.Type() == avro.String {
+			return strconv.FormatInt(n, 10), nil
+		}
+		return n, nil
+	case "RandomDecimal":
+		digits := max(intValue("digits", 10), 2)
+		var sb strings.Builder
+		sb.WriteByte(byte('1' + rand.IntN(9)))
+		for range digits - 1 {
+			sb.WriteByte(byte('0' + rand.IntN(10)))
+		}
+		point := 1 + rand.IntN(digits-1)
+		decimal := sb.String()[:point] + "." + sb.String()[point:]
+		if target.Type() == avro.String {
+			return decimal, nil
+		}
+		return strconv.ParseFloat(decimal, 64)
+	case "RandomHexadecimal":
+		return randomString(intValue("digits", 10), "0123456789abcdef"), nil
+	case "RandomString":
+		return randomString(intValue("size", 10), "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"), nil
+	case "RandomBoolean":
+		return rand.IntN(2) == 1, nil
+	case "Date", "Time", "DateTime":
+		return generateDateTime(generator.Type, values["format"].GetStringValue(), target, time.Now().UTC())
+	case "ProviderState":
+		return g.fromProviderState(values["expression"].GetStringValue())
+	}
+	return nil, fmt.Errorf("unsupported generator %s", generator.Type)
+}
+
+func randomString(size int, alphabet string) string {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = alphabet[rand.IntN(len(alphabet))]
+	}
+	return string(b)
+}
+
+// generateDateTime generates the current date and/or time, either formatted for string fields
+// or as the number expected by the date, time and timestamp logical types.
+func generateDateTime(generatorType, format string, target avro.Schema, now time.Time) (any, error) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch logicalType(target) {
+	case avro.Date:
+		return int64(midnight.Unix() / 86400), nil
+	case avro.TimeMillis:
+		return now.Sub(midnight).Milliseconds(), nil
+	case avro.TimeMicros:
+		return now.Sub(midnight).Microseconds(), nil
+	case avro.TimestampMillis, avro.LocalTimestampMillis:
+		return now.UnixMilli(), nil
+	case avro.TimestampMicros, avro.LocalTimestampMicros:
+		return now.UnixMicro(), nil
+	}
+
+	if target.Type() == avro.Long {
+		return now.UnixMilli(), nil
+	}
+	if format == "" {
+		format = map[string]string{
+			"Date":     "yyyy-MM-dd",
+			"Time":     "HH:mm:ss",
+			"DateTime": "yyyy-MM-dd'T'HH:mm:ss",
+		}[generatorType]
+	}
+	layout, err := JavaDateLayout(format)
+	if err != nil {
+		return nil, err
+	}
+	return now.Format(layout), nil
+}
+
+// fromProviderState replaces the ${name} placeholders in the expression with values from the
+// provider state. An expression that is a single placeholder keeps the type of the value.
+func (g *generatorApplier) fromProviderState(expression string) (any, error) {
+	lookup := func(name string) (any, bool) {
+		if v, ok := g.context[name]; ok {
+			return v, true
+		}
+		if state, ok := g.context["providerState"].(map[string]any); ok {
+			v, ok := state[name]
+			return v, ok
+		}
+		return nil, false
+	}
+
+	if match := providerStateExpression.FindStringSubmatch(expression); match != nil && match[0] == expression {
+		v, ok := lookup(match[1])
+		if !ok {
+			return nil, fmt.Errorf("provider state value %q is not available", match[1])
+		}
+		return v, nil
+	}
+
+	var missing []string
+	result := providerStateExpression.ReplaceAllStringFunc(expression, func(placeholder string) string {
+		name := placeholder[2 : len(placeholder)-1]
+		v, ok := lookup(name)
+		if !ok {
+			missing = append(missing, name)
+			return placeholder
+		}
+		if s, ok := v.(string); ok {
+			return s
+		}
+		return string(renderValue(v))
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("provider state values %v are not available", missing)
+	}
+	return result, nil
+}
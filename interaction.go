@@ -0,0 +1,600 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// schemaSource is the text of the writer schema declared in a contentsConfig, and the ID it is
+// registered under if it was looked up in a schema registry
+type schemaSource struct {
+	text       string
+	schemaType string
+	schemaID   uint32
+	hasID      bool
+	subject    string
+	version    int
+}
+
+// loadSchemaSource loads the writer schema declared in the contentsConfig. The schema can be
+// given inline with "schema" (a JSON string or object), read from a file with "schemaFile", or
+// looked up with "schemaRegistry" ({"url": ..., "subject": ..., "version": ...}). The url defaults
+// to the registry configured for the plugin. Without any of these, the schemaId is looked up in the
+// snapshot directory given by "schemaDirectory" or the configured registry. It returns nil if no
+// schema is declared.
+func loadSchemaSource(ctx context.Context, fields map[string]*structpb.Value) (*schemaSource, error) {
+	var (
+		schemaText string
+		result     schemaSource
+		sources    int
+	)
+
+	if value, ok := fields["schema"]; ok {
+		sources++
+		switch value.Kind.(type) {
+		case *structpb.Value_StringValue:
+			schemaText = value.GetStringValue()
+		case *structpb.Value_StructValue, *structpb.Value_ListValue:
+			data, err := json.Marshal(value.AsInterface())
+			if err != nil {
+				return nil, fmt.Errorf("failed to read schema field: %w", err)
+			}
+			schemaText = string(data)
+		default:
+			return nil, fmt.Errorf("schema field must be a string or an object")
+		}
+	}
+
+	if value, ok := fields["schemaFile"]; ok {
+		sources++
+		path, err := absolutePath("schemaFile", value)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema file: %w", err)
+		}
+		schemaText = string(data)
+	}
+
+	if value, ok := fields["schemaRegistry"]; ok {
+		sources++
+		ref := value.GetStructValue().GetFields()
+		if ref == nil {
+			return nil, fmt.Errorf("schemaRegistry field must be an object")
+		}
+		subject := ref["subject"].GetStringValue()
+		if subject == "" {
+			return nil, fmt.Errorf("schemaRegistry field requires a subject")
+		}
+		registry, err := schemaRegistries.client(ref["url"].GetStringValue())
+		if err != nil {
+			return nil, err
+		}
+		if registry == nil {
+			return nil, fmt.Errorf("schemaRegistry field requires a url, as no schema registry is configured")
+		}
+		version := "latest"
+		if v, ok := ref["version"]; ok {
+			switch v.Kind.(type) {
+			case *structpb.Value_NumberValue:
+				version = fmt.Sprintf("%d", int64(v.GetNumberValue()))
+			case *structpb.Value_StringValue:
+				version = v.GetStringValue()
+			default:
+				return nil, fmt.Errorf("schemaRegistry version must be a number or a string")
+			}
+		}
+		registered, err := registry.SubjectVersion(ctx, subject, version)
+		if err != nil {
+			return nil, err
+		}
+		schemaText = registered.Schema
+		result.schemaType = registered.Type()
+		result.schemaID = registered.ID
+		result.hasID = true
+		result.subject = registered.Subject
+		result.version = registered.Version
+	}
+
+	if sources == 0 {
+		return lookupSchemaID(ctx, fields)
+	}
+	if sources > 1 {
+		return nil, fmt.Errorf("only one of schema, schemaFile or schemaRegistry may be given")
+	}
+
+	result.text = schemaText
+	return &result, nil
+}
+
+// lookupSchemaID looks up the schemaId of the contentsConfig, in the snapshot directory given by
+// "schemaDirectory" or else the configured schema registry. It returns nil if there is no schemaId
+// or nowhere to look it up.
+func lookupSchemaID(ctx context.Context, fields map[string]*structpb.Value) (*schemaSource, error) {
+	value, ok := fields["schemaId"]
+	if !ok {
+		return nil, nil
+	}
+	id, err := parseSchemaID(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var registered *RegistrySchema
+	if dir, ok := fields["schemaDirectory"]; ok {
+		path, err := absolutePath("schemaDirectory", dir)
+		if err != nil {
+			return nil, err
+		}
+		snapshot, err := LoadSchemaSnapshot(path)
+		if err != nil {
+			return nil, err
+		}
+		if registered, err = snapshot.SchemaByID(id); err != nil {
+			return nil, err
+		}
+	} else {
+		registry, err := schemaRegistries.client("")
+		if err != nil || registry == nil {
+			return nil, err
+		}
+		if registered, err = registry.SchemaByID(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	return &schemaSource{
+		text:       registered.Schema,
+		schemaType: registered.Type(),
+		schemaID:   id,
+		hasID:      true,
+		subject:    registered.Subject,
+		version:    registered.Version,
+	}, nil
+}
+
+// schemaTypes are the schema registry schema types of the content types
+var schemaTypes = map[string]string{
+	AVRO_SCHEMA_CONTENT_TYPE:     "AVRO",
+	PROTOBUF_SCHEMA_CONTENT_TYPE: "PROTOBUF",
+	JSON_SCHEMA_CONTENT_TYPE:     "JSON",
+}
+
+// newMessageFormat creates the message format of the content type from the writer schema declared
+// in the contentsConfig. It returns nil if the content type allows messages without a schema.
+func newMessageFormat(contentType string, source *schemaSource, fields map[string]*structpb.Value) (messageFormat, error) {
+	if source != nil && source.schemaType != "" && source.schemaType != schemaTypes[contentType] {
+		return nil, fmt.Errorf("the registered schema is a %s schema, which can not be used with content type %s", source.schemaType, contentType)
+	}
+	if contentType != AVRO_SCHEMA_CONTENT_TYPE && (fields["readerSchema"] != nil || fields["readerSchemaFile"] != nil) {
+		return nil, fmt.Errorf("a reader schema can only be used with content type %s", AVRO_SCHEMA_CONTENT_TYPE)
+	}
+	switch contentType {
+	case AVRO_SCHEMA_CONTENT_TYPE:
+		if source == nil {
+			return nil, nil
+		}
+		readerText, err := loadReaderSchema(fields)
+		if err != nil {
+			return nil, err
+		}
+		return newAvroFormat(source.text, readerText)
+	case PROTOBUF_SCHEMA_CONTENT_TYPE:
+		return newProtobufFormat(source, fields)
+	case JSON_SCHEMA_CONTENT_TYPE:
+		if source == nil {
+			return nil, fmt.Errorf("a schema, schemaFile or schemaRegistry field is required")
+		}
+		return newJSONSchemaFormat(source.text)
+	}
+	return nil, fmt.Errorf("unsupported content type %s", contentType)
+}
+
+// loadReaderSchema loads the Avro reader schema of the consumer, given inline with "readerSchema" (a
+// JSON string or object) or read from a file with "readerSchemaFile". It returns an empty string if
+// the consumer reads with the writer schema.
+func loadReaderSchema(fields map[string]*structpb.Value) (string, error) {
+	value, inline := fields["readerSchema"]
+	file, hasFile := fields["readerSchemaFile"]
+	switch {
+	case inline && hasFile:
+		return "", fmt.Errorf("only one of readerSchema or readerSchemaFile may be given")
+	case inline:
+		switch value.Kind.(type) {
+		case *structpb.Value_StringValue:
+			return value.GetStringValue(), nil
+		case *structpb.Value_StructValue, *structpb.Value_ListValue:
+			data, err := json.Marshal(value.AsInterface())
+			if err != nil {
+				return "", fmt.Errorf("failed to read readerSchema field: %w", err)
+			}
+			return string(data), nil
+		}
+		return "", fmt.Errorf("readerSchema field must be a string or an object")
+	case hasFile:
+		path, err := absolutePath("readerSchemaFile", file)
+		if err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read reader schema file: %w", err)
+		}
+		return string(data), nil
+	}
+	return "", nil
+}
+
+// absolutePath returns the path of a file or directory field of the contentsConfig. Relative paths
+// are rejected, as the plugin runs in the plugin directory rather than the directory of the tests.
+func absolutePath(name string, value *structpb.Value) (string, error) {
+	if _, ok := value.Kind.(*structpb.Value_StringValue); !ok {
+		return "", fmt.Errorf("%s field must be a string", name)
+	}
+	path := value.GetStringValue()
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("%s field must be an absolute path, as the plugin does not run in the directory of the tests, got %q", name, path)
+	}
+	return path, nil
+}
+
+// parseSchemaID parses the schemaId field of the contentsConfig
+func parseSchemaID(value *structpb.Value) (uint32, error) {
+	if _, ok := value.Kind.(*structpb.Value_NumberValue); !ok {
+		return 0, fmt.Errorf("schemaId field must be a number")
+	}
+	id := value.GetNumberValue()
+	if id < 0 || id > math.MaxUint32 || id != math.Trunc(id) {
+		return 0, fmt.Errorf("schemaId field must be an integer between 0 and %d", uint32(math.MaxUint32))
+	}
+	return uint32(id), nil
+}
+
+// encodedMessage is a serialized message along with the matching rules and generators declared in it
+type encodedMessage struct {
+	data       []byte
+	rules      ruleSet
+	generators map[string]*pb.Generator
+}
+
+// encodeMessage serializes the message field of the contentsConfig. An encoded message is a string
+// holding the already serialized, base64 encoded message, which must be readable with the writer
+// schema if there is one. Without a writer schema, the message must be encoded. Otherwise the
+// message is a JSON value, strings included, encoded with the writer schema, collecting the matching
+// rules and generators declared in it.
+func encodeMessage(value *structpb.Value, encoded bool, format messageFormat) (*encodedMessage, error) {
+	if encoded || format == nil {
+		if _, ok := value.Kind.(*structpb.Value_StringValue); !ok {
+			if format == nil {
+				return nil, fmt.Errorf("a schema, schemaFile or schemaRegistry field is required to encode a JSON message")
+			}
+			return nil, fmt.Errorf("message field must be a base64 encoded string when encoded is true")
+		}
+		message, err := base64.StdEncoding.DecodeString(value.GetStringValue())
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode base64 message: %w", err)
+		}
+		if format != nil {
+			if _, err := format.Decode(message); err != nil {
+				return nil, fmt.Errorf("encoded message does not match the schema: %w", err)
+			}
+		}
+		return &encodedMessage{data: message}, nil
+	}
+
+	native, rules, generators, err := format.ParseMessage(value.AsInterface())
+	if err != nil {
+		return nil, fmt.Errorf("message does not match the schema: %w", err)
+	}
+	message, err := format.Encode(native)
+	if err != nil {
+		return nil, err
+	}
+	return &encodedMessage{data: message, rules: rules, generators: generators}, nil
+}
+
+// configurePart configures a part of a Kafka record, the message or its key, from its contentsConfig.
+// The schema shared by the pact is added to pactFields.
+func configurePart(ctx context.Context, contentType, partName string, fields, pactFields map[string]*structpb.Value) (*pb.InteractionResponse, error) {
+	// load the writer schema, either inline, from a file or from a schema registry
+	source, err := loadSchemaSource(ctx, fields)
+	if err != nil {
+		return nil, err
+	}
+	format, err := newMessageFormat(contentType, source, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// parse the schemaId. It may be omitted if the schema was looked up in a schema registry
+	var id uint32
+	if schemaID, ok := fields["schemaId"]; ok {
+		if id, err = parseSchemaID(schemaID); err != nil {
+			return nil, err
+		}
+	} else if source != nil && source.hasID {
+		id = source.schemaID
+	} else {
+		return nil, fmt.Errorf("schemaId field is required")
+	}
+	slog.Info("schemaId", "part", partName, "value", id)
+
+	// encode the message, either given as JSON or already serialized and base64 encoded
+	messageValue, ok := fields["message"]
+	if !ok {
+		return nil, fmt.Errorf("message field is required")
+	}
+	encoded := false
+	if value, ok := fields["encoded"]; ok {
+		if _, isBool := value.Kind.(*structpb.Value_BoolValue); !isBool {
+			return nil, fmt.Errorf("encoded field must be a boolean")
+		}
+		encoded = value.GetBoolValue()
+	}
+	message, err := encodeMessage(messageValue, encoded, format)
+	if err != nil {
+		return nil, err
+	}
+
+	// the consumer must be able to read its own message with its reader schema
+	if avroFormat, ok := format.(*avroFormat); ok && avroFormat.reader != nil {
+		if _, err := avroFormat.resolve(avroFormat.schema, message.data); err != nil {
+			return nil, fmt.Errorf("the message can not be read with the reader schema: %w", err)
+		}
+	}
+
+	// generators may also be declared by path, in addition to fromProviderState in the message
+	if generatorsValue, ok := fields["generators"]; ok {
+		if format == nil {
+			return nil, fmt.Errorf("a schema, schemaFile or schemaRegistry field is required to use generators")
+		}
+		generators, err := parseGenerators(generatorsValue)
+		if err != nil {
+			return nil, err
+		}
+		if message.generators == nil {
+			message.generators = make(map[string]*pb.Generator, len(generators))
+		}
+		maps.Copy(message.generators, generators)
+	}
+
+	// the writer schema is used to decode the message during CompareContents and GenerateContent. It is
+	// stored once in the pact configuration, and referred to by its fingerprint from the interaction.
+	var (
+		pluginConfiguration *pb.PluginConfiguration
+		content             []byte
+	)
+	if format == nil && fields["compatibility"] != nil {
+		return nil, fmt.Errorf("a schema, schemaFile or schemaRegistry field is required to check compatibility")
+	}
+	if format != nil {
+		interactionFields, fingerprint, schema := persistFormat(format, source, id)
+		// the compatibility of the provider's current writer schema is checked during verification
+		if value, ok := fields["compatibility"]; ok {
+			if _, isAvro := format.(*avroFormat); !isAvro {
+				return nil, fmt.Errorf("compatibility checks are only supported for content type %s", AVRO_SCHEMA_CONTENT_TYPE)
+			}
+			compatibility, err := parseCompatibility(value, source)
+			if err != nil {
+				return nil, err
+			}
+			interactionFields["compatibility"] = structpb.NewStructValue(compatibility)
+		}
+		pluginConfiguration = &pb.PluginConfiguration{
+			InteractionConfiguration: &structpb.Struct{Fields: interactionFields},
+		}
+		pactFields[fingerprint] = structpb.NewStructValue(schema)
+		content = format.Frame(id, message.data)
+	} else {
+		content = EncodeWireFormat(id, message.data)
+	}
+
+	// the decoded message is rendered for the Pact Broker, as CommonMark unless markupType is HTML
+	markupType := pb.InteractionResponse_COMMON_MARK
+	if value, ok := fields["markupType"]; ok {
+		if markupType, err = parseMarkupType(value.GetStringValue()); err != nil {
+			return nil, err
+		}
+	}
+	markup, err := newMessageMarkup(fields["topic"].GetStringValue(), id, source, format, message)
+	if err != nil {
+		return nil, err
+	}
+	interactionMarkup, err := markup.Render(markupType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.InteractionResponse{
+		Contents: &pb.Body{
+			ContentType: contentType,
+			Content:     wrapperspb.Bytes(content),
+		},
+		Rules:                 message.rules,
+		Generators:            message.generators,
+		PluginConfiguration:   pluginConfiguration,
+		InteractionMarkup:     interactionMarkup,
+		InteractionMarkupType: markupType,
+		PartName:              partName,
+	}, nil
+}
+
+// isTombstone returns true if the contentsConfig configures a tombstone, a record with a null value
+func isTombstone(fields map[string]*structpb.Value) (bool, error) {
+	value, ok := fields["tombstone"]
+	if !ok {
+		return false, nil
+	}
+	if _, isBool := value.Kind.(*structpb.Value_BoolValue); !isBool {
+		return false, fmt.Errorf("tombstone field must be a boolean")
+	}
+	return value.GetBoolValue(), nil
+}
+
+// configureTombstone configures the message part of a tombstone. Its body is empty, without the
+// schema framing, and the interaction configuration records that the provider must send a null value.
+func configureTombstone(contentType string, fields map[string]*structpb.Value) (*pb.InteractionResponse, error) {
+	for _, field := range []string{"message", "generators"} {
+		if _, ok := fields[field]; ok {
+			return nil, fmt.Errorf("%s field can not be used with a tombstone", field)
+		}
+	}
+
+	markupType := pb.InteractionResponse_COMMON_MARK
+	if value, ok := fields["markupType"]; ok {
+		var err error
+		if markupType, err = parseMarkupType(value.GetStringValue()); err != nil {
+			return nil, err
+		}
+	}
+	markup := &messageMarkup{Topic: fields["topic"].GetStringValue(), Tombstone: true}
+	interactionMarkup, err := markup.Render(markupType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.InteractionResponse{
+		Contents: &pb.Body{
+			ContentType: contentType,
+			Content:     wrapperspb.Bytes(nil),
+		},
+		PluginConfiguration: &pb.PluginConfiguration{
+			InteractionConfiguration: &structpb.Struct{Fields: map[string]*structpb.Value{
+				"tombstone": structpb.NewBoolValue(true),
+			}},
+		},
+		InteractionMarkup:     interactionMarkup,
+		InteractionMarkupType: markupType,
+		PartName:              "message",
+	}, nil
+}
+
+// STRING_KEY_CONTENT_TYPE is the content type of keys written with the Kafka StringSerializer
+const STRING_KEY_CONTENT_TYPE = "text/plain;charset=utf-8"
+
+// keySerializers are the content types of the serializers a record key can be written with
+var keySerializers = map[string]string{
+	"avro":     AVRO_SCHEMA_CONTENT_TYPE,
+	"protobuf": PROTOBUF_SCHEMA_CONTENT_TYPE,
+	"json":     JSON_SCHEMA_CONTENT_TYPE,
+	"string":   STRING_KEY_CONTENT_TYPE,
+}
+
+// configureKey configures the key part of a Kafka record from the key block of the contentsConfig.
+// The serializer defaults to the one of the message, and the topic, markupType and schemaDirectory
+// of the message apply to the key unless it has its own.
+func configureKey(ctx context.Context, contentType string, fields, keyFields, pactFields map[string]*structpb.Value) (*pb.InteractionResponse, error) {
+	keyFields = maps.Clone(keyFields)
+	for _, inherited := range []string{"topic", "markupType", "schemaDirectory"} {
+		if _, ok := keyFields[inherited]; !ok && fields[inherited] != nil {
+			keyFields[inherited] = fields[inherited]
+		}
+	}
+
+	keyContentType := contentType
+	if serializer, ok := keyFields["serializer"]; ok {
+		if keyContentType, ok = keySerializers[serializer.GetStringValue()]; !ok {
+			return nil, fmt.Errorf("serializer must be one of avro, protobuf, json or string, got %q", serializer.GetStringValue())
+		}
+	}
+	if keyContentType != STRING_KEY_CONTENT_TYPE {
+		return configurePart(ctx, keyContentType, "key", keyFields, pactFields)
+	}
+
+	// a string key is written as is, without a schema
+	value, ok := keyFields["message"]
+	if _, isString := value.GetKind().(*structpb.Value_StringValue); !ok || !isString {
+		return nil, fmt.Errorf("message field must be a string for the string serializer")
+	}
+	extractor := &definitionExtractor{rules: make(ruleSet), generators: make(map[string]*pb.Generator)}
+	key, err := extractor.extract("$", value.GetStringValue())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.InteractionResponse{
+		Contents: &pb.Body{
+			ContentType: keyContentType,
+			Content:     wrapperspb.Bytes([]byte(fmt.Sprint(key))),
+		},
+		Rules:      extractor.rules,
+		Generators: extractor.generators,
+		PartName:   "key",
+	}, nil
+}
+
+// persistKey stores the key part of a record in the interaction configuration of its message part,
+// as only the message of an asynchronous message interaction is stored in the pact. The key is
+// stored with its content type, its base64 encoded contents, its matching rules in their pact
+// representation and its own interaction configuration, such as the fingerprint of its schema.
+func persistKey(message, key *pb.InteractionResponse) error {
+	rules := make(map[string]any, len(key.Rules))
+	for path, rule := range key.Rules {
+		rules[path] = ruleValues(rule.GetRule())
+	}
+	fields := map[string]any{
+		"contentType": key.Contents.ContentType,
+		"content":     base64.StdEncoding.EncodeToString(key.Contents.Content.GetValue()),
+		"rules":       rules,
+	}
+	if config := key.GetPluginConfiguration().GetInteractionConfiguration(); config != nil {
+		fields["configuration"] = config.AsMap()
+	}
+	value, err := structpb.NewValue(fields)
+	if err != nil {
+		return fmt.Errorf("failed to persist the key: %w", err)
+	}
+
+	if message.PluginConfiguration == nil {
+		message.PluginConfiguration = &pb.PluginConfiguration{}
+	}
+	if message.PluginConfiguration.InteractionConfiguration == nil {
+		message.PluginConfiguration.InteractionConfiguration = &structpb.Struct{Fields: make(map[string]*structpb.Value)}
+	}
+	message.PluginConfiguration.InteractionConfiguration.Fields["key"] = value
+	return nil
+}
+
+// recordKey is the expected record key of an interaction, as persisted by persistKey
+type recordKey struct {
+	body   *pb.Body
+	rules  ruleSet
+	config *pb.PluginConfiguration
+}
+
+// loadRecordKey loads the expected record key persisted in the plugin configuration of an
+// interaction. The key is compared with the interaction configuration of the key and the pact
+// configuration of the interaction. It returns nil if the interaction has no key.
+func loadRecordKey(config *pb.PluginConfiguration) (*recordKey, error) {
+	fields := config.GetInteractionConfiguration().GetFields()["key"].GetStructValue().GetFields()
+	if fields == nil {
+		return nil, nil
+	}
+	content, err := base64.StdEncoding.DecodeString(fields["content"].GetStringValue())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the persisted key: %w", err)
+	}
+	rules := make(ruleSet)
+	for path, values := range fields["rules"].GetStructValue().GetFields() {
+		rules.add(path, rulesFromValues(values.GetListValue().GetValues())...)
+	}
+	return &recordKey{
+		body:  &pb.Body{ContentType: fields["contentType"].GetStringValue(), Content: wrapperspb.Bytes(content)},
+		rules: rules,
+		config: &pb.PluginConfiguration{
+			InteractionConfiguration: fields["configuration"].GetStructValue(),
+			PactConfiguration:        config.GetPactConfiguration(),
+		},
+	}, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// JSON_SCHEMA_URL is the location the JSON Schema is compiled under
+const JSON_SCHEMA_URL = "file:///schema.json"
+
+// ParseJSONSchema compiles a JSON Schema. References to other documents are not resolved.
+func ParseJSONSchema(schema string) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(strings.NewReader(schema))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse json schema: %w", err)
+	}
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(JSON_SCHEMA_URL, doc); err != nil {
+		return nil, fmt.Errorf("failed to load json schema: %w", err)
+	}
+	compiled, err := compiler.Compile(JSON_SCHEMA_URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile json schema: %w", err)
+	}
+	return compiled, nil
+}
+
+// jsonSchemaFormat is the message format of application/vnd.kafka.json.v2. The payload is the
+// UTF-8 JSON message.
+type jsonSchemaFormat struct {
+	schema *jsonschema.Schema
+	text   string
+}
+
+func newJSONSchemaFormat(schemaText string) (*jsonSchemaFormat, error) {
+	schema, err := ParseJSONSchema(schemaText)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonSchemaFormat{schema: schema, text: schemaText}, nil
+}
+
+func (f *jsonSchemaFormat) Frame(schemaID uint32, payload []byte) []byte {
+	return EncodeWireFormat(schemaID, payload)
+}
+
+func (f *jsonSchemaFormat) Unframe(data []byte) (uint32, []byte, error) {
+	return DecodeWireFormat(data)
+}
+
+func (f *jsonSchemaFormat) ParseMessage(value any) (any, ruleSet, map[string]*pb.Generator, error) {
+	extractor := &definitionExtractor{rules: make(ruleSet), generators: make(map[string]*pb.Generator)}
+	cleaned, err := extractor.json("$", value)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// round trip the message so that numbers use the decoded representation
+	data, err := f.Encode(cleaned)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	decoded, err := f.Decode(data)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if violations := f.validate(decoded); len(violations) > 0 {
+		return nil, nil, nil, fmt.Errorf("%s", strings.Join(violations.messages(), "; "))
+	}
+	return decoded, extractor.rules, extractor.generators, nil
+}
+
+// Decode parses a JSON payload. Integers are decoded as int64 and other numbers as float64.
+func (f *jsonSchemaFormat) Decode(payload []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("failed to decode json message: %w", err)
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("failed to decode json message: unexpected data after the message")
+	}
+	return jsonNumbers(value), nil
+}
+
+func (f *jsonSchemaFormat) Encode(value any) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+// Compare validates the actual message against the schema, reporting each violation at the path
+// of the invalid value, and then compares it with the expected message
+func (f *jsonSchemaFormat) Compare(expected, actual any, rules ruleSet, allowUnexpectedKeys bool) mismatches {
+	results := CompareJSON(expected, actual, rules, allowUnexpectedKeys)
+	for path, violations := range f.validate(actual) {
+		for _, violation := range violations {
+			results.add(path, nil, nil, "Message does not match the JSON Schema: %s", violation)
+		}
+	}
+	return results
+}
+
+func (f *jsonSchemaFormat) ApplyGenerators(value any, generators map[string]*pb.Generator, testContext map[string]any) (any, error) {
+	return ApplyJSONGenerators(value, generators, testContext)
+}
+
+func (f *jsonSchemaFormat) Configuration() map[string]*structpb.Value {
+	return map[string]*structpb.Value{
+		"schemaType": structpb.NewStringValue("JSON"),
+		"schema":     structpb.NewStringValue(f.text),
+	}
+}
+
+// schemaViolations are the JSON Schema validation errors of a message, keyed by path
+type schemaViolations map[string][]string
+
+func (v schemaViolations) messages() []string {
+	var result []string
+	for _, path := range sortedKeys(v) {
+		for _, violation := range v[path] {
+			result = append(result, fmt.Sprintf("%s: %s", path, violation))
+		}
+	}
+	return result
+}
+
+// validate validates a decoded message against the schema
+func (f *jsonSchemaFormat) validate(value any) schemaViolations {
+	err := f.schema.Validate(value)
+	if err == nil {
+		return nil
+	}
+	violations := make(schemaViolations)
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		violations["$"] = append(violations["$"], err.Error())
+		return violations
+	}
+	printer := message.NewPrinter(language.English)
+	var collect func(e *jsonschema.ValidationError)
+	collect = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			path := instancePath(value, e.InstanceLocation)
+			violations[path] = append(violations[path], e.ErrorKind.LocalizedString(printer))
+		}
+		for _, cause := range e.Causes {
+			collect(cause)
+		}
+	}
+	collect(validationErr)
+	return violations
+}
+
+// instancePath converts the JSON pointer tokens locating a value within a message into a path
+func instancePath(value any, tokens []string) string {
+	path := "$"
+	for _, token := range tokens {
+		switch v := value.(type) {
+		case []any:
+			idx, err := strconv.Atoi(token)
+			if err != nil || idx < 0 || idx >= len(v) {
+				return PathField(path, token)
+			}
+			path, value = PathIndex(path, idx), v[idx]
+		case map[string]any:
+			path, value = PathField(path, token), v[token]
+		default:
+			path = PathField(path, token)
+		}
+	}
+	return path
+}
+
+// jsonNumbers converts the json.Number values of a decoded JSON value into int64 or float64
+func jsonNumbers(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, item := range v {
+			v[key] = jsonNumbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = jsonNumbers(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hamba/avro/v2"
+)
+
+// REGISTRY_MANIFEST is the manifest of a local registry directory, assigning the IDs, subjects and
+// versions of the schema files
+const REGISTRY_MANIFEST = "registry.json"
+
+// schemaFileTypes are the schema types of the schema file extensions
+var schemaFileTypes = map[string]string{
+	".avsc":  "AVRO",
+	".proto": "PROTOBUF",
+	".json":  "JSON",
+}
+
+// registryManifestEntry is an entry of the registry manifest. The schema is either read from file,
+// relative to the manifest, or given inline.
+type registryManifestEntry struct {
+	ID         uint32          `json:"id"`
+	Subject    string          `json:"subject"`
+	Version    int             `json:"version"`
+	SchemaType string          `json:"schemaType,omitempty"`
+	File       string          `json:"file,omitempty"`
+	Schema     json.RawMessage `json:"schema,omitempty"`
+}
+
+// LocalRegistry is a schema registry stand-in serving the schemas of a directory
+type LocalRegistry struct {
+	schemas []*RegistrySchema
+	// compatibilityLevel is the global compatibility level, and subjectLevels the levels of subjects
+	compatibilityLevel string
+	subjectLevels      map[string]string
+}
+
+// registryManifest is the content of a registry.json manifest
+type registryManifest struct {
+	schemas            []*RegistrySchema
+	compatibilityLevel string
+	subjectLevels      map[string]string
+}
+
+// LoadLocalRegistry loads the schemas listed in the registry.json manifest of a directory:
+//
+//	{"schemas": [{"id": 16, "subject": "users-value", "version": 1, "file": "user.avsc"}]}
+//
+// The schema type is taken from the file extension (.avsc, .proto or .json) unless schemaType is
+// given. The version defaults to 1. The compatibility level of compatibility checks is given with
+// compatibilityLevel, BACKWARD by default, and per subject with subjectCompatibilityLevels.
+func LoadLocalRegistry(dir string) (*LocalRegistry, error) {
+	manifest, err := readRegistryManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := &LocalRegistry{compatibilityLevel: manifest.compatibilityLevel, subjectLevels: manifest.subjectLevels}
+	for _, schema := range manifest.schemas {
+		if schema.Subject == "" {
+			return nil, fmt.Errorf("registry manifest entry %d: a subject is required", schema.ID)
+		}
+		if err := registry.add(schema); err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// readRegistryManifest reads the schemas of the registry.json manifest of a directory. Besides a
+// list of schemas, the manifest may be an index of the schemas by ID:
+//
+//	{"16": {"subject": "users-value", "version": 1, "file": "user.avsc"}}
+//
+// Only a list of schemas may have compatibility levels.
+func readRegistryManifest(dir string) (*registryManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, REGISTRY_MANIFEST))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry manifest: %w", err)
+	}
+	var manifest struct {
+		Schemas                    []registryManifestEntry `json:"schemas"`
+		CompatibilityLevel         string                  `json:"compatibilityLevel"`
+		SubjectCompatibilityLevels map[string]string       `json:"subjectCompatibilityLevels"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse registry manifest: %w", err)
+	}
+	if manifest.Schemas == nil {
+		var index map[string]registryManifestEntry
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, fmt.Errorf("failed to parse registry manifest: %w", err)
+		}
+		for _, key := range sortedKeys(index) {
+			id, err := strconv.ParseUint(key, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("registry manifest key %s is not a schema ID", key)
+			}
+			entry := index[key]
+			entry.ID = uint32(id)
+			manifest.Schemas = append(manifest.Schemas, entry)
+		}
+	}
+
+	result := &registryManifest{
+		schemas:            make([]*RegistrySchema, 0, len(manifest.Schemas)),
+		compatibilityLevel: "BACKWARD",
+		subjectLevels:      make(map[string]string),
+	}
+	if manifest.CompatibilityLevel != "" {
+		result.compatibilityLevel = strings.ToUpper(manifest.CompatibilityLevel)
+	}
+	if !slices.Contains(compatibilityModes, result.compatibilityLevel) {
+		return nil, fmt.Errorf("registry manifest compatibilityLevel must be one of %s, got %q", strings.Join(compatibilityModes, ", "), manifest.CompatibilityLevel)
+	}
+	for subject, level := range manifest.SubjectCompatibilityLevels {
+		result.subjectLevels[subject] = strings.ToUpper(level)
+		if !slices.Contains(compatibilityModes, result.subjectLevels[subject]) {
+			return nil, fmt.Errorf("registry manifest compatibility level of subject %s must be one of %s, got %q", subject, strings.Join(compatibilityModes, ", "), level)
+		}
+	}
+	for _, entry := range manifest.Schemas {
+		schema, err := entry.load(dir)
+		if err != nil {
+			return nil, fmt.Errorf("registry manifest entry %d: %w", entry.ID, err)
+		}
+		result.schemas = append(result.schemas, schema)
+	}
+	return result, nil
+}
+
+func (e registryManifestEntry) load(dir string) (*RegistrySchema, error) {
+	schema := &RegistrySchema{ID: e.ID, Subject: e.Subject, Version: e.Version, SchemaType: e.SchemaType}
+	if schema.Version == 0 {
+		schema.Version = 1
+	}
+
+	switch {
+	case e.File != "" && len(e.Schema) > 0:
+		return nil, fmt.Errorf("only one of file or schema may be given")
+	case e.File != "":
+		data, err := os.ReadFile(filepath.Join(dir, e.File))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema file: %w", err)
+		}
+		schema.Schema = string(data)
+		if schema.SchemaType == "" {
+			schema.SchemaType = schemaFileTypes[strings.ToLower(filepath.Ext(e.File))]
+		}
+		if schema.SchemaType == "" {
+			return nil, fmt.Errorf("unknown schema file type %s", e.File)
+		}
+	case len(e.Schema) > 0:
+		// the schema is either a JSON string, such as .proto source, or a JSON schema document
+		if err := json.Unmarshal(e.Schema, &schema.Schema); err != nil {
+			schema.Schema = string(e.Schema)
+		}
+		if schema.SchemaType == "" {
+			schema.SchemaType = "AVRO"
+		}
+	default:
+		return nil, fmt.Errorf("a file or schema is required")
+	}
+
+	// the registry omits the schema type of Avro schemas
+	if schema.SchemaType == "AVRO" {
+		schema.SchemaType = ""
+	}
+	return schema, nil
+}
+
+func (r *LocalRegistry) add(schema *RegistrySchema) error {
+	for _, existing := range r.schemas {
+		if existing.Subject == schema.Subject && existing.Version == schema.Version {
+			return fmt.Errorf("subject %s version %d is registered more than once", schema.Subject, schema.Version)
+		}
+		if existing.ID == schema.ID && existing.Schema != schema.Schema {
+			return fmt.Errorf("schema ID %d is assigned to different schemas", schema.ID)
+		}
+	}
+	r.schemas = append(r.schemas, schema)
+	return nil
+}
+
+// byID returns a schema by ID
+func (r *LocalRegistry) byID(id uint32) *RegistrySchema {
+	for _, schema := range r.schemas {
+		if schema.ID == id {
+			return schema
+		}
+	}
+	return nil
+}
+
+// subjectCompatibilityLevel returns the compatibility level of a subject, the global level unless
+// the subject has its own
+func (r *LocalRegistry) subjectCompatibilityLevel(subject string) string {
+	if level, ok := r.subjectLevels[subject]; ok {
+		return level
+	}
+	return r.compatibilityLevel
+}
+
+// versions returns the versions of a subject in order
+func (r *LocalRegistry) versions(subject string) []*RegistrySchema {
+	var versions []*RegistrySchema
+	for _, schema := range r.schemas {
+		if schema.Subject == subject {
+			versions = append(versions, schema)
+		}
+	}
+	slices.SortFunc(versions, func(a, b *RegistrySchema) int { return a.Version - b.Version })
+	return versions
+}
+
+// Handler returns the handler serving the read only endpoints of the Confluent Schema Registry REST API
+func (r *LocalRegistry) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /schemas/ids/{id}", r.handleSchemaByID)
+	mux.HandleFunc("GET /schemas/ids/{id}/versions", r.handleSchemaVersions)
+	mux.HandleFunc("GET /subjects", r.handleSubjects)
+	mux.HandleFunc("GET /subjects/{subject}/versions", r.handleSubjectVersions)
+	mux.HandleFunc("GET /subjects/{subject}/versions/{version}", r.handleSubjectVersion)
+	mux.HandleFunc("GET /subjects/{subject}/versions/{version}/schema", r.handleSubjectVersionSchema)
+	mux.HandleFunc("POST /subjects/{subject}", r.handleLookupSchema)
+	mux.HandleFunc("POST /compatibility/subjects/{subject}/versions", r.handleCompatibility)
+	mux.HandleFunc("POST /compatibility/subjects/{subject}/versions/{version}", r.handleCompatibility)
+	mux.HandleFunc("GET /config", func(w http.ResponseWriter, _ *http.Request) {
+		writeRegistryResponse(w, map[string]string{"compatibilityLevel": r.compatibilityLevel})
+	})
+	mux.HandleFunc("GET /config/{subject}", func(w http.ResponseWriter, req *http.Request) {
+		writeRegistryResponse(w, map[string]string{"compatibilityLevel": r.subjectCompatibilityLevel(req.PathValue("subject"))})
+	})
+	return mux
+}
+
+func writeRegistryResponse(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", REGISTRY_CONTENT_TYPE)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("failed to write schema registry response", "error", err)
+	}
+}
+
+func writeRegistryError(w http.ResponseWriter, statusCode, errorCode int, format string, args ...any) {
+	w.Header().Set("Content-Type", REGISTRY_CONTENT_TYPE)
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(RegistryError{ErrorCode: errorCode, Message: fmt.Sprintf(format, args...)}); err != nil {
+		slog.Error("failed to write schema registry response", "error", err)
+	}
+}
+
+func (r *LocalRegistry) handleSchemaByID(w http.ResponseWriter, req *http.Request) {
+	schema := r.schemaForID(w, req)
+	if schema == nil {
+		return
+	}
+	writeRegistryResponse(w, RegistrySchema{SchemaType: schema.SchemaType, Schema: schema.Schema})
+}
+
+func (r *LocalRegistry) handleSchemaVersions(w http.ResponseWriter, req *http.Request) {
+	schema := r.schemaForID(w, req)
+	if schema == nil {
+		return
+	}
+	type subjectVersion struct {
+		Subject string `json:"subject"`
+		Version int    `json:"version"`
+	}
+	var versions []subjectVersion
+	for _, s := range r.schemas {
+		if s.ID == schema.ID {
+			versions = append(versions, subjectVersion{Subject: s.Subject, Version: s.Version})
+		}
+	}
+	writeRegistryResponse(w, versions)
+}
+
+func (r *LocalRegistry) schemaForID(w http.ResponseWriter, req *http.Request) *RegistrySchema {
+	id, err := strconv.ParseUint(req.PathValue("id"), 10, 32)
+	if err != nil {
+		writeRegistryError(w, http.StatusNotFound, 40403, "Schema %s not found", req.PathValue("id"))
+		return nil
+	}
+	schema := r.byID(uint32(id))
+	if schema == nil {
+		writeRegistryError(w, http.StatusNotFound, 40403, "Schema %d not found", id)
+	}
+	return schema
+}
+
+func (r *LocalRegistry) handleSubjects(w http.ResponseWriter, _ *http.Request) {
+	subjects := []string{}
+	for _, schema := range r.schemas {
+		if !slices.Contains(subjects, schema.Subject) {
+			subjects = append(subjects, schema.Subject)
+		}
+	}
+	slices.Sort(subjects)
+	writeRegistryResponse(w, subjects)
+}
+
+func (r *LocalRegistry) handleSubjectVersions(w http.ResponseWriter, req *http.Request) {
+	versions := r.versions(req.PathValue("subject"))
+	if len(versions) == 0 {
+		writeRegistryError(w, http.StatusNotFound, 40401, "Subject '%s' not found.", req.PathValue("subject"))
+		return
+	}
+	numbers := make([]int, len(versions))
+	for i, schema := range versions {
+		numbers[i] = schema.Version
+	}
+	writeRegistryResponse(w, numbers)
+}
+
+func (r *LocalRegistry) handleSubjectVersion(w http.ResponseWriter, req *http.Request) {
+	if schema := r.subjectVersion(w, req); schema != nil {
+		writeRegistryResponse(w, schema)
+	}
+}
+
+func (r *LocalRegistry) handleSubjectVersionSchema(w http.ResponseWriter, req *http.Request) {
+	schema := r.subjectVersion(w, req)
+	if schema == nil {
+		return
+	}
+	w.Header().Set("Content-Type", REGISTRY_CONTENT_TYPE)
+	if _, err := io.WriteString(w, schema.Schema); err != nil {
+		slog.Error("failed to write schema registry response", "error", err)
+	}
+}
+
+// subjectVersion finds the version of a subject, which is a version number, or "latest" or -1
+// for the latest version
+func (r *LocalRegistry) subjectVersion(w http.ResponseWriter, req *http.Request) *RegistrySchema {
+	subject, version := req.PathValue("subject"), req.PathValue("version")
+	versions := r.versions(subject)
+	if len(versions) == 0 {
+		writeRegistryError(w, http.StatusNotFound, 40401, "Subject '%s' not found.", subject)
+		return nil
+	}
+	if version == "latest" || version == "-1" {
+		return versions[len(versions)-1]
+	}
+	number, err := strconv.Atoi(version)
+	if err != nil || number <= 0 {
+		writeRegistryError(w, http.StatusUnprocessableEntity, 42202, "The specified version '%s' is not a valid version id.", version)
+		return nil
+	}
+	for _, schema := range versions {
+		if schema.Version == number {
+			return schema
+		}
+	}
+	writeRegistryError(w, http.StatusNotFound, 40402, "Version %d not found.", number)
+	return nil
+}
+
+// handleLookupSchema finds the version of a subject with the given schema
+func (r *LocalRegistry) handleLookupSchema(w http.ResponseWriter, req *http.Request) {
+	var body RegistrySchema
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeRegistryError(w, http.StatusUnprocessableEntity, 42201, "Invalid schema: %v", err)
+		return
+	}
+	subject := req.PathValue("subject")
+	versions := r.versions(subject)
+	if len(versions) == 0 {
+		writeRegistryError(w, http.StatusNotFound, 40401, "Subject '%s' not found.", subject)
+		return
+	}
+	for _, schema := range versions {
+		if schema.Type() == body.Type() && sameSchema(schema, body.Schema) {
+			writeRegistryResponse(w, schema)
+			return
+		}
+	}
+	writeRegistryError(w, http.StatusNotFound, 40403, "Schema not found")
+}
+
+// handleCompatibility checks a schema in the compatibility level of the subject, see
+// CheckCompatibilityMode. With a version, the schema is checked against that version, and without one
+// against the versions the level checks: all of them for the TRANSITIVE levels, or else the latest.
+func (r *LocalRegistry) handleCompatibility(w http.ResponseWriter, req *http.Request) {
+	var body RegistrySchema
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeRegistryError(w, http.StatusUnprocessableEntity, 42201, "Invalid schema: %v", err)
+		return
+	}
+	subject := req.PathValue("subject")
+	versions := r.versions(subject)
+	if req.PathValue("version") != "" {
+		existing := r.subjectVersion(w, req)
+		if existing == nil {
+			return
+		}
+		versions = []*RegistrySchema{existing}
+	} else if len(versions) == 0 {
+		writeRegistryError(w, http.StatusNotFound, 40401, "Subject '%s' not found.", subject)
+		return
+	}
+	if body.Type() != "AVRO" {
+		writeRegistryError(w, http.StatusUnprocessableEntity, 42201, "Compatibility checks are only supported for Avro schemas")
+		return
+	}
+	newSchema, err := ParseAvroSchema(body.Schema)
+	if err != nil {
+		writeRegistryError(w, http.StatusUnprocessableEntity, 42201, "Invalid schema: %v", err)
+		return
+	}
+	existing := make([]avro.Schema, 0, len(versions))
+	for _, version := range versions {
+		if version.Type() != "AVRO" {
+			writeRegistryError(w, http.StatusUnprocessableEntity, 42201, "Compatibility checks are only supported for Avro schemas")
+			return
+		}
+		schema, err := ParseAvroSchema(version.Schema)
+		if err != nil {
+			writeRegistryError(w, http.StatusInternalServerError, 50001, "Invalid registered schema: %v", err)
+			return
+		}
+		existing = append(existing, schema)
+	}
+
+	incompatibilities, err := CheckCompatibilityMode(r.subjectCompatibilityLevel(subject), newSchema, existing)
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, 50001, "%v", err)
+		return
+	}
+	result := CompatibilityResult{IsCompatible: len(incompatibilities) == 0}
+	for _, incompatibility := range incompatibilities {
+		result.Messages = append(result.Messages, incompatibility.Path+": "+incompatibility.Message)
+	}
+	writeRegistryResponse(w, result)
+}
+
+// sameSchema compares schemas, ignoring formatting if they are JSON
+func sameSchema(schema *RegistrySchema, text string) bool {
+	if schema.Schema == text {
+		return true
+	}
+	var a, b any
+	if json.Unmarshal([]byte(schema.Schema), &a) != nil || json.Unmarshal([]byte(text), &b) != nil {
+		return false
+	}
+	aJSON, _ := json.Marshal(a)
+	bJSON, _ := json.Marshal(b)
+	return string(aJSON) == string(bJSON)
+}
+
+// StartLocalRegistry serves the schemas of a directory on addr, returning the URL of the registry
+func StartLocalRegistry(dir, addr string) (string, error) {
+	registry, err := LoadLocalRegistry(dir)
+	if err != nil {
+		return "", err
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	go func() {
+		if err := http.Serve(lis, registry.Handler()); err != nil && !errors.Is(err, net.ErrClosed) {
+			slog.Error("local schema registry stopped", "error", err)
+		}
+	}()
+	registryURL := "http://" + lis.Addr().String()
+	slog.Info("local schema registry listening", "url", registryURL, "directory", dir)
+	return registryURL, nil
+}
+
+// RunRegistryCommand runs the registry subcommand, serving the schemas of a directory until the
+// process is stopped
+func RunRegistryCommand(args []string) error {
+	flags := flag.NewFlagSet("registry", flag.ContinueOnError)
+	dir := flags.String("dir", "schemas", "the directory holding registry.json and the schema files")
+	addr := flags.String("addr", "localhost:8081", "the address to listen on")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	registry, err := LoadLocalRegistry(*dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("serving %d schemas from %s on http://%s\n", len(registry.schemas), *dir, *addr)
+	return http.ListenAndServe(*addr, registry.Handler())
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeTestRegistryDir writes a local registry directory with Avro, Protobuf and JSON schemas
+func writeTestRegistryDir(t *testing.T) string {
+	dir := t.TempDir()
+	files := map[string]string{
+		"user.avsc":    testUserSchema,
+		"user-v2.avsc": `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}]}`,
+		"order.proto":  testOrderProto,
+		REGISTRY_MANIFEST: `{
+		  "subjectCompatibilityLevels": {"users-value": "full_transitive"},
+		  "schemas": [
+		    {"id": 16, "subject": "users-value", "file": "user.avsc"},
+		    {"id": 17, "subject": "users-value", "version": 2, "file": "user-v2.avsc"},
+		    {"id": 20, "subject": "orders-value", "file": "order.proto"},
+		    {"id": 30, "subject": "payments-value", "schemaType": "JSON", "schema": {"type": "object"}}
+		  ]
+		}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+// TestLocalRegistry tests the schema registry REST API served from a directory
+func TestLocalRegistry(t *testing.T) {
+	registry, err := LoadLocalRegistry(writeTestRegistryDir(t))
+	if err != nil {
+		t.Fatalf("LoadLocalRegistry() unexpected error: %v", err)
+	}
+	server := httptest.NewServer(registry.Handler())
+	defer server.Close()
+	client := NewRegistryClient(RegistryConfig{URL: server.URL})
+	ctx := context.Background()
+
+	types := map[uint32]string{16: "AVRO", 17: "AVRO", 20: "PROTOBUF", 30: "JSON"}
+	for id, schemaType := range types {
+		schema, err := client.SchemaByID(ctx, id)
+		if err != nil {
+			t.Fatalf("SchemaByID(%d) unexpected error: %v", id, err)
+		}
+		if schema.Type() != schemaType {
+			t.Errorf("SchemaByID(%d) schema type = %s, want %s", id, schema.Type(), schemaType)
+		}
+	}
+	if schema, _ := client.SchemaByID(ctx, 30); schema.Schema != `{"type": "object"}` {
+		t.Errorf("SchemaByID(30) schema = %s", schema.Schema)
+	}
+
+	latest, err := client.SubjectVersion(ctx, "users-value", "latest")
+	if err != nil {
+		t.Fatalf("SubjectVersion() unexpected error: %v", err)
+	}
+	if latest.ID != 17 || latest.Version != 2 {
+		t.Errorf("SubjectVersion(latest) = %+v, want ID 17 version 2", latest)
+	}
+
+	versions, err := client.SubjectVersions(ctx, "users-value")
+	if err != nil {
+		t.Fatalf("SubjectVersions() unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]int{1, 2}, versions); diff != "" {
+		t.Errorf("SubjectVersions() mismatch (-want +got):\n%s", diff)
+	}
+
+	errorCodes := map[string]int{
+		"/schemas/ids/99":                      40403,
+		"/subjects/missing-value/versions/1":   40401,
+		"/subjects/users-value/versions/3":     40402,
+		"/subjects/users-value/versions/first": 42202,
+	}
+	for path, errorCode := range errorCodes {
+		var registryErr *RegistryError
+		if err := client.do(ctx, http.MethodGet, path, nil, &RegistrySchema{}); !errors.As(err, &registryErr) || registryErr.ErrorCode != errorCode {
+			t.Errorf("GET %s error = %v, want error code %d", path, err, errorCode)
+		}
+	}
+
+	var subjects []string
+	if err := client.do(ctx, http.MethodGet, "/subjects", nil, &subjects); err != nil {
+		t.Fatalf("GET /subjects unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"orders-value", "payments-value", "users-value"}, subjects); diff != "" {
+		t.Errorf("GET /subjects mismatch (-want +got):\n%s", diff)
+	}
+
+	resp, err := http.Get(server.URL + "/subjects/orders-value/versions/1/schema")
+	if err != nil {
+		t.Fatalf("GET schema unexpected error: %v", err)
+	}
+	// nolint:errcheck
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != testOrderProto {
+		t.Errorf("GET schema = %s, want the .proto source", body)
+	}
+
+	var found RegistrySchema
+	lookup := RegistrySchema{Schema: `{"type":"record","name":"User","fields":[{"name":"id","type":"string"}]}`}
+	if err := client.do(ctx, http.MethodPost, "/subjects/users-value", lookup, &found); err != nil || found.ID != 17 {
+		t.Errorf("POST /subjects/users-value = %+v, %v, want ID 17", found, err)
+	}
+
+	compatible, err := client.CheckCompatibility(ctx, "users-value", "1", &RegistrySchema{Schema: testUserSchema})
+	if err != nil || !compatible.IsCompatible {
+		t.Errorf("CheckCompatibility() = %+v, %v, want compatible", compatible, err)
+	}
+	withEmail := `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}, {"name": "email", "type": "string"}]}`
+	incompatible, err := client.CheckCompatibility(ctx, "users-value", "latest", &RegistrySchema{Schema: withEmail})
+	if err != nil {
+		t.Errorf("CheckCompatibility() unexpected error: %v", err)
+	}
+	want := &CompatibilityResult{Messages: []string{"$.email: the new schema can not read data written with an existing schema: the field email is missing from the writer schema, and the reader field has no default"}}
+	if diff := cmp.Diff(want, incompatible); diff != "" {
+		t.Errorf("CheckCompatibility() mismatch (-want +got):\n%s", diff)
+	}
+
+	// without a version, the FULL_TRANSITIVE level of the subject checks all of its versions
+	var transitive CompatibilityResult
+	if err := client.do(ctx, http.MethodPost, "/compatibility/subjects/users-value/versions", RegistrySchema{Schema: latest.Schema}, &transitive); err != nil {
+		t.Fatalf("POST /compatibility/subjects/users-value/versions unexpected error: %v", err)
+	}
+	want = &CompatibilityResult{Messages: []string{
+		"$.email: an existing schema can not read data written with the new schema: the field email is missing from the writer schema, and the reader field has no default",
+		"$.tags: an existing schema can not read data written with the new schema: the field tags is missing from the writer schema, and the reader field has no default",
+		"$.attributes: an existing schema can not read data written with the new schema: the field attributes is missing from the writer schema, and the reader field has no default",
+	}}
+	if diff := cmp.Diff(want, &transitive); diff != "" {
+		t.Errorf("POST /compatibility/subjects/users-value/versions mismatch (-want +got):\n%s", diff)
+	}
+	levels := map[string]string{"/config": "BACKWARD", "/config/users-value": "FULL_TRANSITIVE", "/config/orders-value": "BACKWARD"}
+	for path, level := range levels {
+		var config map[string]string
+		if err := client.do(ctx, http.MethodGet, path, nil, &config); err != nil || config["compatibilityLevel"] != level {
+			t.Errorf("GET %s = %v, %v, want compatibility level %s", path, config, err, level)
+		}
+	}
+}
+
+// TestLoadLocalRegistryErrors tests that invalid registry manifests are rejected
+func TestLoadLocalRegistryErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+	}{
+		{name: "invalid json", manifest: `{"schemas": [`},
+		{name: "missing subject", manifest: `{"schemas": [{"id": 1, "schema": "\"string\""}]}`},
+		{name: "missing schema", manifest: `{"schemas": [{"id": 1, "subject": "a"}]}`},
+		{name: "missing file", manifest: `{"schemas": [{"id": 1, "subject": "a", "file": "a.avsc"}]}`},
+		{name: "duplicate version", manifest: `{"schemas": [{"id": 1, "subject": "a", "schema": "\"string\""}, {"id": 2, "subject": "a", "schema": "\"long\""}]}`},
+		{name: "duplicate id", manifest: `{"schemas": [{"id": 1, "subject": "a", "schema": "\"string\""}, {"id": 1, "subject": "b", "schema": "\"long\""}]}`},
+		{name: "unknown compatibility level", manifest: `{"compatibilityLevel": "SIDEWAYS", "schemas": []}`},
+		{name: "unknown subject compatibility level", manifest: `{"subjectCompatibilityLevels": {"a": "NONE"}, "schemas": []}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, REGISTRY_MANIFEST), []byte(tt.manifest), 0644); err != nil {
+				t.Fatalf("failed to write manifest: %v", err)
+			}
+			if _, err := LoadLocalRegistry(dir); err == nil {
+				t.Error("LoadLocalRegistry() expected error")
+			}
+		})
+	}
+
+	if _, err := LoadLocalRegistry(t.TempDir()); err == nil {
+		t.Error("LoadLocalRegistry() expected error for a directory without a manifest")
+	}
+}
+
+// TestLocalRegistryFromPlugin tests that the plugin starts a local registry for the configured directory
+func TestLocalRegistryFromPlugin(t *testing.T) {
+	useRegistryConfig(t, RegistryConfig{Directory: writeTestRegistryDir(t)})
+
+	client, err := schemaRegistries.client("")
+	if err != nil {
+		t.Fatalf("client() unexpected error: %v", err)
+	}
+	schema, err := client.SchemaByID(context.Background(), 20)
+	if err != nil {
+		t.Fatalf("SchemaByID() unexpected error: %v", err)
+	}
+	if schema.Type() != "PROTOBUF" {
+		t.Errorf("SchemaByID() schema type = %s, want PROTOBUF", schema.Type())
+	}
+
+	again, err := schemaRegistries.client("")
+	if err != nil || again != client {
+		t.Errorf("client() = %p, %v, want the same client", again, err)
+	}
+}
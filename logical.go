@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamba/avro/v2"
+)
+
+// avroDecimal is a decimal logical type value in its decimal string form, such as "12.50", so that
+// it renders as a string but is matched as a number
+type avroDecimal string
+
+// logicalTimeLayouts are the layouts of the readable forms of the date and time logical types
+var logicalTimeLayouts = map[avro.LogicalType]string{
+	avro.Date:                 "2006-01-02",
+	avro.TimeMillis:           "15:04:05.000",
+	avro.TimeMicros:           "15:04:05.000000",
+	avro.TimestampMillis:      "2006-01-02T15:04:05.000Z07:00",
+	avro.TimestampMicros:      "2006-01-02T15:04:05.000000Z07:00",
+	avro.LocalTimestampMillis: "2006-01-02T15:04:05.000",
+	avro.LocalTimestampMicros: "2006-01-02T15:04:05.000000",
+}
+
+// ReadableAvro converts a decoded Avro value to its readable form, for diffs and markup: dates and
+// times become ISO 8601 strings and decimals their decimal string, see readableLogicalValue
+func ReadableAvro(schema avro.Schema, value any) any {
+	switch s := derefAvroSchema(schema).(type) {
+	case *avro.RecordSchema:
+		record, ok := value.(map[string]any)
+		if !ok {
+			return value
+		}
+		result := make(map[string]any, len(record))
+		for _, field := range s.Fields() {
+			result[field.Name()] = ReadableAvro(field.Type(), record[field.Name()])
+		}
+		return result
+	case *avro.ArraySchema:
+		items, ok := value.([]any)
+		if !ok {
+			return value
+		}
+		result := make([]any, len(items))
+		for i, item := range items {
+			result[i] = ReadableAvro(s.Items(), item)
+		}
+		return result
+	case *avro.MapSchema:
+		values, ok := value.(map[string]any)
+		if !ok {
+			return value
+		}
+		result := make(map[string]any, len(values))
+		for key, item := range values {
+			result[key] = ReadableAvro(s.Values(), item)
+		}
+		return result
+	case *avro.UnionSchema:
+		branch, branchValue, ok := unionBranch(s, value)
+		if !ok || branchValue == nil {
+			return value
+		}
+		return map[string]any{AvroTypeName(branch): ReadableAvro(branch, branchValue)}
+	}
+	return readableLogicalValue(schema, value)
+}
+
+// readableLogicalValue converts a leaf value of a logical type to its readable form. Values of other
+// types, and values that are not valid for their logical type, are returned as is.
+func readableLogicalValue(schema avro.Schema, value any) any {
+	schema = derefAvroSchema(schema)
+	switch logical := logicalType(schema); logical {
+	case avro.Decimal:
+		data, ok := value.([]byte)
+		decimal, isDecimal := schema.(avro.LogicalTypeSchema).Logical().(*avro.DecimalLogicalSchema)
+		if !ok || !isDecimal {
+			return value
+		}
+		return avroDecimal(formatDecimal(decodeTwosComplement(data), decimal.Scale()))
+	case avro.Date:
+		if days, ok := value.(int64); ok {
+			return time.Unix(days*86400, 0).UTC().Format(logicalTimeLayouts[logical])
+		}
+	case avro.TimeMillis, avro.TimestampMillis, avro.LocalTimestampMillis:
+		if ms, ok := value.(int64); ok {
+			return time.UnixMilli(ms).UTC().Format(logicalTimeLayouts[logical])
+		}
+	case avro.TimeMicros, avro.TimestampMicros, avro.LocalTimestampMicros:
+		if us, ok := value.(int64); ok {
+			return time.UnixMicro(us).UTC().Format(logicalTimeLayouts[logical])
+		}
+	}
+	return value
+}
+
+// avroLogicalFromJSON converts the readable form of a logical type value in a JSON message to the
+// value of the underlying Avro type: an ISO 8601 string for dates and times, a number or
+// {"decimal": "<decimal string>"} for decimals, and a UUID string for uuid. A string given for a
+// decimal is the Avro JSON encoding of its bytes, like for any bytes value. It returns false if the
+// schema has no logical type or the value is already of the underlying type.
+func avroLogicalFromJSON(schema avro.Schema, value any) (any, bool, error) {
+	logical := logicalType(schema)
+	if logical == "" {
+		return nil, false, nil
+	}
+
+	if logical == avro.Decimal {
+		decimal, ok := schema.(avro.LogicalTypeSchema).Logical().(*avro.DecimalLogicalSchema)
+		if !ok {
+			return nil, false, nil
+		}
+		var text string
+		switch v := value.(type) {
+		case map[string]any:
+			tagged, ok := v["decimal"].(string)
+			if !ok || len(v) != 1 {
+				return nil, false, nil
+			}
+			text = tagged
+		case avroDecimal:
+			text = string(v)
+		case float64:
+			text = big.NewFloat(v).Text('f', -1)
+		case int64:
+			text = fmt.Sprint(v)
+		default:
+			return nil, false, nil
+		}
+		unscaled, err := parseDecimal(text, decimal.Scale())
+		if err != nil {
+			return nil, true, err
+		}
+		if decimal.Precision() > 0 && len(strings.TrimPrefix(unscaled.String(), "-")) > decimal.Precision() {
+			return nil, true, fmt.Errorf("%s exceeds the precision %d of the decimal", text, decimal.Precision())
+		}
+		size := 0
+		if fixed, ok := schema.(*avro.FixedSchema); ok {
+			size = fixed.Size()
+		}
+		data, err := encodeTwosComplement(unscaled, size)
+		return data, true, err
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		return nil, false, nil
+	}
+	if logical == avro.UUID {
+		if _, err := uuid.Parse(text); err != nil {
+			return nil, true, fmt.Errorf("%q is not a valid uuid", text)
+		}
+		return text, true, nil
+	}
+	layout, ok := logicalTimeLayouts[logical]
+	if !ok {
+		return nil, false, nil
+	}
+	t, err := parseLogicalTime(logical, layout, text)
+	if err != nil {
+		return nil, true, fmt.Errorf("%q is not a valid %s: %w", text, logical, err)
+	}
+	switch logical {
+	case avro.Date:
+		return int64(math.Floor(float64(t.Unix()) / 86400)), true, nil
+	case avro.TimeMillis, avro.TimestampMillis, avro.LocalTimestampMillis:
+		return t.UnixMilli(), true, nil
+	}
+	return t.UnixMicro(), true, nil
+}
+
+// parseLogicalTime parses the readable form of a date or time logical type value. Fractional seconds
+// are optional, and timestamps may use any time zone offset.
+func parseLogicalTime(logical avro.LogicalType, layout, text string) (time.Time, error) {
+	switch logical {
+	case avro.TimeMillis, avro.TimeMicros:
+		layout = "15:04:05.999999999"
+	case avro.TimestampMillis, avro.TimestampMicros:
+		layout = time.RFC3339Nano
+	case avro.LocalTimestampMillis, avro.LocalTimestampMicros:
+		layout = "2006-01-02T15:04:05.999999999"
+	}
+	t, err := time.Parse(layout, text)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if logical == avro.TimeMillis || logical == avro.TimeMicros {
+		// times of day are relative to midnight of the epoch
+		t = t.AddDate(1970, 0, 0)
+	}
+	return t, nil
+}
+
+// parseDecimal parses a decimal string into its unscaled value for the scale of a decimal logical type
+func parseDecimal(text string, scale int) (*big.Int, error) {
+	r, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a valid decimal", text)
+	}
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	if !scaled.IsInt() {
+		return nil, fmt.Errorf("%s has more than %d decimal places", text, scale)
+	}
+	return scaled.Num(), nil
+}
+
+// formatDecimal formats an unscaled decimal value with a scale, such as 1250 with scale 2 as 12.50
+func formatDecimal(unscaled *big.Int, scale int) string {
+	if scale <= 0 {
+		return unscaled.String()
+	}
+	digits := new(big.Int).Abs(unscaled).String()
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	sign := ""
+	if unscaled.Sign() < 0 {
+		sign = "-"
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+// decodeTwosComplement decodes a big-endian two's complement integer, as decimals are encoded
+func decodeTwosComplement(data []byte) *big.Int {
+	n := new(big.Int).SetBytes(data)
+	if len(data) > 0 && data[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(data)*8)))
+	}
+	return n
+}
+
+// encodeTwosComplement encodes an integer as a big-endian two's complement, in the fewest bytes or
+// sign extended to size bytes if size is not zero
+func encodeTwosComplement(n *big.Int, size int) ([]byte, error) {
+	length := n.BitLen()/8 + 1
+	if size > 0 {
+		if length > size {
+			return nil, fmt.Errorf("%s does not fit in a fixed of %d bytes", n, size)
+		}
+		length = size
+	}
+	value := new(big.Int).Set(n)
+	if n.Sign() < 0 {
+		value.Add(value, new(big.Int).Lsh(big.NewInt(1), uint(length*8)))
+	}
+	data := value.Bytes()
+	return append(make([]byte, length-len(data), length), data...), nil
+}
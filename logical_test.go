@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestAvroLogicalTypes tests converting logical type values between their readable form and the
+// value of their underlying type
+func TestAvroLogicalTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		schema   string
+		value    any
+		native   any
+		readable any
+	}{
+		{name: "date", schema: `{"type": "int", "logicalType": "date"}`, value: "2024-03-01", native: int64(19783), readable: "2024-03-01"},
+		{name: "date before the epoch", schema: `{"type": "int", "logicalType": "date"}`, value: "1969-12-31", native: int64(-1), readable: "1969-12-31"},
+		{name: "time-millis", schema: `{"type": "int", "logicalType": "time-millis"}`, value: "10:20:30.5", native: int64(37230500), readable: "10:20:30.500"},
+		{name: "time-micros", schema: `{"type": "long", "logicalType": "time-micros"}`, value: "10:20:30", native: int64(37230000000), readable: "10:20:30.000000"},
+		{name: "timestamp-millis", schema: `{"type": "long", "logicalType": "timestamp-millis"}`, value: "2024-03-01T10:20:30.123+01:00", native: int64(1709284830123), readable: "2024-03-01T09:20:30.123Z"},
+		{name: "timestamp-micros", schema: `{"type": "long", "logicalType": "timestamp-micros"}`, value: "2024-03-01T09:20:30.123456Z", native: int64(1709284830123456), readable: "2024-03-01T09:20:30.123456Z"},
+		{name: "local-timestamp-millis", schema: `{"type": "long", "logicalType": "local-timestamp-millis"}`, value: "2024-03-01T09:20:30", native: int64(1709284830000), readable: "2024-03-01T09:20:30.000"},
+		{name: "timestamp as a number", schema: `{"type": "long", "logicalType": "timestamp-millis"}`, value: float64(1709284830123), native: int64(1709284830123), readable: "2024-03-01T09:20:30.123Z"},
+		{name: "decimal bytes", schema: `{"type": "bytes", "logicalType": "decimal", "precision": 6, "scale": 2}`, value: float64(12.5), native: []byte{0x04, 0xE2}, readable: avroDecimal("12.50")},
+		{name: "tagged decimal", schema: `{"type": "bytes", "logicalType": "decimal", "precision": 6, "scale": 2}`, value: map[string]any{"decimal": "12.5"}, native: []byte{0x04, 0xE2}, readable: avroDecimal("12.50")},
+		{name: "decimal as bytes", schema: `{"type": "bytes", "logicalType": "decimal", "precision": 6, "scale": 2}`, value: "12", native: []byte("12"), readable: avroDecimal("125.94")},
+		{name: "negative decimal", schema: `{"type": "bytes", "logicalType": "decimal", "precision": 6, "scale": 2}`, value: float64(-0.05), native: []byte{0xFB}, readable: avroDecimal("-0.05")},
+		{name: "decimal fixed", schema: `{"type": "fixed", "name": "Amount", "size": 4, "logicalType": "decimal", "precision": 8, "scale": 3}`, value: map[string]any{"decimal": "-1.5"}, native: []byte{0xFF, 0xFF, 0xFA, 0x24}, readable: avroDecimal("-1.500")},
+		{name: "uuid", schema: `{"type": "string", "logicalType": "uuid"}`, value: "94af717d-1b04-4fad-9879-01dc828e410d", native: "94af717d-1b04-4fad-9879-01dc828e410d", readable: "94af717d-1b04-4fad-9879-01dc828e410d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := ParseAvroSchema(tt.schema)
+			if err != nil {
+				t.Fatalf("failed to parse schema: %v", err)
+			}
+			native, err := AvroFromJSON(schema, tt.value)
+			if err != nil {
+				t.Fatalf("AvroFromJSON() unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.native, native); diff != "" {
+				t.Errorf("AvroFromJSON() mismatch (-want +got):\n%s", diff)
+			}
+			data, err := EncodeAvro(schema, native)
+			if err != nil {
+				t.Fatalf("EncodeAvro() unexpected error: %v", err)
+			}
+			decoded, err := DecodeAvro(schema, data)
+			if err != nil {
+				t.Fatalf("DecodeAvro() unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.readable, ReadableAvro(schema, decoded)); diff != "" {
+				t.Errorf("ReadableAvro() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	invalid := map[string]any{
+		`{"type": "int", "logicalType": "date"}`:                                                              "01/03/2024",
+		`{"type": "string", "logicalType": "uuid"}`:                                                           "not-a-uuid",
+		`{"type": "bytes", "logicalType": "decimal", "precision": 6, "scale": 2}`:                             map[string]any{"decimal": "1.234"},
+		`{"type": "bytes", "logicalType": "decimal", "precision": 3, "scale": 2}`:                             float64(12.5),
+		`{"type": "fixed", "name": "Small", "size": 1, "logicalType": "decimal", "precision": 4, "scale": 0}`: float64(1000),
+	}
+	for schemaText, value := range invalid {
+		schema, err := ParseAvroSchema(schemaText)
+		if err != nil {
+			t.Fatalf("failed to parse schema: %v", err)
+		}
+		if _, err := AvroFromJSON(schema, value); err == nil {
+			t.Errorf("AvroFromJSON(%s, %v) expected error", schemaText, value)
+		}
+	}
+}
@@ -4,18 +4,44 @@ import (
 	"log"
 	"log/slog"
 	"os"
+	"path/filepath"
 )
 
 const (
-	AVRO_SCHEMA_CONTENT_TYPE = "application/vnd.kafka.avro.v2"
-	PLUGIN_NAME              = "kafkaplugin"
+	AVRO_SCHEMA_CONTENT_TYPE     = "application/vnd.kafka.avro.v2"
+	PROTOBUF_SCHEMA_CONTENT_TYPE = "application/vnd.kafka.protobuf.v2"
+	JSON_SCHEMA_CONTENT_TYPE     = "application/vnd.kafka.json.v2"
+	PLUGIN_NAME                  = "kafkaplugin"
+	// CONTENT_TYPES lists the content types handled by the plugin, in the format of the catalogue
+	CONTENT_TYPES = AVRO_SCHEMA_CONTENT_TYPE + ";" + PROTOBUF_SCHEMA_CONTENT_TYPE + ";" + JSON_SCHEMA_CONTENT_TYPE
 )
 
 func main() {
+	// the registry subcommand serves a directory of schemas, see LoadLocalRegistry
+	if len(os.Args) > 1 && os.Args[1] == "registry" {
+		if err := RunRegistryCommand(os.Args[2:]); err != nil {
+			log.Fatalf("registry: %v", err)
+		}
+		return
+	}
+
 	if err := InitLogger(); err != nil {
 		log.Fatalf("failed to initialize logger: %v", err)
 	}
 
+	// the plugin manifest is next to the executable in the plugin directory, whatever the working directory
+	executable, err := os.Executable()
+	if err != nil {
+		slog.Error("failed to locate the plugin executable", "error", err)
+		os.Exit(1)
+	}
+	registryConfig, err := LoadRegistryConfig(filepath.Join(filepath.Dir(executable), PLUGIN_MANIFEST))
+	if err != nil {
+		slog.Error("failed to load the schema registry configuration", "error", err)
+		os.Exit(1)
+	}
+	schemaRegistries.configure(registryConfig)
+
 	if err := StartPluginServer(); err != nil {
 		slog.Error("failed to start plugin server", "error", err)
 		os.Exit(1)
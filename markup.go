@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"sort"
+	"strings"
+	"text/template"
+
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+)
+
+// messageMarkup is the data rendered as the interaction markup of a configured message
+type messageMarkup struct {
+	Topic      string
+	Tombstone  bool
+	SchemaID   uint32
+	Subject    string
+	Version    int
+	SchemaType string
+	Schema     string
+	Message    string
+	Rules      []markupAnnotation
+	Generators []markupAnnotation
+}
+
+// markupAnnotation describes the matching rules or generator of a path
+type markupAnnotation struct {
+	Path        string
+	Description string
+}
+
+const commonMarkTemplate = `# Kafka message
+{{if .Topic}}
+**Topic:** ` + "`{{.Topic}}`" + `
+{{end}}{{if .Tombstone}}
+**Tombstone:** the record has a null value
+{{else}}
+**Schema ID:** {{.SchemaID}}{{if .Subject}} (subject ` + "`{{.Subject}}`" + ` version {{.Version}}){{end}}
+
+## Message
+
+` + "```json" + `
+{{.Message}}
+` + "```" + `
+{{end}}{{if .Rules}}
+## Matching rules
+{{range .Rules}}
+- ` + "`{{.Path}}`" + `: {{.Description}}{{end}}
+{{end}}{{if .Generators}}
+## Generators
+{{range .Generators}}
+- ` + "`{{.Path}}`" + `: {{.Description}}{{end}}
+{{end}}{{if .Schema}}
+## {{.SchemaType}} schema
+
+` + "```{{if eq .SchemaType \"PROTOBUF\"}}protobuf{{else}}json{{end}}" + `
+{{.Schema}}
+` + "```" + `
+{{end}}`
+
+const htmlTemplate = `<div class="kafka-message">
+<h1>Kafka message</h1>
+<dl>
+{{- if .Topic}}
+<dt>Topic</dt><dd><code>{{.Topic}}</code></dd>
+{{- end}}
+{{- if .Tombstone}}
+<dt>Tombstone</dt><dd>the record has a null value</dd>
+</dl>
+{{- else}}
+<dt>Schema ID</dt><dd>{{.SchemaID}}</dd>
+{{- if .Subject}}
+<dt>Subject</dt><dd><code>{{.Subject}}</code> version {{.Version}}</dd>
+{{- end}}
+</dl>
+<h2>Message</h2>
+<pre><code class="language-json">{{.Message}}</code></pre>
+{{- end}}
+{{- if .Rules}}
+<h2>Matching rules</h2>
+<ul>
+{{- range .Rules}}
+<li><code>{{.Path}}</code>: {{.Description}}</li>
+{{- end}}
+</ul>
+{{- end}}
+{{- if .Generators}}
+<h2>Generators</h2>
+<ul>
+{{- range .Generators}}
+<li><code>{{.Path}}</code>: {{.Description}}</li>
+{{- end}}
+</ul>
+{{- end}}
+{{- if .Schema}}
+<h2>{{.SchemaType}} schema</h2>
+<pre><code>{{.Schema}}</code></pre>
+{{- end}}
+</div>
+`
+
+var (
+	commonMarkMarkup = template.Must(template.New("markup").Parse(commonMarkTemplate))
+	htmlMarkup       = htmltemplate.Must(htmltemplate.New("markup").Parse(htmlTemplate))
+)
+
+// parseMarkupType parses the markupType field of the contentsConfig, COMMON_MARK or HTML
+func parseMarkupType(value string) (pb.InteractionResponse_MarkupType, error) {
+	markupType, ok := pb.InteractionResponse_MarkupType_value[strings.ToUpper(value)]
+	if !ok {
+		return 0, fmt.Errorf("markupType must be COMMON_MARK or HTML, got %q", value)
+	}
+	return pb.InteractionResponse_MarkupType(markupType), nil
+}
+
+// newMessageMarkup collects the markup data of a configured message. Without a message format the
+// message is shown base64 encoded.
+func newMessageMarkup(topic string, schemaID uint32, source *schemaSource, format messageFormat, message *encodedMessage) (*messageMarkup, error) {
+	markup := &messageMarkup{Topic: topic, SchemaID: schemaID}
+	if source != nil {
+		markup.Subject = source.subject
+		markup.Version = source.version
+	}
+
+	if format == nil {
+		markup.Message = fmt.Sprintf("%q", base64.StdEncoding.EncodeToString(message.data))
+	} else {
+		value, err := format.Decode(message.data)
+		if err != nil {
+			return nil, err
+		}
+		if avroFormat, ok := format.(*avroFormat); ok {
+			value = ReadableAvro(avroFormat.schema, value)
+		}
+		if markup.Message, err = prettyJSON(value); err != nil {
+			return nil, err
+		}
+		config := format.Configuration()
+		markup.SchemaType = config["schemaType"].GetStringValue()
+		if markup.SchemaType == "" {
+			markup.SchemaType = "AVRO"
+		}
+		schema := config["schema"].GetStringValue()
+		if pretty, err := prettyJSONText(schema); err == nil {
+			schema = pretty
+		}
+		markup.Schema = strings.TrimSpace(schema)
+	}
+
+	for _, path := range sortedKeys(message.rules) {
+		descriptions := make([]string, 0, len(message.rules[path].GetRule()))
+		for _, rule := range message.rules[path].GetRule() {
+			descriptions = append(descriptions, describeRule(rule.GetType(), rule.GetValues().AsMap()))
+		}
+		markup.Rules = append(markup.Rules, markupAnnotation{Path: path, Description: strings.Join(descriptions, ", ")})
+	}
+	for _, path := range sortedKeys(message.generators) {
+		generator := message.generators[path]
+		description := describeRule(generator.GetType(), generator.GetValues().AsMap())
+		markup.Generators = append(markup.Generators, markupAnnotation{Path: path, Description: description})
+	}
+	return markup, nil
+}
+
+// Render renders the markup as CommonMark or HTML
+func (m *messageMarkup) Render(markupType pb.InteractionResponse_MarkupType) (string, error) {
+	var buf bytes.Buffer
+	var err error
+	if markupType == pb.InteractionResponse_HTML {
+		err = htmlMarkup.Execute(&buf, m)
+	} else {
+		err = commonMarkMarkup.Execute(&buf, m)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to render interaction markup: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// describeRule describes a matching rule or generator by its type and values, e.g. regex(regex: '\d+')
+func describeRule(ruleType string, values map[string]any) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if key != "match" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return ruleType
+	}
+	sort.Strings(keys)
+	args := make([]string, len(keys))
+	for i, key := range keys {
+		value, _ := json.Marshal(values[key])
+		args[i] = fmt.Sprintf("%s: %s", key, value)
+	}
+	return fmt.Sprintf("%s(%s)", ruleType, strings.Join(args, ", "))
+}
+
+func prettyJSON(value any) (string, error) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func prettyJSONText(text string) (string, error) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(text), "", "  "); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+)
+
+// TestMessageMarkup tests rendering a configured message as CommonMark and HTML
+func TestMessageMarkup(t *testing.T) {
+	schema, err := ParseAvroSchema(`{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}, {"name": "age", "type": "int"}]}`)
+	if err != nil {
+		t.Fatalf("failed to parse schema: %v", err)
+	}
+	format := &avroFormat{schema: schema}
+	value, rules, generators, err := format.ParseMessage(map[string]any{"id": "matching(regex, '\\d+', '42')", "age": "fromProviderState('${age}', 30)"})
+	if err != nil {
+		t.Fatalf("ParseMessage() unexpected error: %v", err)
+	}
+	data, err := format.Encode(value)
+	if err != nil {
+		t.Fatalf("Encode() unexpected error: %v", err)
+	}
+	source := &schemaSource{subject: "users-value", version: 2}
+	markup, err := newMessageMarkup("users", 16, source, format, &encodedMessage{data: data, rules: rules, generators: generators})
+	if err != nil {
+		t.Fatalf("newMessageMarkup() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		markupType pb.InteractionResponse_MarkupType
+		want       []string
+	}{
+		{
+			markupType: pb.InteractionResponse_COMMON_MARK,
+			want: []string{
+				"**Topic:** `users`",
+				"**Schema ID:** 16 (subject `users-value` version 2)",
+				"```json\n{\n  \"age\": 30,\n  \"id\": \"42\"\n}\n```",
+				"- `$.id`: regex(regex: \"\\\\d+\")",
+				"- `$.age`: ProviderState(dataType: \"INTEGER\", expression: \"${age}\")",
+				"## AVRO schema",
+			},
+		},
+		{
+			markupType: pb.InteractionResponse_HTML,
+			want: []string{
+				"<dt>Topic</dt><dd><code>users</code></dd>",
+				"<dt>Subject</dt><dd><code>users-value</code> version 2</dd>",
+				"&#34;id&#34;: &#34;42&#34;",
+				"<li><code>$.id</code>: regex(regex: &#34;\\\\d&#43;&#34;)</li>",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.markupType.String(), func(t *testing.T) {
+			got, err := markup.Render(tt.markupType)
+			if err != nil {
+				t.Fatalf("Render() unexpected error: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("Render() does not contain %q:\n%s", want, got)
+				}
+			}
+		})
+	}
+}
+
+// TestParseMarkupType tests parsing the markupType field
+func TestParseMarkupType(t *testing.T) {
+	for value, want := range map[string]pb.InteractionResponse_MarkupType{
+		"COMMON_MARK": pb.InteractionResponse_COMMON_MARK,
+		"html":        pb.InteractionResponse_HTML,
+	} {
+		if got, err := parseMarkupType(value); err != nil || got != want {
+			t.Errorf("parseMarkupType(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	if _, err := parseMarkupType("markdown"); err == nil {
+		t.Error("parseMarkupType() expected error for an unknown markup type")
+	}
+}
@@ -0,0 +1,771 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// matcherFunctions are the functions that may start a matching rule definition
+var matcherFunctions = []string{"matching", "notEmpty", "fromProviderState"}
+
+// COLLECTION_MATCHER_KEY is the key of a collection matcher, an object such as
+// {"pact:match": "eachLike", "value": [...]} that declares the matching rules of an array or map
+// together with its example value
+const COLLECTION_MATCHER_KEY = "pact:match"
+
+// MatchingRuleDefinition is a parsed matching rule definition such as matching(type, 'Jane')
+type MatchingRuleDefinition struct {
+	// Value is the example value of the definition
+	Value any
+	// Rules are the matching rules declared by the definition
+	Rules []*pb.MatchingRule
+	// Generator is set when the value is generated from the provider state with fromProviderState
+	Generator *pb.Generator
+}
+
+// IsMatchingRuleDefinition returns true if the string looks like a matching rule definition
+func IsMatchingRuleDefinition(s string) bool {
+	s = strings.TrimSpace(s)
+	for _, name := range matcherFunctions {
+		if rest, ok := strings.CutPrefix(s, name); ok && strings.HasPrefix(strings.TrimSpace(rest), "(") {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseMatchingRuleDefinition parses a comma separated list of matching rule definitions, for example
+// "matching(regex, '\\d+', '100')" or "matching(type, 'Jane'), notEmpty('Jane')".
+func ParseMatchingRuleDefinition(expr string) (*MatchingRuleDefinition, error) {
+	p := &definitionParser{input: expr}
+	def := &MatchingRuleDefinition{}
+	for {
+		if err := p.parseDefinition(def); err != nil {
+			return nil, fmt.Errorf("invalid matching rule definition %q: %w", expr, err)
+		}
+		if !p.consume(",") {
+			break
+		}
+	}
+	if p.skipSpace(); p.pos != len(p.input) {
+		return nil, fmt.Errorf("invalid matching rule definition %q: unexpected %q", expr, p.input[p.pos:])
+	}
+	return def, nil
+}
+
+type definitionParser struct {
+	input string
+	pos   int
+}
+
+func (p *definitionParser) skipSpace() {
+	for p.pos < len(p.input) && strings.ContainsRune(" \t\r\n", rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *definitionParser) consume(token string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.input[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *definitionParser) expect(token string) error {
+	if !p.consume(token) {
+		return fmt.Errorf("expected %q at position %d", token, p.pos)
+	}
+	return nil
+}
+
+func (p *definitionParser) identifier() (string, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) && (isLetter(p.input[p.pos]) || (p.pos > start && p.input[p.pos] >= '0' && p.input[p.pos] <= '9')) {
+		p.pos++
+	}
+	if start == p.pos {
+		return "", fmt.Errorf("expected an identifier at position %d", p.pos)
+	}
+	return p.input[start:p.pos], nil
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == '-'
+}
+
+// stringLiteral parses a single quoted string, supporting backslash escapes
+func (p *definitionParser) stringLiteral() (string, error) {
+	if err := p.expect("'"); err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		p.pos++
+		switch c {
+		case '\'':
+			return sb.String(), nil
+		case '\\':
+			if p.pos >= len(p.input) {
+				return "", fmt.Errorf("unterminated escape sequence")
+			}
+			escaped := p.input[p.pos]
+			p.pos++
+			switch escaped {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case '\'', '\\':
+				sb.WriteByte(escaped)
+			default:
+				// keep unknown escapes, which are common in regular expressions
+				sb.WriteByte('\\')
+				sb.WriteByte(escaped)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+// value parses a string, number, boolean or null literal, or a fromProviderState reference
+func (p *definitionParser) value(def *MatchingRuleDefinition) (any, error) {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return nil, fmt.Errorf("expected a value at position %d", p.pos)
+	}
+	if p.input[p.pos] == '\'' {
+		return p.stringLiteral()
+	}
+	if p.consume("fromProviderState") {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		value, err := p.fromProviderState(def)
+		if err != nil {
+			return nil, err
+		}
+		return value, p.expect(")")
+	}
+	start := p.pos
+	for p.pos < len(p.input) && !strings.ContainsRune(",) \t\r\n", rune(p.input[p.pos])) {
+		p.pos++
+	}
+	literal := p.input[start:p.pos]
+	switch literal {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	number, err := strconv.ParseFloat(literal, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q at position %d", literal, start)
+	}
+	return number, nil
+}
+
+// fromProviderState parses the arguments of fromProviderState('${expression}', example), recording
+// a ProviderState generator on the definition and returning the example value.
+func (p *definitionParser) fromProviderState(def *MatchingRuleDefinition) (any, error) {
+	expression, err := p.stringLiteral()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	value, err := p.value(def)
+	if err != nil {
+		return nil, err
+	}
+
+	dataType := "RAW"
+	switch v := value.(type) {
+	case string:
+		dataType = "STRING"
+	case bool:
+		dataType = "BOOLEAN"
+	case float64:
+		dataType = "DECIMAL"
+		if v == float64(int64(v)) {
+			dataType = "INTEGER"
+		}
+	}
+	def.Generator = &pb.Generator{
+		Type: "ProviderState",
+		Values: &structpb.Struct{Fields: map[string]*structpb.Value{
+			"expression": structpb.NewStringValue(expression),
+			"dataType":   structpb.NewStringValue(dataType),
+		}},
+	}
+	return value, nil
+}
+
+func (p *definitionParser) parseDefinition(def *MatchingRuleDefinition) error {
+	name, err := p.identifier()
+	if err != nil {
+		return err
+	}
+	if err := p.expect("("); err != nil {
+		return err
+	}
+
+	switch name {
+	case "matching":
+		if err := p.parseMatching(def); err != nil {
+			return err
+		}
+	case "notEmpty":
+		value, err := p.value(def)
+		if err != nil {
+			return err
+		}
+		def.Value = value
+		def.Rules = append(def.Rules, newMatchingRule("notEmpty", nil))
+	case "fromProviderState":
+		value, err := p.fromProviderState(def)
+		if err != nil {
+			return err
+		}
+		def.Value = value
+	default:
+		return fmt.Errorf("unknown matching rule definition %s", name)
+	}
+
+	return p.expect(")")
+}
+
+func (p *definitionParser) parseMatching(def *MatchingRuleDefinition) error {
+	matcher, err := p.identifier()
+	if err != nil {
+		return err
+	}
+	if err := p.expect(","); err != nil {
+		return err
+	}
+
+	switch matcher {
+	case "type", "number", "integer", "decimal", "boolean":
+		value, err := p.value(def)
+		if err != nil {
+			return err
+		}
+		def.Value = value
+		def.Rules = append(def.Rules, newMatchingRule(matcher, nil))
+	case "equalTo":
+		value, err := p.value(def)
+		if err != nil {
+			return err
+		}
+		def.Value = value
+		def.Rules = append(def.Rules, newMatchingRule("equality", nil))
+	case "regex":
+		regex, err := p.stringLiteral()
+		if err != nil {
+			return err
+		}
+		if _, err := regexp.Compile(regex); err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+		if err := p.expect(","); err != nil {
+			return err
+		}
+		value, err := p.value(def)
+		if err != nil {
+			return err
+		}
+		def.Value = value
+		def.Rules = append(def.Rules, newMatchingRule("regex", map[string]any{"regex": regex}))
+	case "datetime", "timestamp", "date", "time":
+		format, err := p.stringLiteral()
+		if err != nil {
+			return err
+		}
+		if _, err := JavaDateLayout(format); err != nil {
+			return err
+		}
+		if err := p.expect(","); err != nil {
+			return err
+		}
+		value, err := p.value(def)
+		if err != nil {
+			return err
+		}
+		def.Value = value
+		if matcher == "timestamp" {
+			matcher = "datetime"
+		}
+		def.Rules = append(def.Rules, newMatchingRule(matcher, map[string]any{"format": format}))
+	case "include":
+		value, err := p.stringLiteral()
+		if err != nil {
+			return err
+		}
+		def.Value = value
+		def.Rules = append(def.Rules, newMatchingRule("include", map[string]any{"value": value}))
+	default:
+		return fmt.Errorf("unknown matcher %s", matcher)
+	}
+	return nil
+}
+
+func newMatchingRule(ruleType string, values map[string]any) *pb.MatchingRule {
+	rule := &pb.MatchingRule{Type: ruleType}
+	if values != nil {
+		// values are always built from JSON compatible types
+		rule.Values, _ = structpb.NewStruct(values)
+	}
+	return rule
+}
+
+// ParseCollectionDefinition parses the comma separated collection rule definitions of a collection
+// matcher, for example "atLeast(1), atMost(5)" or "eachKey(matching(regex, '[a-z]+', 'colour'))":
+//
+//   - eachLike or eachLike(min) matches every element by the example element, with at least min (1) elements
+//   - atLeast(min) and atMost(max) bound the number of elements, matching them by the example element
+//   - eachKey(definition) and eachValue(definition) match every key or value of a map with a definition
+//   - arrayContains matches arrays that contain an element matching each example element, in any order
+func ParseCollectionDefinition(expr string) ([]*pb.MatchingRule, error) {
+	p := &definitionParser{input: expr}
+	var rules []*pb.MatchingRule
+	for {
+		rule, err := p.parseCollectionDefinition()
+		if err != nil {
+			return nil, fmt.Errorf("invalid collection rule definition %q: %w", expr, err)
+		}
+		rules = append(rules, rule)
+		if !p.consume(",") {
+			break
+		}
+	}
+	if p.skipSpace(); p.pos != len(p.input) {
+		return nil, fmt.Errorf("invalid collection rule definition %q: unexpected %q", expr, p.input[p.pos:])
+	}
+	return rules, nil
+}
+
+func (p *definitionParser) parseCollectionDefinition() (*pb.MatchingRule, error) {
+	name, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	// the parentheses are optional for definitions without arguments
+	if !p.consume("(") {
+		switch name {
+		case "eachLike":
+			return newMatchingRule("min", map[string]any{"min": 1}), nil
+		case "arrayContains":
+			return newMatchingRule("arrayContains", nil), nil
+		}
+		return nil, fmt.Errorf("expected %q at position %d", "(", p.pos)
+	}
+
+	var rule *pb.MatchingRule
+	switch name {
+	case "eachLike", "atLeast", "atMost":
+		if name == "eachLike" && p.consume(")") {
+			return newMatchingRule("min", map[string]any{"min": 1}), nil
+		}
+		bound, err := p.count()
+		if err != nil {
+			return nil, err
+		}
+		key := "min"
+		if name == "atMost" {
+			key = "max"
+		}
+		rule = newMatchingRule(key, map[string]any{key: bound})
+	case "eachKey", "eachValue":
+		def := &MatchingRuleDefinition{}
+		if err := p.parseDefinition(def); err != nil {
+			return nil, err
+		}
+		if len(def.Rules) == 0 {
+			return nil, fmt.Errorf("%s requires a matching rule", name)
+		}
+		rule = newMatchingRule(name, map[string]any{"rules": ruleValues(def.Rules)})
+	case "arrayContains":
+		rule = newMatchingRule("arrayContains", nil)
+	default:
+		return nil, fmt.Errorf("unknown collection rule definition %s", name)
+	}
+	return rule, p.expect(")")
+}
+
+// count parses a non-negative integer literal
+func (p *definitionParser) count() (int, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+		p.pos++
+	}
+	count, err := strconv.Atoi(p.input[start:p.pos])
+	if err != nil {
+		return 0, fmt.Errorf("expected a non-negative integer at position %d", start)
+	}
+	return count, nil
+}
+
+// ruleValues converts matching rules to their representation in the pact, {"match": type, ...values},
+// for rules nested in the values of another rule
+func ruleValues(rules []*pb.MatchingRule) []any {
+	values := make([]any, 0, len(rules))
+	for _, rule := range rules {
+		value := rule.GetValues().AsMap()
+		value["match"] = rule.Type
+		values = append(values, value)
+	}
+	return values
+}
+
+// nestedRules returns the matching rules nested in the rules value of an eachKey or eachValue rule
+func nestedRules(rule *pb.MatchingRule) []*pb.MatchingRule {
+	return rulesFromValues(rule.GetValues().GetFields()["rules"].GetListValue().GetValues())
+}
+
+// rulesFromValues converts matching rules from their representation in the pact, see ruleValues
+func rulesFromValues(values []*structpb.Value) []*pb.MatchingRule {
+	rules := make([]*pb.MatchingRule, 0, len(values))
+	for _, value := range values {
+		fields := maps.Clone(value.GetStructValue().GetFields())
+		ruleType := fields["match"].GetStringValue()
+		delete(fields, "match")
+		rule := &pb.MatchingRule{Type: ruleType}
+		if len(fields) > 0 {
+			rule.Values = &structpb.Struct{Fields: fields}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// pathToken is a single element of a parsed Pact path expression
+type pathToken struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parsePath parses a Pact path expression such as $.items[*].sku or $['first-name']
+func parsePath(path string) ([]pathToken, bool) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, false
+	}
+	tokens := make([]pathToken, 0)
+	rest := path[1:]
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".*"):
+			tokens = append(tokens, pathToken{wildcard: true})
+			rest = rest[2:]
+		case strings.HasPrefix(rest, "."):
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			tokens = append(tokens, pathToken{name: rest[1 : end+1]})
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, "[*]"):
+			tokens = append(tokens, pathToken{wildcard: true})
+			rest = rest[3:]
+		case strings.HasPrefix(rest, "['"):
+			end := strings.Index(rest, "']")
+			if end < 0 {
+				return nil, false
+			}
+			tokens = append(tokens, pathToken{name: rest[2:end]})
+			rest = rest[end+2:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, false
+			}
+			idx, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, false
+			}
+			tokens = append(tokens, pathToken{index: idx, isIndex: true})
+			rest = rest[end+1:]
+		default:
+			return nil, false
+		}
+	}
+	return tokens, true
+}
+
+// pathWeight calculates how well a rule path matches an actual path. The rule path must
+// match a prefix of the actual path; exact tokens weigh more than wildcards. Zero means no match.
+func pathWeight(rulePath, actualPath []pathToken) int {
+	if len(rulePath) > len(actualPath) {
+		return 0
+	}
+	weight := 1
+	for i, token := range rulePath {
+		actual := actualPath[i]
+		switch {
+		case token.wildcard:
+			weight *= 1
+		case token.isIndex && actual.isIndex && token.index == actual.index:
+			weight *= 2
+		case !token.isIndex && !actual.isIndex && !actual.wildcard && token.name == actual.name:
+			weight *= 2
+		default:
+			return 0
+		}
+	}
+	return weight * 2
+}
+
+// ruleSet looks up the matching rules that apply to a path
+type ruleSet map[string]*pb.MatchingRules
+
+// rulesFor returns the rules of the rule path that best matches the given path. Rules
+// declared on a parent path also apply to its children.
+func (r ruleSet) rulesFor(path string) []*pb.MatchingRule {
+	return r.bestRules(path, false)
+}
+
+// rulesAt returns the rules of the rule path that best matches the given path, ignoring the rules
+// of its parents, for rules such as arrayContains that only apply to the collection they are declared on
+func (r ruleSet) rulesAt(path string) []*pb.MatchingRule {
+	return r.bestRules(path, true)
+}
+
+func (r ruleSet) bestRules(path string, exact bool) []*pb.MatchingRule {
+	actual, ok := parsePath(path)
+	if !ok || len(r) == 0 {
+		return nil
+	}
+	var (
+		best       []*pb.MatchingRule
+		bestWeight int
+		bestLength int
+	)
+	for rulePath, rules := range r {
+		tokens, ok := parsePath(rulePath)
+		if !ok || (exact && len(tokens) != len(actual)) {
+			continue
+		}
+		weight := pathWeight(tokens, actual)
+		if weight > bestWeight || (weight == bestWeight && weight > 0 && len(tokens) > bestLength) {
+			best, bestWeight, bestLength = rules.GetRule(), weight, len(tokens)
+		}
+	}
+	return best
+}
+
+// add appends rules to a path
+func (r ruleSet) add(path string, rules ...*pb.MatchingRule) {
+	if r[path] == nil {
+		r[path] = &pb.MatchingRules{}
+	}
+	r[path].Rule = append(r[path].Rule, rules...)
+}
+
+// hasTypeRule returns true if any of the rules relaxes a collection to be matched by type
+func hasTypeRule(rules []*pb.MatchingRule) bool {
+	for _, rule := range rules {
+		switch rule.Type {
+		case "type", "min", "max", "notEmpty", "eachKey", "eachValue":
+			return true
+		}
+	}
+	return false
+}
+
+// hasKeyRule returns true if any of the rules matches the keys of a map, so that the keys of the
+// expected map are not required
+func hasKeyRule(rules []*pb.MatchingRule) bool {
+	for _, rule := range rules {
+		if rule.Type == "eachKey" || rule.Type == "eachValue" {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleLength returns the min or max length configured by a rule
+func ruleLength(rule *pb.MatchingRule, key string) (int, bool) {
+	value, ok := rule.GetValues().GetFields()[key]
+	if !ok {
+		return 0, false
+	}
+	return int(value.GetNumberValue()), true
+}
+
+// matchRule applies a single matching rule to an actual leaf value, returning a description
+// of the mismatch or an empty string if the value matches.
+func matchRule(rule *pb.MatchingRule, expected, actual any) string {
+	switch rule.Type {
+	case "type", "min", "max", "eachKey", "arrayContains":
+		// collection rules match the values of the collection by type
+		if fmt.Sprintf("%T", expected) != fmt.Sprintf("%T", actual) {
+			return fmt.Sprintf("Expected %s to be the same type as %s", renderValue(actual), renderValue(expected))
+		}
+	case "equality":
+		if !valuesEqual(expected, actual) {
+			return fmt.Sprintf("Expected %s to be equal to %s", renderValue(actual), renderValue(expected))
+		}
+	case "regex":
+		regex := rule.GetValues().GetFields()["regex"].GetStringValue()
+		// like the other Pact implementations, the regex must match the whole value
+		re, err := regexp.Compile(`^(?:` + regex + `)$`)
+		if err != nil {
+			return fmt.Sprintf("Invalid regex %q: %v", regex, err)
+		}
+		if !re.MatchString(matchableString(actual)) {
+			return fmt.Sprintf("Expected %s to match '%s'", renderValue(actual), regex)
+		}
+	case "include":
+		value := rule.GetValues().GetFields()["value"].GetStringValue()
+		if !strings.Contains(matchableString(actual), value) {
+			return fmt.Sprintf("Expected %s to include '%s'", renderValue(actual), value)
+		}
+	case "number":
+		switch actual.(type) {
+		case int64, float64, avroDecimal:
+		default:
+			return fmt.Sprintf("Expected %s to be a number", renderValue(actual))
+		}
+	case "integer":
+		if _, ok := actual.(int64); !ok {
+			return fmt.Sprintf("Expected %s to be an integer", renderValue(actual))
+		}
+	case "decimal":
+		switch actual.(type) {
+		case float64, avroDecimal:
+		default:
+			return fmt.Sprintf("Expected %s to be a decimal number", renderValue(actual))
+		}
+	case "datetime", "timestamp", "date", "time":
+		format := rule.GetValues().GetFields()["format"].GetStringValue()
+		layout, err := JavaDateLayout(format)
+		if err != nil {
+			return fmt.Sprintf("Invalid date format %q: %v", format, err)
+		}
+		if _, err := time.Parse(layout, matchableString(actual)); err != nil {
+			return fmt.Sprintf("Expected %s to match the date format '%s'", renderValue(actual), format)
+		}
+	case "boolean":
+		if _, ok := actual.(bool); !ok {
+			return fmt.Sprintf("Expected %s to be a boolean", renderValue(actual))
+		}
+	case "null":
+		if actual != nil {
+			return fmt.Sprintf("Expected %s to be null", renderValue(actual))
+		}
+	case "notEmpty":
+		if isEmptyValue(actual) {
+			return fmt.Sprintf("Expected %s to not be empty", renderValue(actual))
+		}
+	case "eachValue":
+		for _, nested := range nestedRules(rule) {
+			if mismatch := matchRule(nested, expected, actual); mismatch != "" {
+				return mismatch
+			}
+		}
+	default:
+		return fmt.Sprintf("Unsupported matching rule %s", rule.Type)
+	}
+	return ""
+}
+
+// matchableString returns the string form of a value used by the regex and include rules
+func matchableString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case nil:
+		return ""
+	}
+	return string(renderValue(value))
+}
+
+func isEmptyValue(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []byte:
+		return len(v) == 0
+	case []any:
+		return len(v) == 0
+	case map[string]any:
+		return len(v) == 0
+	}
+	return false
+}
+
+// definitionExtractor replaces matching rule definitions in a JSON message with their example
+// values, collecting the rules and generators keyed by path
+type definitionExtractor struct {
+	rules      ruleSet
+	generators map[string]*pb.Generator
+}
+
+// extract replaces a matching rule definition with its example value
+func (e *definitionExtractor) extract(path string, value any) (any, error) {
+	s, ok := value.(string)
+	if !ok || !IsMatchingRuleDefinition(s) {
+		return value, nil
+	}
+	def, err := ParseMatchingRuleDefinition(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(def.Rules) > 0 {
+		e.rules.add(path, def.Rules...)
+	}
+	if def.Generator != nil {
+		e.generators[path] = def.Generator
+	}
+	return def.Value, nil
+}
+
+// json walks a JSON message without a schema, extracting the definitions at every level
+func (e *definitionExtractor) json(path string, value any) (any, error) {
+	value, err := e.extract(path, value)
+	if err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, item := range v {
+			if result[key], err = e.json(PathField(path, key), item); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	case []any:
+		result := make([]any, len(v))
+		for i, item := range v {
+			if result[i], err = e.json(PathIndex(path, i), item); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+	return value, nil
+}
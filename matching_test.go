@@ -0,0 +1,346 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+// TestParseMatchingRuleDefinition tests parsing matching rule definitions into example values and rules
+func TestParseMatchingRuleDefinition(t *testing.T) {
+	tests := []struct {
+		expr      string
+		wantValue any
+		wantRules []*pb.MatchingRule
+		wantErr   bool
+	}{
+		{
+			expr:      "matching(type, 'Jane')",
+			wantValue: "Jane",
+			wantRules: []*pb.MatchingRule{newMatchingRule("type", nil)},
+		},
+		{
+			expr:      `matching(regex, '\d+@example\.com', '1@example.com')`,
+			wantValue: "1@example.com",
+			wantRules: []*pb.MatchingRule{newMatchingRule("regex", map[string]any{"regex": `\d+@example\.com`})},
+		},
+		{
+			expr:      "matching(equalTo, 42)",
+			wantValue: 42.0,
+			wantRules: []*pb.MatchingRule{newMatchingRule("equality", nil)},
+		},
+		{
+			expr:      "matching(integer, -7)",
+			wantValue: -7.0,
+			wantRules: []*pb.MatchingRule{newMatchingRule("integer", nil)},
+		},
+		{
+			expr:      "matching(boolean, true)",
+			wantValue: true,
+			wantRules: []*pb.MatchingRule{newMatchingRule("boolean", nil)},
+		},
+		{
+			expr:      "matching(include, 'Doe')",
+			wantValue: "Doe",
+			wantRules: []*pb.MatchingRule{newMatchingRule("include", map[string]any{"value": "Doe"})},
+		},
+		{
+			expr:      "matching(type, 'it\\'s'), notEmpty('it\\'s')",
+			wantValue: "it's",
+			wantRules: []*pb.MatchingRule{newMatchingRule("type", nil), newMatchingRule("notEmpty", nil)},
+		},
+		{
+			expr:      "matching(type, fromProviderState('${id}', 42))",
+			wantValue: 42.0,
+			wantRules: []*pb.MatchingRule{newMatchingRule("type", nil)},
+		},
+		{expr: "matching(type 'Jane')", wantErr: true},
+		{expr: "matching(unknown, 'Jane')", wantErr: true},
+		{expr: "matching(regex, '[', 'Jane')", wantErr: true},
+		{expr: "matching(type, 'Jane'", wantErr: true},
+		{expr: "matching(type, 'Jane') trailing", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			if !IsMatchingRuleDefinition(tt.expr) {
+				t.Fatalf("IsMatchingRuleDefinition(%q) = false", tt.expr)
+			}
+			def, err := ParseMatchingRuleDefinition(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMatchingRuleDefinition() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tt.wantValue, def.Value); diff != "" {
+				t.Errorf("ParseMatchingRuleDefinition() value mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantRules, def.Rules, protocmp.Transform()); diff != "" {
+				t.Errorf("ParseMatchingRuleDefinition() rules mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestRulesFor tests selecting the most specific rule path for a value
+func TestRulesFor(t *testing.T) {
+	rules := ruleSet{
+		"$.user":           {Rule: []*pb.MatchingRule{newMatchingRule("type", nil)}},
+		"$.user.email":     {Rule: []*pb.MatchingRule{newMatchingRule("regex", map[string]any{"regex": "@"})}},
+		"$.items[*].sku":   {Rule: []*pb.MatchingRule{newMatchingRule("include", map[string]any{"value": "SKU"})}},
+		"$['first-name']":  {Rule: []*pb.MatchingRule{newMatchingRule("notEmpty", nil)}},
+		"$.attributes.*":   {Rule: []*pb.MatchingRule{newMatchingRule("integer", nil)}},
+		"$.attributes.age": {Rule: []*pb.MatchingRule{newMatchingRule("equality", nil)}},
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "$.user.email", want: "regex"},
+		{path: "$.user.name", want: "type"},
+		{path: "$.items[3].sku", want: "include"},
+		{path: "$['first-name']", want: "notEmpty"},
+		{path: "$.attributes.height", want: "integer"},
+		{path: "$.attributes.age", want: "equality"},
+		{path: "$.other", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got := ""
+			if matched := rules.rulesFor(tt.path); len(matched) > 0 {
+				got = matched[0].Type
+			}
+			if got != tt.want {
+				t.Errorf("rulesFor(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCompareAvroWithRules tests that values are checked against matching rules instead of equality
+func TestCompareAvroWithRules(t *testing.T) {
+	schema, err := ParseAvroSchema(testUserSchema)
+	if err != nil {
+		t.Fatalf("failed to parse schema: %v", err)
+	}
+	expected, rules, _, err := ParseAvroMessage(schema, map[string]any{
+		"id":         "matching(regex, '^[0-9a-f-]{36}$', '94af717d-1b04-4fad-9879-01dc828e410d')",
+		"email":      "matching(type, 'jane.doe@example.com')",
+		"tags":       []any{"matching(include, 'a')"},
+		"attributes": map[string]any{"age": "matching(integer, 30)"},
+	})
+	if err != nil {
+		t.Fatalf("ParseAvroMessage() unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"$.attributes.age", "$.email", "$.id", "$.tags[0]"}, sortedKeys(rules)); diff != "" {
+		t.Errorf("ParseAvroMessage() rule paths mismatch (-want +got):\n%s", diff)
+	}
+
+	matching := map[string]any{
+		"id":         "0b9d4f8e-6f5e-4a43-9d4c-6d1b7d5e0e5b",
+		"email":      map[string]any{"string": "john@example.com"},
+		"tags":       []any{"abc"},
+		"attributes": map[string]any{"age": int64(41)},
+	}
+	if results := CompareAvro(schema, expected, matching, rules, false); len(results) != 0 {
+		t.Errorf("CompareAvro() expected no mismatches, got %v", results)
+	}
+
+	failing := map[string]any{
+		"id":         "not-a-uuid",
+		"email":      nil,
+		"tags":       []any{"xyz"},
+		"attributes": map[string]any{"age": int64(41)},
+	}
+	results := CompareAvro(schema, expected, failing, rules, false)
+	if diff := cmp.Diff([]string{"$.email", "$.id", "$.tags[0]"}, sortedKeys(results)); diff != "" {
+		t.Errorf("CompareAvro() mismatch paths (-want +got):\n%s", diff)
+	}
+}
+
+// TestMatchRuleRegex tests that a regex rule matches the whole value, not a part of it
+func TestMatchRuleRegex(t *testing.T) {
+	tests := []struct {
+		regex     string
+		actual    string
+		wantMatch bool
+	}{
+		{regex: `\d+`, actual: "123", wantMatch: true},
+		{regex: `\d+`, actual: "abc123xyz"},
+		{regex: `^\d+$`, actual: "123", wantMatch: true},
+		{regex: `cat|dog`, actual: "dog", wantMatch: true},
+		{regex: `cat|dog`, actual: "catdog"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.regex+" "+tt.actual, func(t *testing.T) {
+			mismatch := matchRule(newMatchingRule("regex", map[string]any{"regex": tt.regex}), "", tt.actual)
+			if (mismatch == "") != tt.wantMatch {
+				t.Errorf("matchRule() = %q, want match %v", mismatch, tt.wantMatch)
+			}
+		})
+	}
+}
+
+// TestFromProviderStateDefinition tests that fromProviderState declares a ProviderState generator
+func TestFromProviderStateDefinition(t *testing.T) {
+	def, err := ParseMatchingRuleDefinition("fromProviderState('/users/${id}', '/users/1')")
+	if err != nil {
+		t.Fatalf("ParseMatchingRuleDefinition() unexpected error: %v", err)
+	}
+	if def.Value != "/users/1" || len(def.Rules) != 0 {
+		t.Errorf("ParseMatchingRuleDefinition() = %v, %v", def.Value, def.Rules)
+	}
+	if def.Generator.GetType() != "ProviderState" {
+		t.Fatalf("expected a ProviderState generator, got %v", def.Generator)
+	}
+	values := def.Generator.Values.AsMap()
+	if values["expression"] != "/users/${id}" || values["dataType"] != "STRING" {
+		t.Errorf("unexpected generator values %v", values)
+	}
+}
+
+// TestParseCollectionDefinition tests parsing the collection rule definitions of collection matchers
+func TestParseCollectionDefinition(t *testing.T) {
+	tests := []struct {
+		expr      string
+		wantRules []*pb.MatchingRule
+		wantErr   bool
+	}{
+		{expr: "eachLike", wantRules: []*pb.MatchingRule{newMatchingRule("min", map[string]any{"min": 1})}},
+		{expr: "eachLike()", wantRules: []*pb.MatchingRule{newMatchingRule("min", map[string]any{"min": 1})}},
+		{expr: "eachLike(2)", wantRules: []*pb.MatchingRule{newMatchingRule("min", map[string]any{"min": 2})}},
+		{
+			expr: "atLeast(1), atMost(3)",
+			wantRules: []*pb.MatchingRule{
+				newMatchingRule("min", map[string]any{"min": 1}),
+				newMatchingRule("max", map[string]any{"max": 3}),
+			},
+		},
+		{
+			expr: "eachKey(matching(regex, '^[a-z]+$', 'colour'))",
+			wantRules: []*pb.MatchingRule{newMatchingRule("eachKey", map[string]any{
+				"rules": []any{map[string]any{"match": "regex", "regex": "^[a-z]+$"}},
+			})},
+		},
+		{
+			expr: "eachValue(matching(type, 'red'))",
+			wantRules: []*pb.MatchingRule{newMatchingRule("eachValue", map[string]any{
+				"rules": []any{map[string]any{"match": "type"}},
+			})},
+		},
+		{expr: "arrayContains", wantRules: []*pb.MatchingRule{newMatchingRule("arrayContains", nil)}},
+		{expr: "atLeast(-1)", wantErr: true},
+		{expr: "atMost()", wantErr: true},
+		{expr: "eachValue(fromProviderState('${id}', 1))", wantErr: true},
+		{expr: "eachOther(1)", wantErr: true},
+		{expr: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			rules, err := ParseCollectionDefinition(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCollectionDefinition() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.wantRules, rules, protocmp.Transform()); diff != "" {
+				t.Errorf("ParseCollectionDefinition() rules mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestCompareAvroCollections tests matching arrays, maps and unions with collection matchers
+func TestCompareAvroCollections(t *testing.T) {
+	schema, err := ParseAvroSchema(`{"type": "record", "name": "Order", "fields": [
+		{"name": "items", "type": {"type": "array", "items": {"type": "record", "name": "Item", "fields": [
+			{"name": "sku", "type": "string"},
+			{"name": "quantity", "type": "int"}
+		]}}},
+		{"name": "attributes", "type": {"type": "map", "values": "string"}},
+		{"name": "labels", "type": {"type": "array", "items": "string"}},
+		{"name": "notes", "type": ["null", {"type": "array", "items": "string"}], "default": null}
+	]}`)
+	if err != nil {
+		t.Fatalf("failed to parse schema: %v", err)
+	}
+	expected, rules, _, err := ParseAvroMessage(schema, map[string]any{
+		"items": map[string]any{
+			"pact:match": "eachLike, atMost(3)",
+			"value":      map[string]any{"sku": "matching(regex, '^SKU-\\d+$', 'SKU-1')", "quantity": "matching(integer, 1)"},
+		},
+		"attributes": map[string]any{
+			"pact:match": "eachKey(matching(regex, '^[a-z]+$', 'colour')), eachValue(matching(type, 'red'))",
+			"value":      map[string]any{"colour": "red"},
+		},
+		"labels": map[string]any{
+			"pact:match": "arrayContains",
+			"value":      []any{"new", "matching(regex, '^sale-\\d+$', 'sale-10')"},
+		},
+		"notes": map[string]any{"pact:match": "eachLike", "value": "matching(type, 'gift wrap')"},
+	})
+	if err != nil {
+		t.Fatalf("ParseAvroMessage() unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"$.attributes", "$.items", "$.items[*].quantity", "$.items[*].sku", "$.labels", "$.notes", "$.notes[*]"}, sortedKeys(rules)); diff != "" {
+		t.Errorf("ParseAvroMessage() rule paths mismatch (-want +got):\n%s", diff)
+	}
+
+	tests := []struct {
+		name      string
+		actual    map[string]any
+		wantPaths []string
+	}{
+		{
+			name: "matching",
+			actual: map[string]any{
+				"items": []any{
+					map[string]any{"sku": "SKU-7", "quantity": int64(2)},
+					map[string]any{"sku": "SKU-42", "quantity": int64(1)},
+				},
+				"attributes": map[string]any{"size": "L", "fit": "slim"},
+				"labels":     []any{"sale-25", "clearance", "new"},
+				"notes":      map[string]any{"array": []any{"leave at door", "fragile"}},
+			},
+		},
+		{
+			name: "mismatching",
+			actual: map[string]any{
+				"items": []any{
+					map[string]any{"sku": "SKU-7", "quantity": int64(2)},
+					map[string]any{"sku": "sku-8", "quantity": int64(1)},
+					map[string]any{"sku": "SKU-9", "quantity": int64(1)},
+					map[string]any{"sku": "SKU-10", "quantity": int64(1)},
+				},
+				"attributes": map[string]any{"Size": "L"},
+				"labels":     []any{"sale", "new"},
+				"notes":      nil,
+			},
+			wantPaths: []string{"$.attributes.Size", "$.items", "$.items[1].sku", "$.labels", "$.notes"},
+		},
+		{
+			name: "empty",
+			actual: map[string]any{
+				"items":      []any{},
+				"attributes": map[string]any{},
+				"labels":     []any{},
+				"notes":      map[string]any{"array": []any{}},
+			},
+			wantPaths: []string{"$.items", "$.labels", "$.notes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := CompareAvro(schema, expected, tt.actual, rules, false)
+			if diff := cmp.Diff(tt.wantPaths, sortedKeys(results), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("CompareAvro() mismatch paths (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"slices"
+
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// TOPIC_METADATA_KEY is the message metadata key of the topic of a record
+	TOPIC_METADATA_KEY = "kafka_topic"
+	// PARTITION_METADATA_KEY is the message metadata key of the partition of a record
+	PARTITION_METADATA_KEY = "kafka_partition"
+	// TIMESTAMP_METADATA_KEY is the message metadata key of the timestamp of a record
+	TIMESTAMP_METADATA_KEY = "kafka_timestamp"
+	// KEY_METADATA_KEY is the metadata key of the record key of a provider's message in verification results
+	KEY_METADATA_KEY = "kafka_key"
+)
+
+// recordMetadata is the message metadata of a Kafka record: its headers, topic, partition and
+// timestamp. Header values are strings, or []byte for binary headers, and the matching rules and
+// generators are keyed by the metadata key.
+type recordMetadata struct {
+	values     map[string]any
+	rules      ruleSet
+	generators map[string]*pb.Generator
+}
+
+// parseRecordMetadata parses the headers, topic, partition and timestamp of the contentsConfig. A
+// header value is a string, which may be a matching rule definition, or {"binary": "<base64>"} for a
+// header that is not UTF-8 text. The timestamp is either epoch milliseconds or a string.
+func parseRecordMetadata(fields map[string]*structpb.Value) (*recordMetadata, error) {
+	metadata := &recordMetadata{
+		values:     make(map[string]any),
+		rules:      make(ruleSet),
+		generators: make(map[string]*pb.Generator),
+	}
+	extractor := &definitionExtractor{rules: metadata.rules, generators: metadata.generators}
+
+	if headers, ok := fields["headers"]; ok {
+		headerFields := headers.GetStructValue().GetFields()
+		if headerFields == nil {
+			return nil, fmt.Errorf("headers field must be an object")
+		}
+		for name, value := range headerFields {
+			switch value.Kind.(type) {
+			case *structpb.Value_StringValue:
+				header, err := extractor.extract(name, value.GetStringValue())
+				if err != nil {
+					return nil, fmt.Errorf("headers: %w", err)
+				}
+				metadata.values[name] = header
+			case *structpb.Value_StructValue:
+				encoded, ok := value.GetStructValue().GetFields()["binary"]
+				if _, isString := encoded.GetKind().(*structpb.Value_StringValue); !ok || !isString {
+					return nil, fmt.Errorf("headers: binary header %s must be given as {\"binary\": \"<base64>\"}", name)
+				}
+				data, err := base64.StdEncoding.DecodeString(encoded.GetStringValue())
+				if err != nil {
+					return nil, fmt.Errorf("headers: failed to decode binary header %s: %w", name, err)
+				}
+				metadata.values[name] = data
+			default:
+				return nil, fmt.Errorf("headers: header %s must be a string or a binary value", name)
+			}
+		}
+	}
+
+	if topic, ok := fields["topic"]; ok {
+		if _, isString := topic.Kind.(*structpb.Value_StringValue); !isString {
+			return nil, fmt.Errorf("topic field must be a string")
+		}
+		value, err := extractor.extract(TOPIC_METADATA_KEY, topic.GetStringValue())
+		if err != nil {
+			return nil, err
+		}
+		metadata.values[TOPIC_METADATA_KEY] = value
+	}
+
+	if partition, ok := fields["partition"]; ok {
+		p := partition.GetNumberValue()
+		if _, isNumber := partition.Kind.(*structpb.Value_NumberValue); !isNumber || p < 0 || p > math.MaxInt32 || p != math.Trunc(p) {
+			return nil, fmt.Errorf("partition field must be a non-negative integer")
+		}
+		metadata.values[PARTITION_METADATA_KEY] = int64(p)
+	}
+
+	if timestamp, ok := fields["timestamp"]; ok {
+		switch timestamp.Kind.(type) {
+		case *structpb.Value_NumberValue:
+			ms := timestamp.GetNumberValue()
+			if ms != math.Trunc(ms) {
+				return nil, fmt.Errorf("timestamp field must be an integer number of milliseconds")
+			}
+			metadata.values[TIMESTAMP_METADATA_KEY] = int64(ms)
+		case *structpb.Value_StringValue:
+			value, err := extractor.extract(TIMESTAMP_METADATA_KEY, timestamp.GetStringValue())
+			if err != nil {
+				return nil, err
+			}
+			metadata.values[TIMESTAMP_METADATA_KEY] = value
+		default:
+			return nil, fmt.Errorf("timestamp field must be a number or a string")
+		}
+	}
+	return metadata, nil
+}
+
+// MessageMetadata returns the metadata as the MessageMetadata of an InteractionResponse. Binary
+// header values are base64 encoded, and their names are returned to be persisted in the interaction
+// configuration.
+func (m *recordMetadata) MessageMetadata() (*structpb.Struct, []string, error) {
+	fields := make(map[string]*structpb.Value, len(m.values))
+	var binary []string
+	for key, value := range m.values {
+		if data, ok := value.([]byte); ok {
+			value = base64.StdEncoding.EncodeToString(data)
+			binary = append(binary, key)
+		}
+		v, err := structpb.NewValue(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid metadata value %s: %w", key, err)
+		}
+		fields[key] = v
+	}
+	slices.Sort(binary)
+	return &structpb.Struct{Fields: fields}, binary, nil
+}
+
+// setMessageMetadata sets the message metadata, metadata rules and metadata generators of an
+// interaction. The names of the binary headers are added to its interaction configuration as
+// binaryHeaders.
+func setMessageMetadata(interaction *pb.InteractionResponse, metadata *recordMetadata) error {
+	if len(metadata.values) == 0 {
+		return nil
+	}
+	messageMetadata, binary, err := metadata.MessageMetadata()
+	if err != nil {
+		return err
+	}
+	interaction.MessageMetadata = messageMetadata
+	if len(metadata.rules) > 0 {
+		interaction.MetadataRules = metadata.rules
+	}
+	if len(metadata.generators) > 0 {
+		interaction.MetadataGenerators = metadata.generators
+	}
+
+	if len(binary) > 0 {
+		names := make([]any, len(binary))
+		for i, name := range binary {
+			names[i] = name
+		}
+		if interaction.PluginConfiguration == nil {
+			interaction.PluginConfiguration = &pb.PluginConfiguration{}
+		}
+		if interaction.PluginConfiguration.InteractionConfiguration == nil {
+			interaction.PluginConfiguration.InteractionConfiguration = &structpb.Struct{Fields: make(map[string]*structpb.Value)}
+		}
+		list, err := structpb.NewList(names)
+		if err != nil {
+			return err
+		}
+		interaction.PluginConfiguration.InteractionConfiguration.Fields["binaryHeaders"] = structpb.NewListValue(list)
+	}
+	return nil
+}
+
+// binaryHeaders returns the names of the binary headers persisted in the interaction configuration
+func binaryHeaders(config *structpb.Struct) []string {
+	var names []string
+	for _, name := range config.GetFields()["binaryHeaders"].GetListValue().GetValues() {
+		names = append(names, name.GetStringValue())
+	}
+	return names
+}
+
+// decodeMetadata converts the JSON message metadata of an interaction back to record metadata
+// values, decoding the base64 values of the binary headers
+func decodeMetadata(values map[string]any, binary []string) (map[string]any, error) {
+	decoded := make(map[string]any, len(values))
+	for key, value := range values {
+		switch v := value.(type) {
+		case string:
+			if slices.Contains(binary, key) {
+				data, err := base64.StdEncoding.DecodeString(v)
+				if err != nil {
+					return nil, fmt.Errorf("failed to decode binary header %s: %w", key, err)
+				}
+				value = data
+			}
+		case float64:
+			if v == math.Trunc(v) {
+				value = int64(v)
+			}
+		}
+		decoded[key] = value
+	}
+	return decoded, nil
+}
+
+// metadataValue converts a record metadata value to a MetadataValue, binary for []byte values
+func metadataValue(value any) (*pb.MetadataValue, error) {
+	if data, ok := value.([]byte); ok {
+		return &pb.MetadataValue{Value: &pb.MetadataValue_BinaryValue{BinaryValue: data}}, nil
+	}
+	if i, ok := value.(int64); ok {
+		value = float64(i)
+	}
+	v, err := structpb.NewValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.MetadataValue{Value: &pb.MetadataValue_NonBinaryValue{NonBinaryValue: v}}, nil
+}
+
+// CompareMetadata compares the expected metadata of a record with the actual metadata, applying the
+// metadata rules keyed by metadata key. Metadata that is not expected, such as additional headers,
+// is ignored. Text headers received as raw bytes are compared as strings. Provider verification
+// compares the metadata alongside the body, see verifyMessage.
+func CompareMetadata(expected, actual map[string]any, rules ruleSet) mismatches {
+	results := make(mismatches)
+	for _, key := range sortedKeys(expected) {
+		actualValue, ok := actual[key]
+		if !ok {
+			results.add(key, expected[key], nil, "Expected metadata '%s' but it was missing", key)
+			continue
+		}
+		if data, isBytes := actualValue.([]byte); isBytes {
+			if _, isString := expected[key].(string); isString {
+				actualValue = string(data)
+			}
+		}
+
+		keyRules := rules[key].GetRule()
+		if len(keyRules) == 0 {
+			if !valuesEqual(expected[key], actualValue) {
+				results.add(key, expected[key], actualValue, "Expected metadata '%s' to be %s but received %s", key, renderValue(expected[key]), renderValue(actualValue))
+			}
+			continue
+		}
+		for _, rule := range keyRules {
+			if mismatch := matchRule(rule, expected[key], actualValue); mismatch != "" {
+				results.add(key, expected[key], actualValue, "Metadata '%s': %s", key, mismatch)
+			}
+		}
+	}
+	return results.withType("metadata")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+)
+
+// metadataValues converts MetadataValues back to record metadata values, as the plugin receives them
+// in InteractionData
+func metadataValues(values map[string]*pb.MetadataValue) map[string]any {
+	result := make(map[string]any, len(values))
+	for key, value := range values {
+		switch v := value.GetValue().(type) {
+		case *pb.MetadataValue_BinaryValue:
+			result[key] = v.BinaryValue
+		case *pb.MetadataValue_NonBinaryValue:
+			result[key] = v.NonBinaryValue.AsInterface()
+			if f, ok := result[key].(float64); ok && f == math.Trunc(f) {
+				result[key] = int64(f)
+			}
+		}
+	}
+	return result
+}
+
+// TestCompareMetadata tests comparing the expected metadata of a record with the actual metadata
+func TestCompareMetadata(t *testing.T) {
+	rules := ruleSet{"ce_id": {Rule: []*pb.MatchingRule{newMatchingRule("type", nil)}}}
+	expected := map[string]any{
+		"event-type":           "user.created",
+		"ce_id":                "abc",
+		"checksum":             []byte{0xCA, 0xFE},
+		PARTITION_METADATA_KEY: int64(3),
+	}
+
+	tests := []struct {
+		name   string
+		actual map[string]any
+		want   []string
+	}{
+		{
+			name: "matching metadata with additional headers",
+			actual: map[string]any{
+				"event-type":           []byte("user.created"),
+				"ce_id":                "xyz",
+				"checksum":             []byte{0xCA, 0xFE},
+				"traceparent":          "00-01",
+				PARTITION_METADATA_KEY: int64(3),
+			},
+		},
+		{
+			name: "missing and different metadata",
+			actual: map[string]any{
+				"event-type": "user.deleted",
+				"ce_id":      int64(1),
+				"checksum":   []byte{0xBE, 0xEF},
+			},
+			want: []string{"ce_id", "checksum", "event-type", PARTITION_METADATA_KEY},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CompareMetadata(expected, tt.actual, rules)
+			if diff := cmp.Diff(tt.want, sortedKeys(got), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("CompareMetadata() mismatch keys (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestMetadataValue tests converting record metadata values to and from MetadataValues
+func TestMetadataValue(t *testing.T) {
+	values := map[string]any{"event-type": "user.created", "checksum": []byte{0xCA, 0xFE}, PARTITION_METADATA_KEY: int64(3)}
+	converted := make(map[string]*pb.MetadataValue, len(values))
+	for key, value := range values {
+		v, err := metadataValue(value)
+		if err != nil {
+			t.Fatalf("metadataValue(%v) unexpected error: %v", value, err)
+		}
+		converted[key] = v
+	}
+	if got := converted["checksum"].GetBinaryValue(); len(got) != 2 {
+		t.Errorf("metadataValue() checksum is not a binary value: %v", converted["checksum"])
+	}
+	if diff := cmp.Diff(values, metadataValues(converted)); diff != "" {
+		t.Errorf("metadataValues() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,720 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// MOCK_BROKER_NODE_ID is the node ID of the mock broker, which leads every partition and
+	// coordinates every group
+	MOCK_BROKER_NODE_ID = 1
+	// MOCK_BROKER_CLUSTER_ID is the cluster ID reported by the mock broker
+	MOCK_BROKER_CLUSTER_ID = "pact-kafka-plugin"
+	// MOCK_BROKER_MAX_REQUEST_SIZE is the size of the largest request the mock broker reads
+	MOCK_BROKER_MAX_REQUEST_SIZE = 100 << 20
+	// MOCK_BROKER_MAX_WAIT is the longest the mock broker holds a fetch that has no records to return
+	MOCK_BROKER_MAX_WAIT = 500 * time.Millisecond
+)
+
+const (
+	// CONSUMER_MODE is the mode of a mock server verifying that a consumer reads the pact's messages
+	CONSUMER_MODE = "consumer"
+	// PRODUCER_MODE is the mode of a mock server verifying that a producer sends the pact's messages
+	PRODUCER_MODE = "producer"
+)
+
+// mockServerConfig is the configuration of a mock server, from the test context of StartMockServer
+type mockServerConfig struct {
+	// mode is CONSUMER_MODE, the default, or PRODUCER_MODE
+	mode string
+	// allowUnexpectedKeys allows produced records to have fields the pact's messages do not
+	allowUnexpectedKeys bool
+}
+
+// parseMockServerConfig parses the test context of StartMockServer, in which the test framework
+// passes the transport configuration of the test. Its mode is consumer or producer, and fields added
+// by producers are allowed unless allowUnexpectedKeys is false, like in VerifyInteraction.
+func parseMockServerConfig(testContext *structpb.Struct) (*mockServerConfig, error) {
+	config := &mockServerConfig{mode: CONSUMER_MODE, allowUnexpectedKeys: true}
+	fields := testContext.GetFields()
+	if value, ok := fields["mode"]; ok {
+		config.mode = value.GetStringValue()
+		if config.mode != CONSUMER_MODE && config.mode != PRODUCER_MODE {
+			return nil, fmt.Errorf("mode must be %s or %s, got %s", CONSUMER_MODE, PRODUCER_MODE, renderValue(value.AsInterface()))
+		}
+	}
+	if value, ok := fields["allowUnexpectedKeys"]; ok {
+		if _, isBool := value.Kind.(*structpb.Value_BoolValue); !isBool {
+			return nil, fmt.Errorf("allowUnexpectedKeys must be a boolean, got %s", renderValue(value.AsInterface()))
+		}
+		config.allowUnexpectedKeys = value.GetBoolValue()
+	}
+	return config, nil
+}
+
+// mockBrokerVersions are the API versions supported by the mock broker, which are enough for
+// consumers to read the pact's messages, with or without a consumer group, and for producers to
+// send their records
+var mockBrokerVersions = map[kmsg.Key][2]int16{
+	kmsg.Produce:         {3, 9},
+	kmsg.Fetch:           {4, 12},
+	kmsg.ListOffsets:     {1, 7},
+	kmsg.Metadata:        {0, 9},
+	kmsg.OffsetCommit:    {0, 8},
+	kmsg.OffsetFetch:     {1, 7},
+	kmsg.FindCoordinator: {0, 3},
+	kmsg.JoinGroup:       {0, 7},
+	kmsg.Heartbeat:       {0, 4},
+	kmsg.LeaveGroup:      {0, 4},
+	kmsg.SyncGroup:       {0, 5},
+	kmsg.ApiVersions:     {0, 3},
+	kmsg.InitProducerID:  {0, 4},
+}
+
+// brokerRecord is a record of the mock broker, the message of an interaction or a record produced
+// to the broker, which has no interaction
+type brokerRecord struct {
+	interaction *pactInteraction
+	key         []byte
+	value       []byte
+	headers     []kmsg.Header
+	timestamp   int64
+	consumed    bool
+}
+
+// mockBroker is an in-memory Kafka broker started by StartMockServer. It serves the messages of a
+// pact on their topics, acts as the coordinator of the consumer groups reading them, and in producer
+// mode appends the records producers send to their partitions. The partitions fetched, the records
+// produced and the requests for topics the pact has no messages on are recorded for the mock server
+// results.
+type mockBroker struct {
+	key      string
+	pact     *pactFile
+	config   *mockServerConfig
+	host     string
+	port     int32
+	listener net.Listener
+
+	mu         sync.Mutex
+	topics     map[string][][]*brokerRecord
+	groups     map[string]*consumerGroup
+	conns      map[net.Conn]struct{}
+	fetched    map[topicPartition]struct{}
+	unexpected map[string]*pb.MockServerResult
+	produced   []*producedRecord
+	producerID int64
+
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+// newMockBroker creates the broker serving the pact's messages. Each message is appended to the
+// partition of its topic, the first one unless the interaction has a partition, in the order of the pact.
+func newMockBroker(pact *pactFile, config *mockServerConfig) (*mockBroker, error) {
+	broker := &mockBroker{
+		key:        uuid.New().String(),
+		pact:       pact,
+		config:     config,
+		topics:     make(map[string][][]*brokerRecord),
+		groups:     make(map[string]*consumerGroup),
+		conns:      make(map[net.Conn]struct{}),
+		fetched:    make(map[topicPartition]struct{}),
+		unexpected: make(map[string]*pb.MockServerResult),
+		closed:     make(chan struct{}),
+	}
+	now := time.Now().UnixMilli()
+	for _, interaction := range pact.messages() {
+		topic, partition, record, err := messageRecord(pact, interaction, now)
+		if err != nil {
+			return nil, err
+		}
+		partitions := broker.topics[topic]
+		for len(partitions) <= int(partition) {
+			partitions = append(partitions, nil)
+		}
+		partitions[partition] = append(partitions[partition], record)
+		broker.topics[topic] = partitions
+	}
+	return broker, nil
+}
+
+// messageRecord converts the message of an interaction to the record served on its topic and
+// partition. The record value is the contents of the interaction, its key is the key persisted with
+// the interaction, and its headers and timestamp are taken from the message metadata. A timestamp
+// that is not epoch milliseconds or an RFC 3339 date and time defaults to now.
+func messageRecord(pact *pactFile, interaction *pactInteraction, now int64) (string, int32, *brokerRecord, error) {
+	config, err := pact.pluginConfiguration(interaction)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	body, err := interaction.body()
+	if err != nil {
+		return "", 0, nil, err
+	}
+	metadata, err := interaction.expectedMetadata(config)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("invalid metadata of interaction %q: %w", interaction.Description, err)
+	}
+
+	topic, _ := metadata[TOPIC_METADATA_KEY].(string)
+	if topic == "" {
+		return "", 0, nil, fmt.Errorf("interaction %q has no topic to be served on", interaction.Description)
+	}
+	var partition int32
+	if p, ok := metadata[PARTITION_METADATA_KEY].(int64); ok && p >= 0 && p <= math.MaxInt32 {
+		partition = int32(p)
+	}
+	key, err := loadRecordKey(config)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("invalid key of interaction %q: %w", interaction.Description, err)
+	}
+	record := &brokerRecord{interaction: interaction, timestamp: now}
+	if value := body.GetContent().GetValue(); len(value) > 0 {
+		record.value = value
+	}
+	if key != nil {
+		record.key = key.body.Content.GetValue()
+	}
+	switch timestamp := metadata[TIMESTAMP_METADATA_KEY].(type) {
+	case int64:
+		record.timestamp = timestamp
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+			record.timestamp = t.UnixMilli()
+		}
+	}
+
+	// the other metadata values are the record headers, ordered by name
+	for _, name := range slices.Sorted(maps.Keys(metadata)) {
+		if strings.HasPrefix(name, "kafka_") {
+			continue
+		}
+		switch value := metadata[name].(type) {
+		case []byte:
+			record.headers = append(record.headers, kmsg.Header{Key: name, Value: value})
+		case string:
+			record.headers = append(record.headers, kmsg.Header{Key: name, Value: []byte(value)})
+		default:
+			record.headers = append(record.headers, kmsg.Header{Key: name, Value: []byte(fmt.Sprint(value))})
+		}
+	}
+	return topic, partition, record, nil
+}
+
+// start listens on the host and port, the loopback interface and a random port by default, and
+// serves connections until the broker is shut down
+func (b *mockBroker) start(host string, port uint32) error {
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s:%d: %w", host, port, err)
+	}
+	addr := listener.Addr().(*net.TCPAddr)
+	b.listener = listener
+	b.port = int32(addr.Port)
+	// clients connect to the advertised address, which can not be the unspecified address
+	b.host = host
+	if addr.IP.IsUnspecified() {
+		b.host = "localhost"
+	}
+
+	b.wg.Add(1)
+	go b.serve()
+	return nil
+}
+
+// serve accepts connections and handles the requests of each one in turn
+func (b *mockBroker) serve() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		// a connection accepted while shutting down is not tracked, so it is closed here
+		b.mu.Lock()
+		select {
+		case <-b.closed:
+			b.mu.Unlock()
+			// nolint:errcheck
+			conn.Close()
+			return
+		default:
+		}
+		b.conns[conn] = struct{}{}
+		b.mu.Unlock()
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.handle(conn)
+		}()
+	}
+}
+
+// shutdown stops the broker, closing its listener and connections
+func (b *mockBroker) shutdown() {
+	close(b.closed)
+	// nolint:errcheck
+	b.listener.Close()
+	b.mu.Lock()
+	for conn := range b.conns {
+		// nolint:errcheck
+		conn.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+// requestHeader is the header of a Kafka request
+type requestHeader struct {
+	key           int16
+	version       int16
+	correlationID int32
+	clientID      string
+}
+
+// handle reads the size delimited requests of a connection and writes their responses, until the
+// connection is closed or sends a request the broker does not support
+func (b *mockBroker) handle(conn net.Conn) {
+	defer func() {
+		// nolint:errcheck
+		conn.Close()
+		b.mu.Lock()
+		delete(b.conns, conn)
+		b.mu.Unlock()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		var size int32
+		if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size < 8 || size > MOCK_BROKER_MAX_REQUEST_SIZE {
+			slog.Debug("mock broker received an invalid request size", "size", size)
+			return
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return
+		}
+		header, req, err := parseRequest(data)
+		if err != nil {
+			slog.Debug("mock broker failed to read a request", "error", err)
+			return
+		}
+		resp, err := b.respond(header, req)
+		if err != nil {
+			slog.Debug("mock broker received an unexpected request", "error", err)
+			name := kmsg.NameForKey(header.key)
+			b.mu.Lock()
+			b.unexpectedRequest("request "+name, name, "Unexpected %s request, which the mock server does not support", name)
+			b.mu.Unlock()
+			return
+		}
+		if resp == nil {
+			continue
+		}
+		if _, err := conn.Write(encodeResponse(header.correlationID, resp)); err != nil {
+			return
+		}
+	}
+}
+
+// parseRequest parses the header and body of a request. The request of a supported ApiVersions
+// version is nil, which is answered with the versions of the broker as Kafka does.
+func parseRequest(data []byte) (*requestHeader, kmsg.Request, error) {
+	header := &requestHeader{
+		key:           int16(binary.BigEndian.Uint16(data)),
+		version:       int16(binary.BigEndian.Uint16(data[2:])),
+		correlationID: int32(binary.BigEndian.Uint32(data[4:])),
+	}
+	versions, ok := mockBrokerVersions[kmsg.Key(header.key)]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported request %s", kmsg.NameForKey(header.key))
+	}
+	if header.version < versions[0] || header.version > versions[1] {
+		if kmsg.Key(header.key) == kmsg.ApiVersions {
+			return header, nil, nil
+		}
+		return nil, nil, fmt.Errorf("unsupported version %d of request %s", header.version, kmsg.NameForKey(header.key))
+	}
+	req := kmsg.RequestForKey(header.key)
+	req.SetVersion(header.version)
+
+	rest := data[8:]
+	if len(rest) < 2 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	if n := int16(binary.BigEndian.Uint16(rest)); n > 0 {
+		if len(rest) < 2+int(n) {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		header.clientID = string(rest[2 : 2+n])
+		rest = rest[2+n:]
+	} else {
+		rest = rest[2:]
+	}
+	// flexible requests have tagged fields in their header, none of which the broker uses
+	if req.IsFlexible() {
+		tags := &tagReader{src: rest}
+		kmsg.SkipTags(tags)
+		if tags.failed {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		rest = tags.src
+	}
+	if err := req.ReadFrom(rest); err != nil {
+		return nil, nil, fmt.Errorf("invalid %s request: %w", kmsg.NameForKey(header.key), err)
+	}
+	return header, req, nil
+}
+
+// tagReader reads the tagged fields of a request header for kmsg.SkipTags
+type tagReader struct {
+	src    []byte
+	failed bool
+}
+
+func (r *tagReader) Uvarint() uint32 {
+	v, n := binary.Uvarint(r.src)
+	if n <= 0 || v > math.MaxUint32 {
+		r.failed = true
+		r.src = nil
+		return 0
+	}
+	r.src = r.src[n:]
+	return uint32(v)
+}
+
+func (r *tagReader) Span(n int) []byte {
+	if n < 0 || n > len(r.src) {
+		r.failed = true
+		r.src = nil
+		return nil
+	}
+	span := r.src[:n]
+	r.src = r.src[n:]
+	return span
+}
+
+// encodeResponse encodes a size delimited response. The header of a flexible response has tagged
+// fields, except for ApiVersions, whose header clients read before knowing the broker's versions.
+func encodeResponse(correlationID int32, resp kmsg.Response) []byte {
+	buf := binary.BigEndian.AppendUint32(make([]byte, 4, 64), uint32(correlationID))
+	if resp.IsFlexible() && resp.Key() != kmsg.ApiVersions.Int16() {
+		buf = append(buf, 0)
+	}
+	buf = resp.AppendTo(buf)
+	binary.BigEndian.PutUint32(buf, uint32(len(buf)-4))
+	return buf
+}
+
+// respond handles a request, returning nil for a request that has no response, and an error for a
+// request the broker has no handler for
+func (b *mockBroker) respond(header *requestHeader, req kmsg.Request) (kmsg.Response, error) {
+	switch r := req.(type) {
+	case nil:
+		resp := kmsg.NewPtrApiVersionsResponse()
+		resp.ErrorCode = kerr.UnsupportedVersion.Code
+		resp.ApiKeys = apiVersions()
+		return resp, nil
+	case *kmsg.ApiVersionsRequest:
+		resp := r.ResponseKind().(*kmsg.ApiVersionsResponse)
+		resp.ApiKeys = apiVersions()
+		return resp, nil
+	case *kmsg.MetadataRequest:
+		return b.metadata(r), nil
+	case *kmsg.ListOffsetsRequest:
+		return b.listOffsets(r), nil
+	case *kmsg.ProduceRequest:
+		return b.produce(r), nil
+	case *kmsg.InitProducerIDRequest:
+		return b.initProducerID(r), nil
+	case *kmsg.FetchRequest:
+		return b.fetch(r), nil
+	case *kmsg.FindCoordinatorRequest:
+		return b.findCoordinator(r), nil
+	case *kmsg.JoinGroupRequest:
+		return b.joinGroup(header, r), nil
+	case *kmsg.SyncGroupRequest:
+		return b.syncGroup(r), nil
+	case *kmsg.HeartbeatRequest:
+		return b.heartbeat(r), nil
+	case *kmsg.LeaveGroupRequest:
+		return b.leaveGroup(r), nil
+	case *kmsg.OffsetCommitRequest:
+		return b.offsetCommit(r), nil
+	case *kmsg.OffsetFetchRequest:
+		return b.offsetFetch(r), nil
+	}
+	// parseRequest only returns the requests above, a request added to mockBrokerVersions without a
+	// handler is reported instead of failing the plugin
+	return nil, fmt.Errorf("unhandled request %s", kmsg.NameForKey(req.Key()))
+}
+
+// apiVersions returns the API versions of the broker, ordered by key
+func apiVersions() []kmsg.ApiVersionsResponseApiKey {
+	keys := make([]kmsg.ApiVersionsResponseApiKey, 0, len(mockBrokerVersions))
+	for key, versions := range mockBrokerVersions {
+		keys = append(keys, kmsg.ApiVersionsResponseApiKey{ApiKey: key.Int16(), MinVersion: versions[0], MaxVersion: versions[1]})
+	}
+	slices.SortFunc(keys, func(a, b kmsg.ApiVersionsResponseApiKey) int { return int(a.ApiKey) - int(b.ApiKey) })
+	return keys
+}
+
+// metadata describes the broker and the requested topics, or all of them. Topics are not created
+// on request, so those without messages in the pact are unknown.
+func (b *mockBroker) metadata(req *kmsg.MetadataRequest) kmsg.Response {
+	resp := req.ResponseKind().(*kmsg.MetadataResponse)
+	resp.Brokers = []kmsg.MetadataResponseBroker{{NodeID: MOCK_BROKER_NODE_ID, Host: b.host, Port: b.port}}
+	resp.ClusterID = kmsg.StringPtr(MOCK_BROKER_CLUSTER_ID)
+	resp.ControllerID = MOCK_BROKER_NODE_ID
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var names []string
+	// a null topic list is all topics, as is an empty one before version 1
+	if req.Topics == nil || (req.Version == 0 && len(req.Topics) == 0) {
+		names = slices.Sorted(maps.Keys(b.topics))
+	} else {
+		for _, topic := range req.Topics {
+			if topic.Topic != nil {
+				names = append(names, *topic.Topic)
+			}
+		}
+	}
+	for _, name := range names {
+		topic := kmsg.NewMetadataResponseTopic()
+		topic.Topic = kmsg.StringPtr(name)
+		partitions, ok := b.topics[name]
+		if !ok {
+			topic.ErrorCode = kerr.UnknownTopicOrPartition.Code
+			b.unexpectedRequest(name, "Metadata "+name, "Unexpected subscription to topic %s, which has no messages in the pact", name)
+		}
+		for i := range partitions {
+			partition := kmsg.NewMetadataResponseTopicPartition()
+			partition.Partition = int32(i)
+			partition.Leader = MOCK_BROKER_NODE_ID
+			partition.Replicas = []int32{MOCK_BROKER_NODE_ID}
+			partition.ISR = []int32{MOCK_BROKER_NODE_ID}
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		resp.Topics = append(resp.Topics, topic)
+	}
+	return resp
+}
+
+// partition returns the records of a partition, and false if the broker does not have it
+func (b *mockBroker) partition(topic string, partition int32) ([]*brokerRecord, bool) {
+	partitions, ok := b.topics[topic]
+	if !ok || partition < 0 || int(partition) >= len(partitions) {
+		return nil, false
+	}
+	return partitions[partition], true
+}
+
+// listOffsets returns the earliest offset of partitions, which is always 0, their latest offset,
+// or the offset of their first record at or after a timestamp
+func (b *mockBroker) listOffsets(req *kmsg.ListOffsetsRequest) kmsg.Response {
+	resp := req.ResponseKind().(*kmsg.ListOffsetsResponse)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, reqTopic := range req.Topics {
+		topic := kmsg.NewListOffsetsResponseTopic()
+		topic.Topic = reqTopic.Topic
+		for _, reqPartition := range reqTopic.Partitions {
+			partition := kmsg.NewListOffsetsResponseTopicPartition()
+			partition.Partition = reqPartition.Partition
+			records, ok := b.partition(reqTopic.Topic, reqPartition.Partition)
+			switch {
+			case !ok:
+				partition.ErrorCode = kerr.UnknownTopicOrPartition.Code
+				tp := topicPartition{reqTopic.Topic, reqPartition.Partition}
+				b.unexpectedRequest(tp.topic, "ListOffsets "+tp.String(), "Unexpected offset lookup of partition %s, which has no messages in the pact", tp)
+			case reqPartition.Timestamp == -2:
+				partition.Offset = 0
+			case reqPartition.Timestamp < 0:
+				partition.Offset = int64(len(records))
+			default:
+				index := slices.IndexFunc(records, func(r *brokerRecord) bool { return r.timestamp >= reqPartition.Timestamp })
+				if index < 0 {
+					partition.Offset = int64(len(records))
+				} else {
+					partition.Offset = int64(index)
+					partition.Timestamp = records[index].timestamp
+				}
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		resp.Topics = append(resp.Topics, topic)
+	}
+	return resp
+}
+
+// fetch returns the records of partitions from their fetch offsets, as one batch per partition.
+// A fetch with no records to return is held for its maximum wait, so that consumers polling the
+// broker do not spin.
+func (b *mockBroker) fetch(req *kmsg.FetchRequest) kmsg.Response {
+	resp, found := b.fetchRecords(req)
+	if !found && req.MaxWaitMillis > 0 {
+		wait := min(time.Duration(req.MaxWaitMillis)*time.Millisecond, MOCK_BROKER_MAX_WAIT)
+		select {
+		case <-time.After(wait):
+		case <-b.closed:
+		}
+	}
+	return resp
+}
+
+// fetchRecords builds the response of a fetch request, and whether it has any records
+func (b *mockBroker) fetchRecords(req *kmsg.FetchRequest) (*kmsg.FetchResponse, bool) {
+	resp := req.ResponseKind().(*kmsg.FetchResponse)
+	found := false
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, reqTopic := range req.Topics {
+		topic := kmsg.NewFetchResponseTopic()
+		topic.Topic = reqTopic.Topic
+		for _, reqPartition := range reqTopic.Partitions {
+			partition := kmsg.NewFetchResponseTopicPartition()
+			partition.Partition = reqPartition.Partition
+			tp := topicPartition{reqTopic.Topic, reqPartition.Partition}
+			records, ok := b.partition(reqTopic.Topic, reqPartition.Partition)
+			end := int64(len(records))
+			if ok {
+				b.fetched[tp] = struct{}{}
+			}
+			switch {
+			case !ok:
+				partition.ErrorCode = kerr.UnknownTopicOrPartition.Code
+				partition.HighWatermark, partition.LastStableOffset = -1, -1
+				b.unexpectedRequest(tp.topic, "Fetch "+tp.String(), "Unexpected fetch of partition %s, which has no messages in the pact", tp)
+			case reqPartition.FetchOffset < 0 || reqPartition.FetchOffset > end:
+				partition.ErrorCode = kerr.OffsetOutOfRange.Code
+				partition.HighWatermark, partition.LastStableOffset = end, end
+			default:
+				partition.HighWatermark, partition.LastStableOffset = end, end
+				partition.LogStartOffset = 0
+				if reqPartition.FetchOffset < end {
+					fetched := records[reqPartition.FetchOffset:]
+					partition.RecordBatches = recordBatch(reqPartition.FetchOffset, fetched)
+					for _, record := range fetched {
+						record.consumed = true
+					}
+					found = true
+				}
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		resp.Topics = append(resp.Topics, topic)
+	}
+	return resp, found
+}
+
+// castagnoli is the CRC table of record batches
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// recordBatch encodes records as an uncompressed batch of the version 2 message format, starting
+// at an offset
+func recordBatch(offset int64, records []*brokerRecord) []byte {
+	first, last := records[0].timestamp, records[0].timestamp
+	for _, record := range records {
+		first, last = min(first, record.timestamp), max(last, record.timestamp)
+	}
+	var encoded []byte
+	for i, record := range records {
+		r := kmsg.Record{
+			TimestampDelta64: record.timestamp - first,
+			OffsetDelta:      int32(i),
+			Key:              record.key,
+			Value:            record.value,
+			Headers:          record.headers,
+		}
+		// the length of a record is that of everything after it, which is found by encoding the
+		// record with a zero length, a single byte varint
+		r.Length = int32(len(r.AppendTo(nil)) - 1)
+		encoded = r.AppendTo(encoded)
+	}
+
+	batch := kmsg.RecordBatch{
+		FirstOffset:     offset,
+		Magic:           2,
+		LastOffsetDelta: int32(len(records) - 1),
+		FirstTimestamp:  first,
+		MaxTimestamp:    last,
+		ProducerID:      -1,
+		ProducerEpoch:   -1,
+		FirstSequence:   -1,
+		NumRecords:      int32(len(records)),
+		Records:         encoded,
+	}
+	data := batch.AppendTo(nil)
+	// the length follows the first offset and itself, and the CRC covers everything after the
+	// attributes offset
+	binary.BigEndian.PutUint32(data[8:], uint32(len(data)-12))
+	binary.BigEndian.PutUint32(data[17:], crc32.Checksum(data[21:], castagnoli))
+	return data
+}
+
+// mockBrokers are the mock servers started by the plugin, by key
+type mockBrokers struct {
+	mu      sync.Mutex
+	brokers map[string]*mockBroker
+}
+
+// mockServers are the mock brokers shared by all requests to the plugin
+var mockServers = &mockBrokers{brokers: make(map[string]*mockBroker)}
+
+// errMockServerNotFound is returned for the key of a mock server that is not running
+var errMockServerNotFound = errors.New("mock server not found")
+
+func (m *mockBrokers) add(broker *mockBroker) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.brokers[broker.key] = broker
+}
+
+func (m *mockBrokers) get(key string) (*mockBroker, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	broker, ok := m.brokers[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errMockServerNotFound, key)
+	}
+	return broker, nil
+}
+
+func (m *mockBrokers) remove(key string) (*mockBroker, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	broker, ok := m.brokers[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errMockServerNotFound, key)
+	}
+	delete(m.brokers, key)
+	return broker, nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// MOCK_BROKER_SYNC_TIMEOUT is how long a member waits in SyncGroup for the leader's assignments
+const MOCK_BROKER_SYNC_TIMEOUT = 10 * time.Second
+
+// consumerGroup is a group coordinated by the mock broker, and the offsets committed by it. A member
+// joining, leaving or changing its protocols starts a new generation. Members are answered as soon
+// as they join, and those that are not the leader wait in SyncGroup for the assignments it sends.
+type consumerGroup struct {
+	protocolType string
+	protocol     string
+	generation   int32
+	leader       string
+	members      []*groupMember
+	assignments  map[string][]byte
+	synced       chan struct{}
+	offsets      map[string]map[int32]int64
+}
+
+// groupMember is a member of a consumer group, with the protocols it joined with
+type groupMember struct {
+	id         string
+	instanceID *string
+	protocols  []kmsg.JoinGroupRequestProtocol
+}
+
+// group returns the group of a name, creating it on first use. The broker must be locked.
+func (b *mockBroker) group(name string) *consumerGroup {
+	g, ok := b.groups[name]
+	if !ok {
+		g = &consumerGroup{synced: make(chan struct{}), offsets: make(map[string]map[int32]int64)}
+		b.groups[name] = g
+	}
+	return g
+}
+
+// member returns the member of an ID, or nil if it is not in the group
+func (g *consumerGroup) member(id string) *groupMember {
+	index := slices.IndexFunc(g.members, func(m *groupMember) bool { return m.id == id })
+	if index < 0 {
+		return nil
+	}
+	return g.members[index]
+}
+
+// commonProtocol returns the first protocol of a member that all members of the group support
+func (g *consumerGroup) commonProtocol(member *groupMember) (string, bool) {
+	for _, protocol := range member.protocols {
+		supported := func(m *groupMember) bool {
+			return slices.ContainsFunc(m.protocols, func(p kmsg.JoinGroupRequestProtocol) bool { return p.Name == protocol.Name })
+		}
+		if !slices.ContainsFunc(g.members, func(m *groupMember) bool { return !supported(m) }) {
+			return protocol.Name, true
+		}
+	}
+	return "", false
+}
+
+// rebalance starts a new generation of the group. The leader stays the same while it is a member.
+func (g *consumerGroup) rebalance() {
+	g.generation++
+	g.assignments = nil
+	g.synced = make(chan struct{})
+	if len(g.members) == 0 {
+		g.leader, g.protocol = "", ""
+		return
+	}
+	if g.member(g.leader) == nil {
+		g.leader = g.members[0].id
+	}
+	g.protocol, _ = g.commonProtocol(g.member(g.leader))
+}
+
+// findCoordinator returns the broker as the coordinator of every group and transaction
+func (b *mockBroker) findCoordinator(req *kmsg.FindCoordinatorRequest) kmsg.Response {
+	resp := req.ResponseKind().(*kmsg.FindCoordinatorResponse)
+	resp.NodeID = MOCK_BROKER_NODE_ID
+	resp.Host = b.host
+	resp.Port = b.port
+	return resp
+}
+
+// joinGroup adds a member to a group, or updates the protocols of one rejoining it. The leader is
+// sent the members of the generation, to assign them the partitions of their subscriptions.
+func (b *mockBroker) joinGroup(header *requestHeader, req *kmsg.JoinGroupRequest) kmsg.Response {
+	resp := req.ResponseKind().(*kmsg.JoinGroupResponse)
+	resp.MemberID = req.MemberID
+	if req.Group == "" {
+		resp.ErrorCode = kerr.InvalidGroupID.Code
+		return resp
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g := b.group(req.Group)
+	if len(req.Protocols) == 0 || (len(g.members) > 0 && req.ProtocolType != g.protocolType) {
+		resp.ErrorCode = kerr.InconsistentGroupProtocol.Code
+		return resp
+	}
+
+	joining := &groupMember{id: req.MemberID, instanceID: req.InstanceID, protocols: req.Protocols}
+	member := g.member(req.MemberID)
+	switch {
+	case req.MemberID != "" && member == nil:
+		resp.ErrorCode = kerr.UnknownMemberID.Code
+		return resp
+	case member == nil:
+		if _, ok := g.commonProtocol(joining); !ok {
+			resp.ErrorCode = kerr.InconsistentGroupProtocol.Code
+			return resp
+		}
+		joining.id = header.clientID + "-" + uuid.New().String()
+		g.members = append(g.members, joining)
+		g.protocolType = req.ProtocolType
+		g.rebalance()
+	case !slices.EqualFunc(member.protocols, joining.protocols, sameProtocol):
+		member.protocols = joining.protocols
+		g.rebalance()
+	}
+
+	resp.MemberID = joining.id
+	resp.Generation = g.generation
+	resp.ProtocolType = kmsg.StringPtr(g.protocolType)
+	resp.Protocol = kmsg.StringPtr(g.protocol)
+	resp.LeaderID = g.leader
+	if joining.id == g.leader {
+		for _, m := range g.members {
+			member := kmsg.JoinGroupResponseMember{MemberID: m.id, InstanceID: m.instanceID}
+			if index := slices.IndexFunc(m.protocols, func(p kmsg.JoinGroupRequestProtocol) bool { return p.Name == g.protocol }); index >= 0 {
+				member.ProtocolMetadata = m.protocols[index].Metadata
+			}
+			resp.Members = append(resp.Members, member)
+		}
+	}
+	return resp
+}
+
+// sameProtocol returns true if two group protocols have the same name and metadata
+func sameProtocol(a, b kmsg.JoinGroupRequestProtocol) bool {
+	return a.Name == b.Name && slices.Equal(a.Metadata, b.Metadata)
+}
+
+// syncGroup stores the assignments sent by the leader of a generation, and returns each member its
+// assignment once the leader has sent them
+func (b *mockBroker) syncGroup(req *kmsg.SyncGroupRequest) kmsg.Response {
+	resp := req.ResponseKind().(*kmsg.SyncGroupResponse)
+
+	b.mu.Lock()
+	g, ok := b.groups[req.Group]
+	switch {
+	case !ok || g.member(req.MemberID) == nil:
+		resp.ErrorCode = kerr.UnknownMemberID.Code
+	case req.Generation != g.generation:
+		resp.ErrorCode = kerr.IllegalGeneration.Code
+	case req.MemberID == g.leader:
+		g.assignments = make(map[string][]byte, len(req.GroupAssignment))
+		for _, assignment := range req.GroupAssignment {
+			g.assignments[assignment.MemberID] = assignment.MemberAssignment
+		}
+		// the leader may sync again without a rebalance
+		select {
+		case <-g.synced:
+		default:
+			close(g.synced)
+		}
+	}
+	if resp.ErrorCode != 0 {
+		b.mu.Unlock()
+		return resp
+	}
+	synced, generation := g.synced, g.generation
+	b.mu.Unlock()
+
+	select {
+	case <-synced:
+	case <-b.closed:
+		resp.ErrorCode = kerr.RebalanceInProgress.Code
+		return resp
+	case <-time.After(MOCK_BROKER_SYNC_TIMEOUT):
+		resp.ErrorCode = kerr.RebalanceInProgress.Code
+		return resp
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if g.generation != generation {
+		resp.ErrorCode = kerr.RebalanceInProgress.Code
+		return resp
+	}
+	resp.ProtocolType = kmsg.StringPtr(g.protocolType)
+	resp.Protocol = kmsg.StringPtr(g.protocol)
+	resp.MemberAssignment = g.assignments[req.MemberID]
+	return resp
+}
+
+// heartbeat tells a member whether the group is rebalancing, for it to rejoin
+func (b *mockBroker) heartbeat(req *kmsg.HeartbeatRequest) kmsg.Response {
+	resp := req.ResponseKind().(*kmsg.HeartbeatResponse)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g, ok := b.groups[req.Group]
+	switch {
+	case !ok || g.member(req.MemberID) == nil:
+		resp.ErrorCode = kerr.UnknownMemberID.Code
+	case req.Generation != g.generation:
+		resp.ErrorCode = kerr.RebalanceInProgress.Code
+	}
+	return resp
+}
+
+// leaveGroup removes members from a group, which rebalances the members that remain
+func (b *mockBroker) leaveGroup(req *kmsg.LeaveGroupRequest) kmsg.Response {
+	resp := req.ResponseKind().(*kmsg.LeaveGroupResponse)
+	ids := []string{req.MemberID}
+	if req.Version >= 3 {
+		ids = nil
+		for _, member := range req.Members {
+			ids = append(ids, member.MemberID)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g := b.group(req.Group)
+	left := false
+	for _, id := range ids {
+		var errorCode int16
+		if g.member(id) == nil {
+			errorCode = kerr.UnknownMemberID.Code
+		} else {
+			g.members = slices.DeleteFunc(g.members, func(m *groupMember) bool { return m.id == id })
+			left = true
+		}
+		if req.Version >= 3 {
+			resp.Members = append(resp.Members, kmsg.LeaveGroupResponseMember{MemberID: id, ErrorCode: errorCode})
+		} else {
+			resp.ErrorCode = errorCode
+		}
+	}
+	if left {
+		g.rebalance()
+	}
+	return resp
+}
+
+// offsetCommit stores the offsets committed by a group. Consumers assigning partitions themselves
+// commit without a generation, and those in a group commit in its current generation.
+func (b *mockBroker) offsetCommit(req *kmsg.OffsetCommitRequest) kmsg.Response {
+	resp := req.ResponseKind().(*kmsg.OffsetCommitResponse)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g := b.group(req.Group)
+
+	var groupError int16
+	if req.Generation >= 0 && req.MemberID != "" {
+		if g.member(req.MemberID) == nil {
+			groupError = kerr.UnknownMemberID.Code
+		} else if req.Generation != g.generation {
+			groupError = kerr.IllegalGeneration.Code
+		}
+	}
+	for _, reqTopic := range req.Topics {
+		topic := kmsg.NewOffsetCommitResponseTopic()
+		topic.Topic = reqTopic.Topic
+		for _, reqPartition := range reqTopic.Partitions {
+			partition := kmsg.NewOffsetCommitResponseTopicPartition()
+			partition.Partition = reqPartition.Partition
+			partition.ErrorCode = groupError
+			if _, ok := b.partition(reqTopic.Topic, reqPartition.Partition); !ok && groupError == 0 {
+				partition.ErrorCode = kerr.UnknownTopicOrPartition.Code
+				tp := topicPartition{reqTopic.Topic, reqPartition.Partition}
+				b.unexpectedRequest(tp.topic, "OffsetCommit "+tp.String(), "Unexpected offset commit of partition %s, which has no messages in the pact", tp)
+			}
+			if partition.ErrorCode == 0 {
+				if g.offsets[reqTopic.Topic] == nil {
+					g.offsets[reqTopic.Topic] = make(map[int32]int64)
+				}
+				g.offsets[reqTopic.Topic][reqPartition.Partition] = reqPartition.Offset
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		resp.Topics = append(resp.Topics, topic)
+	}
+	return resp
+}
+
+// offsetFetch returns the offsets committed by a group, -1 for partitions it has not committed.
+// A null topic list is all the topics the group has committed offsets for.
+func (b *mockBroker) offsetFetch(req *kmsg.OffsetFetchRequest) kmsg.Response {
+	resp := req.ResponseKind().(*kmsg.OffsetFetchResponse)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g := b.group(req.Group)
+
+	reqTopics := req.Topics
+	if reqTopics == nil {
+		for _, name := range slices.Sorted(maps.Keys(g.offsets)) {
+			reqTopic := kmsg.OffsetFetchRequestTopic{Topic: name}
+			reqTopic.Partitions = slices.Sorted(maps.Keys(g.offsets[name]))
+			reqTopics = append(reqTopics, reqTopic)
+		}
+	}
+	for _, reqTopic := range reqTopics {
+		topic := kmsg.NewOffsetFetchResponseTopic()
+		topic.Topic = reqTopic.Topic
+		for _, p := range reqTopic.Partitions {
+			partition := kmsg.NewOffsetFetchResponseTopicPartition()
+			partition.Partition = p
+			partition.Offset = -1
+			if offset, ok := g.offsets[reqTopic.Topic][p]; ok {
+				partition.Offset = offset
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		resp.Topics = append(resp.Topics, topic)
+	}
+	return resp
+}
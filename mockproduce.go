@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// producedRecord is a record sent to the mock broker by a producer, as the provider message it is
+// verified as, with the partition and offset it was appended at
+type producedRecord struct {
+	partition topicPartition
+	offset    int64
+	message   *providerMessage
+}
+
+// pactRecord is a record of the messages of the pact, with its partition
+type pactRecord struct {
+	partition topicPartition
+	record    *brokerRecord
+}
+
+// recordDecompressor decompresses the record batches of produce requests
+var recordDecompressor = kgo.DefaultDecompressor()
+
+// initProducerID gives idempotent producers an ID. Transactional producers are not supported.
+func (b *mockBroker) initProducerID(req *kmsg.InitProducerIDRequest) kmsg.Response {
+	resp := req.ResponseKind().(*kmsg.InitProducerIDResponse)
+	if req.TransactionalID != nil {
+		resp.ErrorCode = kerr.TransactionalIDAuthorizationFailed.Code
+		return resp
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.producerID++
+	resp.ProducerID = b.producerID
+	return resp
+}
+
+// produce appends the records of a produce request to their partitions, and records them to be
+// matched against the pact's messages. Records are only accepted in producer mode, as those of a
+// consumer test would never be verified. A request with no acks has no response.
+func (b *mockBroker) produce(req *kmsg.ProduceRequest) kmsg.Response {
+	resp := req.ResponseKind().(*kmsg.ProduceResponse)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, reqTopic := range req.Topics {
+		topic := kmsg.NewProduceResponseTopic()
+		topic.Topic = reqTopic.Topic
+		for _, reqPartition := range reqTopic.Partitions {
+			partition := kmsg.NewProduceResponseTopicPartition()
+			partition.Partition = reqPartition.Partition
+			tp := topicPartition{reqTopic.Topic, reqPartition.Partition}
+			records, err := readRecordBatches(reqPartition.Records)
+			existing, ok := b.partition(reqTopic.Topic, reqPartition.Partition)
+			switch {
+			case err != nil:
+				partition.ErrorCode = kerr.CorruptMessage.Code
+				partition.ErrorMessage = kmsg.StringPtr(err.Error())
+			case !ok:
+				partition.ErrorCode = kerr.UnknownTopicOrPartition.Code
+				b.unexpectedRequest(tp.topic, "Produce "+tp.String(), "Unexpected produce to partition %s, which has no messages in the pact", tp)
+			case b.config.mode != PRODUCER_MODE:
+				partition.ErrorCode = kerr.PolicyViolation.Code
+				b.unexpectedRequest(tp.topic, "Produce "+tp.String(), "Unexpected produce to partition %s, the mock server is not in %s mode", tp, PRODUCER_MODE)
+			default:
+				partition.BaseOffset = int64(len(existing))
+				partition.LogStartOffset = 0
+				for i, record := range records {
+					b.produced = append(b.produced, &producedRecord{
+						partition: tp,
+						offset:    partition.BaseOffset + int64(i),
+						message:   record.providerMessage(tp),
+					})
+				}
+				b.topics[tp.topic][tp.partition] = append(existing, records...)
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		resp.Topics = append(resp.Topics, topic)
+	}
+	if req.Acks == 0 {
+		return nil
+	}
+	return resp
+}
+
+// readRecordBatches reads the records of the record batches of a produce request, which are of the
+// version 2 message format and may be compressed. Control batches of transactions are skipped.
+func readRecordBatches(data []byte) ([]*brokerRecord, error) {
+	var records []*brokerRecord
+	for len(data) > 0 {
+		if len(data) < 12 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		size := 12 + int(int32(binary.BigEndian.Uint32(data[8:])))
+		if size < 61 || size > len(data) {
+			return nil, fmt.Errorf("invalid record batch length %d", size-12)
+		}
+		var batch kmsg.RecordBatch
+		if err := batch.ReadFrom(data[:size]); err != nil {
+			return nil, fmt.Errorf("invalid record batch: %w", err)
+		}
+		if batch.Magic != 2 {
+			return nil, fmt.Errorf("unsupported message format version %d", batch.Magic)
+		}
+		if crc32.Checksum(data[21:size], castagnoli) != uint32(batch.CRC) {
+			return nil, fmt.Errorf("record batch CRC mismatch")
+		}
+		data = data[size:]
+		if batch.Attributes&0x20 != 0 {
+			continue
+		}
+
+		raw, err := recordDecompressor.Decompress(batch.Records, kgo.CompressionCodecType(batch.Attributes&0x07))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress record batch: %w", err)
+		}
+		for range batch.NumRecords {
+			length, n := binary.Varint(raw)
+			if n <= 0 || length < 0 || n+int(length) > len(raw) {
+				return nil, fmt.Errorf("invalid record length")
+			}
+			var record kmsg.Record
+			if err := record.ReadFrom(raw[:n+int(length)]); err != nil {
+				return nil, fmt.Errorf("invalid record: %w", err)
+			}
+			raw = raw[n+int(length):]
+			timestamp := batch.FirstTimestamp + record.TimestampDelta64
+			// the timestamp of every record of a batch with the log append time type is its max timestamp
+			if batch.Attributes&0x08 != 0 {
+				timestamp = batch.MaxTimestamp
+			}
+			records = append(records, &brokerRecord{key: record.Key, value: record.Value, headers: record.Headers, timestamp: timestamp})
+		}
+	}
+	return records, nil
+}
+
+// providerMessage converts a produced record to the provider message it is verified as, with binary
+// headers and the topic, partition and timestamp of the record as its metadata
+func (r *brokerRecord) providerMessage(tp topicPartition) *providerMessage {
+	metadata := map[string]any{
+		TOPIC_METADATA_KEY:     tp.topic,
+		PARTITION_METADATA_KEY: int64(tp.partition),
+		TIMESTAMP_METADATA_KEY: r.timestamp,
+	}
+	for _, header := range r.headers {
+		metadata[header.Key] = header.Value
+	}
+	return &providerMessage{value: r.value, key: r.key, metadata: metadata}
+}
+
+// producedResults matches the produced records against the messages of the pact on their topics.
+// Each message can be matched by one record, which is verified like VerifyInteraction does, key
+// included, allowing fields the provider adds unless the mock server configuration does not. A
+// record matching none of the messages is reported with the mismatches of the message it is closest
+// to, and messages no record matched as not produced.
+func (b *mockBroker) producedResults(ctx context.Context, s *pactPluginServer, produced []*producedRecord, expected []pactRecord) (bool, []*pb.MockServerResult) {
+	ok := true
+	var results []*pb.MockServerResult
+	matched := make(map[*brokerRecord]bool)
+	for _, record := range produced {
+		path := "Produce " + record.partition.String()
+		var closest *brokerRecord
+		var closestMismatches []*pb.VerificationResultItem
+		for _, message := range expected {
+			candidate := message.record
+			if matched[candidate] || message.partition.topic != record.partition.topic {
+				continue
+			}
+			verified, err := s.verifyMessage(ctx, b.pact, candidate.interaction, record.message, b.config.allowUnexpectedKeys)
+			if err != nil {
+				verified = &pb.VerificationResult{Mismatches: []*pb.VerificationResultItem{{Result: &pb.VerificationResultItem_Error{Error: err.Error()}}}}
+			}
+			if verified.Success {
+				closest, closestMismatches = candidate, nil
+				break
+			}
+			if closest == nil || len(verified.Mismatches) < len(closestMismatches) {
+				closest, closestMismatches = candidate, verified.Mismatches
+			}
+		}
+
+		switch {
+		case closest == nil:
+			ok = false
+			results = append(results, &pb.MockServerResult{
+				Path:  path,
+				Error: fmt.Sprintf("Unexpected record at offset %d, the expected messages of topic %s were already produced", record.offset, record.partition.topic),
+			})
+		case closestMismatches == nil:
+			matched[closest] = true
+			results = append(results, &pb.MockServerResult{Path: path})
+		default:
+			ok = false
+			result := &pb.MockServerResult{
+				Path:  path,
+				Error: fmt.Sprintf("Produced record at offset %d does not match any expected message, the closest is '%s'", record.offset, closest.interaction.Description),
+			}
+			for _, item := range closestMismatches {
+				switch r := item.Result.(type) {
+				case *pb.VerificationResultItem_Mismatch:
+					result.Mismatches = append(result.Mismatches, r.Mismatch)
+				case *pb.VerificationResultItem_Error:
+					result.Error += ": " + r.Error
+				}
+			}
+			results = append(results, result)
+		}
+	}
+
+	for _, message := range expected {
+		if !matched[message.record] {
+			ok = false
+			results = append(results, &pb.MockServerResult{
+				Path:  "Produce " + message.partition.String(),
+				Error: fmt.Sprintf("Expected message '%s' was not produced", message.record.interaction.Description),
+			})
+		}
+	}
+	return ok, results
+}
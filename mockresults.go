@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+)
+
+// topicPartition is a partition of a topic, shown as topic/partition in the mock server results
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+func (tp topicPartition) String() string {
+	return fmt.Sprintf("%s/%d", tp.topic, tp.partition)
+}
+
+// unexpectedRequest records a request for a topic, or a partition of it, that the pact has no
+// messages on, or a request the broker does not handle. Only the first request for each topic is
+// recorded, usually the Metadata request of a consumer subscribing to it. The broker must be locked.
+func (b *mockBroker) unexpectedRequest(topic, path, format string, args ...any) {
+	if _, ok := b.unexpected[topic]; !ok {
+		b.unexpected[topic] = &pb.MockServerResult{Path: path, Error: fmt.Sprintf(format, args...)}
+	}
+}
+
+// results reports what consumers and producers did with the broker, as the results of the mock
+// server. Each request and resource, such as "Fetch users/0", is a result: the partitions fetched,
+// the offsets committed by each group, the records produced, the messages of the pact that were
+// never fetched and the requests for topics without messages, the last two with an error. In
+// producer mode, the messages of the pact are expected to be produced rather than fetched, and
+// produced records not matching them are errors too. The results are ok if none has an error.
+func (b *mockBroker) results(ctx context.Context, s *pactPluginServer) (bool, []*pb.MockServerResult) {
+	b.mu.Lock()
+	var results []*pb.MockServerResult
+	var expected []pactRecord
+	ok := true
+	producing := b.config.mode == PRODUCER_MODE
+
+	for _, topic := range slices.Sorted(maps.Keys(b.topics)) {
+		for p, records := range b.topics[topic] {
+			tp := topicPartition{topic, int32(p)}
+			path := "Fetch " + tp.String()
+			if _, fetched := b.fetched[tp]; fetched {
+				results = append(results, &pb.MockServerResult{Path: path})
+			}
+			for offset, record := range records {
+				if record.interaction == nil {
+					continue
+				}
+				if producing {
+					expected = append(expected, pactRecord{tp, record})
+				} else if !record.consumed {
+					ok = false
+					results = append(results, &pb.MockServerResult{
+						Path:  path,
+						Error: fmt.Sprintf("Expected message '%s' at offset %d was not consumed", record.interaction.Description, offset),
+					})
+				}
+			}
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(b.groups)) {
+		offsets := b.groups[name].offsets
+		for _, topic := range slices.Sorted(maps.Keys(offsets)) {
+			for _, partition := range slices.Sorted(maps.Keys(offsets[topic])) {
+				tp := topicPartition{topic, partition}
+				results = append(results, &pb.MockServerResult{
+					Path: fmt.Sprintf("OffsetCommit %s %s offset %d", name, tp, offsets[topic][partition]),
+				})
+			}
+		}
+	}
+
+	var unexpected []*pb.MockServerResult
+	for _, topic := range slices.Sorted(maps.Keys(b.unexpected)) {
+		unexpected = append(unexpected, b.unexpected[topic])
+	}
+	produced := slices.Clone(b.produced)
+	b.mu.Unlock()
+
+	if producing {
+		producedOk, producedResults := b.producedResults(ctx, s, produced, expected)
+		ok = ok && producedOk
+		results = append(results, producedResults...)
+	}
+	if len(unexpected) > 0 {
+		ok = false
+		results = append(results, unexpected...)
+	}
+	return ok, results
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// PACT_PLUGIN_NAME is the name of the plugin in pact-plugin.json, which keys the plugin configuration
+// in pact files
+const PACT_PLUGIN_NAME = "kafka"
+
+// ASYNCHRONOUS_MESSAGE_TYPE is the type of the message interactions of V4 pact files
+const ASYNCHRONOUS_MESSAGE_TYPE = "Asynchronous/Messages"
+
+// pactPluginNames are the names the plugin configuration of a pact file may be keyed by
+var pactPluginNames = []string{PACT_PLUGIN_NAME, PLUGIN_NAME}
+
+// pactFile is the part of a V4 pact file the plugin reads to verify interactions and serve them from
+// a mock server
+type pactFile struct {
+	Interactions []*pactInteraction `json:"interactions"`
+	Metadata     struct {
+		Plugins []struct {
+			Name          string         `json:"name"`
+			Configuration map[string]any `json:"configuration"`
+		} `json:"plugins"`
+	} `json:"metadata"`
+}
+
+// pactInteraction is a message interaction of a V4 pact file
+type pactInteraction struct {
+	Type                string                               `json:"type"`
+	Key                 string                               `json:"key"`
+	Description         string                               `json:"description"`
+	ProviderStates      []map[string]any                     `json:"providerStates"`
+	Contents            pactContents                         `json:"contents"`
+	Metadata            map[string]any                       `json:"metadata"`
+	MatchingRules       map[string]map[string]map[string]any `json:"matchingRules"`
+	PluginConfiguration map[string]map[string]any            `json:"pluginConfiguration"`
+}
+
+// pactContents is the body of an interaction. Contents of the plugin's content types are binary, and
+// stored base64 encoded.
+type pactContents struct {
+	Content     any    `json:"content"`
+	ContentType string `json:"contentType"`
+	Encoded     any    `json:"encoded"`
+}
+
+// parsePact parses a pact file, as passed to the plugin for verification and mock servers
+func parsePact(text string) (*pactFile, error) {
+	pact := &pactFile{}
+	if err := json.Unmarshal([]byte(text), pact); err != nil {
+		return nil, fmt.Errorf("failed to parse the pact: %w", err)
+	}
+	return pact, nil
+}
+
+// interaction finds an interaction by its key, or else its description
+func (p *pactFile) interaction(key string) (*pactInteraction, error) {
+	for _, interaction := range p.Interactions {
+		if interaction.Key != "" && interaction.Key == key {
+			return interaction, nil
+		}
+	}
+	for _, interaction := range p.Interactions {
+		if interaction.Description == key {
+			return interaction, nil
+		}
+	}
+	return nil, fmt.Errorf("interaction %q not found in the pact", key)
+}
+
+// messages returns the asynchronous message interactions of the pact with the plugin's content types
+func (p *pactFile) messages() []*pactInteraction {
+	contentTypes := strings.Split(CONTENT_TYPES, ";")
+	var messages []*pactInteraction
+	for _, interaction := range p.Interactions {
+		if interaction.Type == ASYNCHRONOUS_MESSAGE_TYPE && slices.Contains(contentTypes, interaction.Contents.ContentType) {
+			messages = append(messages, interaction)
+		}
+	}
+	return messages
+}
+
+// pluginConfiguration returns the plugin configuration of an interaction, as passed to CompareContents:
+// its interaction configuration and the configuration the plugin persisted for the pact
+func (p *pactFile) pluginConfiguration(interaction *pactInteraction) (*pb.PluginConfiguration, error) {
+	pactFields := make(map[string]any)
+	for _, plugin := range p.Metadata.Plugins {
+		if slices.Contains(pactPluginNames, plugin.Name) {
+			for key, value := range plugin.Configuration {
+				pactFields[key] = value
+			}
+		}
+	}
+	pactConfiguration, err := structpb.NewStruct(pactFields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid plugin configuration of the pact: %w", err)
+	}
+
+	var interactionFields map[string]any
+	for _, name := range pactPluginNames {
+		if fields, ok := interaction.PluginConfiguration[name]; ok {
+			interactionFields = fields
+			break
+		}
+	}
+	interactionConfiguration, err := structpb.NewStruct(interactionFields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid plugin configuration of interaction %q: %w", interaction.Description, err)
+	}
+	return &pb.PluginConfiguration{InteractionConfiguration: interactionConfiguration, PactConfiguration: pactConfiguration}, nil
+}
+
+// body returns the contents of the interaction, decoding base64 encoded contents
+func (i *pactInteraction) body() (*pb.Body, error) {
+	var content []byte
+	switch c := i.Contents.Content.(type) {
+	case nil:
+	case string:
+		content = []byte(c)
+		if encoded, _ := i.Contents.Encoded.(string); encoded == "base64" {
+			data, err := base64.StdEncoding.DecodeString(c)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode the contents of interaction %q: %w", i.Description, err)
+			}
+			content = data
+		}
+	default:
+		data, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		content = data
+	}
+	return &pb.Body{ContentType: i.Contents.ContentType, Content: wrapperspb.Bytes(content)}, nil
+}
+
+// rules returns the matching rules of a category of the interaction, such as body or metadata, from
+// their representation in the pact, {"combine": "AND", "matchers": [{"match": "type"}]}
+func (i *pactInteraction) rules(category string) (ruleSet, error) {
+	rules := make(ruleSet)
+	for path, rule := range i.MatchingRules[category] {
+		matchers, err := structpb.NewValue(rule["matchers"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid matching rules of %s: %w", path, err)
+		}
+		rules.add(path, rulesFromValues(matchers.GetListValue().GetValues())...)
+	}
+	return rules, nil
+}
+
+// expectedMetadata returns the record metadata of the interaction, with binary headers decoded. The
+// contentType metadata Pact adds to messages is the content type of the body, not a record header.
+func (i *pactInteraction) expectedMetadata(config *pb.PluginConfiguration) (map[string]any, error) {
+	metadata := maps.Clone(i.Metadata)
+	delete(metadata, "contentType")
+	return decodeMetadata(metadata, binaryHeaders(config.GetInteractionConfiguration()))
+}
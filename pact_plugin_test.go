@@ -1,16 +1,38 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"maps"
+	"math"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/uuid"
+	"github.com/hamba/avro/v2"
 	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/test/bufconn"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 const bufSize = 1024 * 1024
@@ -52,6 +74,39 @@ func getClient(t *testing.T) (pb.PactPluginClient, *grpc.ClientConn) {
 	return client, conn
 }
 
+// configureAvroInteraction configures an interaction of the Avro content type from its contentsConfig
+func configureAvroInteraction(t *testing.T, client pb.PactPluginClient, fields map[string]any) (*pb.ConfigureInteractionResponse, error) {
+	t.Helper()
+	contentsConfig, err := structpb.NewStruct(fields)
+	if err != nil {
+		t.Fatalf("failed to build contents config: %v", err)
+	}
+	return client.ConfigureInteraction(context.Background(), &pb.ConfigureInteractionRequest{
+		ContentType:    AVRO_SCHEMA_CONTENT_TYPE,
+		ContentsConfig: contentsConfig,
+	})
+}
+
+// mustConfigureAvroInteraction configures an interaction like configureAvroInteraction, failing the
+// test if it can not be configured
+func mustConfigureAvroInteraction(t *testing.T, client pb.PactPluginClient, fields map[string]any) *pb.ConfigureInteractionResponse {
+	t.Helper()
+	resp, err := configureAvroInteraction(t, client, fields)
+	if err != nil {
+		t.Fatalf("ConfigureInteraction() unexpected error: %v", err)
+	}
+	return resp
+}
+
+// pluginConfiguration combines the interaction and pact configuration of a configured interaction,
+// as they are passed to CompareContents and GenerateContent
+func pluginConfiguration(resp *pb.ConfigureInteractionResponse, i int) *pb.PluginConfiguration {
+	return &pb.PluginConfiguration{
+		InteractionConfiguration: resp.Interaction[i].GetPluginConfiguration().GetInteractionConfiguration(),
+		PactConfiguration:        resp.GetPluginConfiguration().GetPactConfiguration(),
+	}
+}
+
 // TestInitPlugin tests the InitPlugin RPC method
 func TestInitPlugin(t *testing.T) {
 	client, conn := getClient(t)
@@ -107,9 +162,20 @@ func TestInitPlugin(t *testing.T) {
 				{
 					Key: PLUGIN_NAME,
 					Values: map[string]string{
-						"content-types": AVRO_SCHEMA_CONTENT_TYPE,
+						"content-types": CONTENT_TYPES,
+					},
+				},
+				{
+					Type: pb.CatalogueEntry_CONTENT_GENERATOR,
+					Key:  PLUGIN_NAME,
+					Values: map[string]string{
+						"content-types": CONTENT_TYPES,
 					},
 				},
+				{
+					Type: pb.CatalogueEntry_TRANSPORT,
+					Key:  PLUGIN_NAME,
+				},
 			}
 			if diff := cmp.Diff(expected, resp.Catalogue, protocmp.Transform()); diff != "" {
 				t.Errorf("InitPlugin() catalogue mismatch (-want +got):\n%s", diff)
@@ -187,3 +253,2339 @@ func TestUpdateCatalogue(t *testing.T) {
 		})
 	}
 }
+
+// TestConfigureInteraction tests the ConfigureInteraction RPC method
+func TestConfigureInteraction(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	tests := []struct {
+		name    string
+		config  map[string]any
+		want    []byte
+		wantErr bool
+	}{
+		{
+			name:   "small schema ID",
+			config: map[string]any{"schemaId": 16, "message": base64.StdEncoding.EncodeToString([]byte("hello"))},
+			want:   []byte{0x00, 0x00, 0x00, 0x00, 0x10, 'h', 'e', 'l', 'l', 'o'},
+		},
+		{
+			name:   "maximum schema ID",
+			config: map[string]any{"schemaId": math.MaxUint32, "message": ""},
+			want:   []byte{0x00, 0xFF, 0xFF, 0xFF, 0xFF},
+		},
+		{
+			name:    "missing schema ID",
+			config:  map[string]any{"message": ""},
+			wantErr: true,
+		},
+		{
+			name:    "negative schema ID",
+			config:  map[string]any{"schemaId": -1, "message": ""},
+			wantErr: true,
+		},
+		{
+			name:    "schema ID out of range",
+			config:  map[string]any{"schemaId": math.MaxUint32 + 1, "message": ""},
+			wantErr: true,
+		},
+		{
+			name:    "fractional schema ID",
+			config:  map[string]any{"schemaId": 1.5, "message": ""},
+			wantErr: true,
+		},
+		{
+			name:    "message does not match schema",
+			config:  map[string]any{"schemaId": 1, "message": base64.StdEncoding.EncodeToString([]byte{0x02}), "schema": testUserSchema},
+			wantErr: true,
+		},
+		{
+			name:    "message not base64",
+			config:  map[string]any{"schemaId": 1, "message": "not base64!"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contentsConfig, err := structpb.NewStruct(tt.config)
+			if err != nil {
+				t.Fatalf("failed to build contents config: %v", err)
+			}
+			resp, err := client.ConfigureInteraction(context.Background(), &pb.ConfigureInteractionRequest{
+				ContentType:    AVRO_SCHEMA_CONTENT_TYPE,
+				ContentsConfig: contentsConfig,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConfigureInteraction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(resp.Interaction) != 1 {
+				t.Fatalf("expected 1 interaction, got %d", len(resp.Interaction))
+			}
+			if diff := cmp.Diff(tt.want, resp.Interaction[0].Contents.Content.GetValue()); diff != "" {
+				t.Errorf("ConfigureInteraction() contents mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+const testUserSchema = `{
+  "namespace": "kafkaplugin",
+  "type": "record",
+  "name": "User",
+  "fields": [
+    { "name": "id", "type": "string" },
+    { "name": "email", "type": ["null", "string"] },
+    { "name": "tags", "type": { "type": "array", "items": "string" } },
+    { "name": "attributes", "type": { "type": "map", "values": "long" } }
+  ]
+}`
+
+func encodeTestUser(t *testing.T, schemaID uint32, user map[string]any) []byte {
+	t.Helper()
+	data, err := testAvroAPI.Marshal(avro.MustParse(testUserSchema), user)
+	if err != nil {
+		t.Fatalf("failed to marshal avro message: %v", err)
+	}
+	return EncodeWireFormat(schemaID, data)
+}
+
+// TestCompareContents tests the CompareContents RPC method
+func TestCompareContents(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	expectedUser := map[string]any{
+		"id":         "94af717d-1b04-4fad-9879-01dc828e410d",
+		"email":      "jane.doe@example.com",
+		"tags":       []any{"a", "b"},
+		"attributes": map[string]any{"age": int64(30)},
+	}
+	pluginConfiguration := &pb.PluginConfiguration{
+		InteractionConfiguration: &structpb.Struct{
+			Fields: map[string]*structpb.Value{"schema": structpb.NewStringValue(testUserSchema)},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		actual    []byte
+		wantPaths []string
+		wantError bool
+	}{
+		{
+			name:   "identical messages",
+			actual: encodeTestUser(t, 16, expectedUser),
+		},
+		{
+			name: "different field values",
+			actual: encodeTestUser(t, 16, map[string]any{
+				"id":         "94af717d-1b04-4fad-9879-01dc828e410d",
+				"email":      "john.doe@example.com",
+				"tags":       []any{"a", "c", "d"},
+				"attributes": map[string]any{"height": int64(180)},
+			}),
+			wantPaths: []string{"$.attributes.age", "$.attributes.height", "$.email", "$.tags", "$.tags[1]"},
+		},
+		{
+			name: "different union branch",
+			actual: encodeTestUser(t, 16, map[string]any{
+				"id":         "94af717d-1b04-4fad-9879-01dc828e410d",
+				"email":      nil,
+				"tags":       []any{"a", "b"},
+				"attributes": map[string]any{"age": int64(30)},
+			}),
+			wantPaths: []string{"$.email"},
+		},
+		{
+			name:      "actual not framed",
+			actual:    []byte("00010"),
+			wantPaths: []string{"$"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.CompareContents(context.Background(), &pb.CompareContentsRequest{
+				Expected: &pb.Body{
+					ContentType: AVRO_SCHEMA_CONTENT_TYPE,
+					Content:     wrapperspb.Bytes(encodeTestUser(t, 16, expectedUser)),
+				},
+				Actual: &pb.Body{
+					ContentType: AVRO_SCHEMA_CONTENT_TYPE,
+					Content:     wrapperspb.Bytes(tt.actual),
+				},
+				PluginConfiguration: pluginConfiguration,
+			})
+			if err != nil {
+				t.Fatalf("CompareContents() unexpected error: %v", err)
+			}
+			if resp.Error != "" {
+				t.Fatalf("CompareContents() returned error: %s", resp.Error)
+			}
+
+			gotPaths := make([]string, 0, len(resp.Results))
+			for path, mismatches := range resp.Results {
+				gotPaths = append(gotPaths, path)
+				for _, mismatch := range mismatches.Mismatches {
+					if mismatch.Path != path || mismatch.Mismatch == "" {
+						t.Errorf("CompareContents() malformed mismatch at %s: %v", path, mismatch)
+					}
+				}
+			}
+			sort.Strings(gotPaths)
+			if diff := cmp.Diff(tt.wantPaths, gotPaths, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("CompareContents() mismatch paths (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestCompareContentsMismatchDetails tests the expected and actual values reported for a field
+func TestCompareContentsMismatchDetails(t *testing.T) {
+	schema := avro.MustParse(testUserSchema)
+	expected := map[string]any{"id": "1", "email": map[string]any{"string": "jane@example.com"}, "tags": []any{}, "attributes": map[string]any{}}
+	actual := map[string]any{"id": "1", "email": map[string]any{"string": "john@example.com"}, "tags": []any{}, "attributes": map[string]any{}}
+
+	results := CompareAvro(schema, expected, actual, nil, false)
+	if len(results) != 1 || len(results["$.email"]) != 1 {
+		t.Fatalf("CompareAvro() expected a single mismatch at $.email, got %v", results)
+	}
+	mismatch := results["$.email"][0]
+	if got := string(mismatch.Expected.GetValue()); got != `"jane@example.com"` {
+		t.Errorf("expected value = %s", got)
+	}
+	if got := string(mismatch.Actual.GetValue()); got != `"john@example.com"` {
+		t.Errorf("actual value = %s", got)
+	}
+	if want := "-\"jane@example.com\"\n+\"john@example.com\""; mismatch.Diff != want {
+		t.Errorf("diff = %q, want %q", mismatch.Diff, want)
+	}
+}
+
+// TestConfigureInteractionJSONMessage tests encoding a JSON message with the declared writer schema
+func TestConfigureInteractionJSONMessage(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	message := map[string]any{
+		"id":         "94af717d-1b04-4fad-9879-01dc828e410d",
+		"email":      "jane.doe@example.com",
+		"tags":       []any{"a"},
+		"attributes": map[string]any{"age": 30},
+	}
+	want := encodeTestUser(t, 16, map[string]any{
+		"id":         "94af717d-1b04-4fad-9879-01dc828e410d",
+		"email":      "jane.doe@example.com",
+		"tags":       []any{"a"},
+		"attributes": map[string]any{"age": int64(30)},
+	})
+
+	schemaFile := filepath.Join(t.TempDir(), "schema.avsc")
+	if err := os.WriteFile(schemaFile, []byte(testUserSchema), 0644); err != nil {
+		t.Fatalf("failed to write schema file: %v", err)
+	}
+	var schemaObject map[string]any
+	if err := json.Unmarshal([]byte(testUserSchema), &schemaObject); err != nil {
+		t.Fatalf("failed to parse schema: %v", err)
+	}
+
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/subjects/users-value/versions/latest" {
+			http.NotFound(w, r)
+			return
+		}
+		// nolint:errcheck
+		json.NewEncoder(w).Encode(RegistrySchema{Subject: "users-value", ID: 16, Version: 1, Schema: testUserSchema})
+	}))
+	defer registry.Close()
+
+	tests := []struct {
+		name    string
+		config  map[string]any
+		wantErr bool
+	}{
+		{
+			name:   "inline schema string",
+			config: map[string]any{"schemaId": 16, "schema": testUserSchema, "message": message},
+		},
+		{
+			name:   "inline schema object",
+			config: map[string]any{"schemaId": 16, "schema": schemaObject, "message": message},
+		},
+		{
+			name:   "schema file",
+			config: map[string]any{"schemaId": 16, "schemaFile": schemaFile, "message": message},
+		},
+		{
+			name:   "schema registry reference",
+			config: map[string]any{"schemaRegistry": map[string]any{"url": registry.URL, "subject": "users-value"}, "message": message},
+		},
+		{
+			name:    "unknown registry subject",
+			config:  map[string]any{"schemaRegistry": map[string]any{"url": registry.URL, "subject": "orders-value"}, "message": message},
+			wantErr: true,
+		},
+		{
+			name:    "missing schema",
+			config:  map[string]any{"schemaId": 16, "message": message},
+			wantErr: true,
+		},
+		{
+			name:    "multiple schema sources",
+			config:  map[string]any{"schemaId": 16, "schema": testUserSchema, "schemaFile": schemaFile, "message": message},
+			wantErr: true,
+		},
+		{
+			name:    "message missing a required field",
+			config:  map[string]any{"schemaId": 16, "schema": testUserSchema, "message": map[string]any{"id": "1"}},
+			wantErr: true,
+		},
+		{
+			name:    "relative schema file",
+			config:  map[string]any{"schemaId": 16, "schemaFile": "schema.avsc", "message": message},
+			wantErr: true,
+		},
+		{
+			name:    "relative reader schema file",
+			config:  map[string]any{"schemaId": 16, "schema": testUserSchema, "readerSchemaFile": "reader.avsc", "message": message},
+			wantErr: true,
+		},
+		{
+			name:    "relative schema directory",
+			config:  map[string]any{"schemaId": 16, "schema": testUserSchema, "schemaDirectory": "schemas", "message": message},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contentsConfig, err := structpb.NewStruct(tt.config)
+			if err != nil {
+				t.Fatalf("failed to build contents config: %v", err)
+			}
+			resp, err := client.ConfigureInteraction(context.Background(), &pb.ConfigureInteractionRequest{
+				ContentType:    AVRO_SCHEMA_CONTENT_TYPE,
+				ContentsConfig: contentsConfig,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConfigureInteraction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if diff := cmp.Diff(want, resp.Interaction[0].Contents.Content.GetValue()); diff != "" {
+				t.Errorf("ConfigureInteraction() contents mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestGenerateContent tests the GenerateContent RPC method
+func TestGenerateContent(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	schema := `{
+	  "type": "record",
+	  "name": "Event",
+	  "fields": [
+	    { "name": "id", "type": "string" },
+	    { "name": "userId", "type": "long" },
+	    { "name": "count", "type": "int" },
+	    { "name": "createdAt", "type": "string" },
+	    { "name": "occurredAt", "type": { "type": "long", "logicalType": "timestamp-millis" } },
+	    { "name": "name", "type": ["null", "string"] },
+	    { "name": "path", "type": "string" }
+	  ]
+	}`
+	contentsConfig, err := structpb.NewStruct(map[string]any{
+		"schemaId": 42,
+		"schema":   schema,
+		"message": map[string]any{
+			"id":         "94af717d-1b04-4fad-9879-01dc828e410d",
+			"userId":     "fromProviderState('${userId}', 1)",
+			"count":      1,
+			"createdAt":  "2024-01-01T00:00:00",
+			"occurredAt": 0,
+			"name":       nil,
+			"path":       "fromProviderState('/users/${userId}', '/users/1')",
+		},
+		"generators": map[string]any{
+			"$.id":         map[string]any{"type": "RandomUUID"},
+			"$.count":      map[string]any{"type": "RandomInt", "min": 10, "max": 20},
+			"$.createdAt":  map[string]any{"type": "DateTime", "format": "yyyy-MM-dd'T'HH:mm:ss"},
+			"$.occurredAt": map[string]any{"type": "DateTime"},
+			"$.name":       map[string]any{"type": "RandomString", "size": 8},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to build contents config: %v", err)
+	}
+	configured, err := client.ConfigureInteraction(context.Background(), &pb.ConfigureInteractionRequest{
+		ContentType:    AVRO_SCHEMA_CONTENT_TYPE,
+		ContentsConfig: contentsConfig,
+	})
+	if err != nil {
+		t.Fatalf("ConfigureInteraction() unexpected error: %v", err)
+	}
+	interaction := configured.Interaction[0]
+	wantGenerators := []string{"$.count", "$.createdAt", "$.id", "$.name", "$.occurredAt", "$.path", "$.userId"}
+	if diff := cmp.Diff(wantGenerators, sortedKeys(interaction.Generators)); diff != "" {
+		t.Fatalf("ConfigureInteraction() generators mismatch (-want +got):\n%s", diff)
+	}
+	if got := interaction.Generators["$.id"].Type; got != "Uuid" {
+		t.Errorf("expected RandomUUID to be stored as Uuid, got %s", got)
+	}
+
+	testContext, err := structpb.NewStruct(map[string]any{"userId": 1234})
+	if err != nil {
+		t.Fatalf("failed to build test context: %v", err)
+	}
+	before := time.Now().Add(-time.Second)
+	resp, err := client.GenerateContent(context.Background(), &pb.GenerateContentRequest{
+		Contents:            interaction.Contents,
+		Generators:          interaction.Generators,
+		PluginConfiguration: pluginConfiguration(configured, 0),
+		TestContext:         testContext,
+		TestMode:            pb.GenerateContentRequest_Consumer,
+	})
+	if err != nil {
+		t.Fatalf("GenerateContent() unexpected error: %v", err)
+	}
+
+	schemaID, payload, err := DecodeWireFormat(resp.Contents.Content.GetValue())
+	if err != nil {
+		t.Fatalf("generated contents are not framed: %v", err)
+	}
+	if schemaID != 42 {
+		t.Errorf("GenerateContent() schema ID = %d, want 42", schemaID)
+	}
+	var event struct {
+		ID         string    `avro:"id"`
+		UserID     int64     `avro:"userId"`
+		Count      int32     `avro:"count"`
+		CreatedAt  string    `avro:"createdAt"`
+		OccurredAt time.Time `avro:"occurredAt"`
+		Name       *string   `avro:"name"`
+		Path       string    `avro:"path"`
+	}
+	if err := avro.Unmarshal(avro.MustParse(schema), payload, &event); err != nil {
+		t.Fatalf("failed to unmarshal generated contents: %v", err)
+	}
+
+	if _, err := uuid.Parse(event.ID); err != nil || event.ID == "94af717d-1b04-4fad-9879-01dc828e410d" {
+		t.Errorf("expected a new UUID, got %q", event.ID)
+	}
+	if event.UserID != 1234 {
+		t.Errorf("expected userId from the provider state, got %d", event.UserID)
+	}
+	if event.Count < 10 || event.Count > 20 {
+		t.Errorf("expected count between 10 and 20, got %d", event.Count)
+	}
+	if _, err := time.Parse("2006-01-02T15:04:05", event.CreatedAt); err != nil || event.CreatedAt == "2024-01-01T00:00:00" {
+		t.Errorf("expected a generated date time, got %q", event.CreatedAt)
+	}
+	if event.OccurredAt.Before(before) {
+		t.Errorf("expected a current timestamp, got %v", event.OccurredAt)
+	}
+	if event.Name == nil || len(*event.Name) != 8 {
+		t.Errorf("expected a random string of 8 characters, got %v", event.Name)
+	}
+	if event.Path != "/users/1234" {
+		t.Errorf("expected path from the provider state, got %q", event.Path)
+	}
+}
+
+const testOrderProto = `syntax = "proto3";
+package shop;
+
+import "google/protobuf/timestamp.proto";
+
+message Order {
+  string id = 1;
+  int64 customer_id = 2;
+  repeated Line lines = 3;
+  Status status = 4;
+  google.protobuf.Timestamp placed_at = 5;
+  optional string note = 6;
+
+  message Line {
+    string sku = 1;
+    uint32 quantity = 2;
+  }
+}
+
+enum Status {
+  PENDING = 0;
+  SHIPPED = 1;
+}
+`
+
+// TestProtobufContents tests the ConfigureInteraction, CompareContents and GenerateContent RPC
+// methods with the Protobuf content type
+func TestProtobufContents(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	contentsConfig, err := structpb.NewStruct(map[string]any{
+		"schemaId": 7,
+		"schema":   testOrderProto,
+		"message": map[string]any{
+			"id":         "matching(type,'order-1')",
+			"customerId": 42,
+			"lines": []any{
+				map[string]any{"sku": "matching(regex,'[A-Z]+-\\d+','ABC-1')", "quantity": 2},
+			},
+			"status":    "SHIPPED",
+			"placed_at": "2024-01-01T00:00:00Z",
+		},
+		"generators": map[string]any{
+			"$.customer_id": map[string]any{"type": "RandomInt", "min": 100, "max": 200},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to build contents config: %v", err)
+	}
+	configured, err := client.ConfigureInteraction(context.Background(), &pb.ConfigureInteractionRequest{
+		ContentType:    PROTOBUF_SCHEMA_CONTENT_TYPE,
+		ContentsConfig: contentsConfig,
+	})
+	if err != nil {
+		t.Fatalf("ConfigureInteraction() unexpected error: %v", err)
+	}
+	interaction := configured.Interaction[0]
+	if diff := cmp.Diff([]string{"$.id", "$.lines[0].sku"}, sortedKeys(interaction.Rules)); diff != "" {
+		t.Errorf("ConfigureInteraction() rules mismatch (-want +got):\n%s", diff)
+	}
+	if got := interaction.PluginConfiguration.InteractionConfiguration.Fields["messageType"].GetStringValue(); got != "shop.Order" {
+		t.Errorf("ConfigureInteraction() messageType = %q, want shop.Order", got)
+	}
+
+	// the first message type is written with the single 0 message index
+	content := interaction.Contents.Content.GetValue()
+	want := EncodeWireFormat(7, []byte{0})
+	if !bytes.HasPrefix(content, want) {
+		t.Fatalf("ConfigureInteraction() content = %X, want prefix %X", content, want)
+	}
+	format, err := loadInteractionFormat(PROTOBUF_SCHEMA_CONTENT_TYPE, pluginConfiguration(configured, 0), interaction.Contents.Content.GetValue())
+	if err != nil {
+		t.Fatalf("failed to load the protobuf format: %v", err)
+	}
+	frame := func(order map[string]any) []byte {
+		payload, err := format.Encode(order)
+		if err != nil {
+			t.Fatalf("failed to encode order: %v", err)
+		}
+		return format.Frame(7, payload)
+	}
+
+	tests := []struct {
+		name   string
+		actual map[string]any
+		want   []string
+	}{
+		{
+			name: "matches rules",
+			actual: map[string]any{
+				"id": "order-2", "customer_id": 42, "status": "SHIPPED", "placed_at": "2024-01-01T00:00:00Z",
+				"lines": []any{map[string]any{"sku": "XYZ-99", "quantity": 2}},
+			},
+		},
+		{
+			name: "mismatches",
+			actual: map[string]any{
+				"id": "order-2", "customer_id": 43, "status": "PENDING", "placed_at": "2024-01-01T00:00:00Z",
+				"lines": []any{map[string]any{"sku": "xyz", "quantity": 2}}, "note": "leave at the door",
+			},
+			want: []string{"$.customer_id", "$.lines[0].sku", "$.note", "$.status"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.CompareContents(context.Background(), &pb.CompareContentsRequest{
+				Expected:            interaction.Contents,
+				Actual:              &pb.Body{ContentType: PROTOBUF_SCHEMA_CONTENT_TYPE, Content: wrapperspb.Bytes(frame(tt.actual))},
+				Rules:               interaction.Rules,
+				PluginConfiguration: pluginConfiguration(configured, 0),
+			})
+			if err != nil {
+				t.Fatalf("CompareContents() unexpected error: %v", err)
+			}
+			if resp.Error != "" {
+				t.Fatalf("CompareContents() error = %s", resp.Error)
+			}
+			if diff := cmp.Diff(tt.want, sortedKeys(resp.Results), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("CompareContents() mismatch paths (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	resp, err := client.GenerateContent(context.Background(), &pb.GenerateContentRequest{
+		Contents:            interaction.Contents,
+		Generators:          interaction.Generators,
+		PluginConfiguration: pluginConfiguration(configured, 0),
+		TestMode:            pb.GenerateContentRequest_Consumer,
+	})
+	if err != nil {
+		t.Fatalf("GenerateContent() unexpected error: %v", err)
+	}
+	_, payload, err := format.Unframe(resp.Contents.Content.GetValue())
+	if err != nil {
+		t.Fatalf("generated contents are not framed: %v", err)
+	}
+	generated, err := format.Decode(payload)
+	if err != nil {
+		t.Fatalf("failed to decode generated contents: %v", err)
+	}
+	if id := generated.(map[string]any)["customer_id"].(int64); id < 100 || id > 200 {
+		t.Errorf("expected customer_id between 100 and 200, got %d", id)
+	}
+}
+
+// TestJSONSchemaContents tests the ConfigureInteraction and CompareContents RPC methods with the
+// JSON Schema content type
+func TestJSONSchemaContents(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	schema := map[string]any{
+		"type":     "object",
+		"required": []any{"id", "items"},
+		"properties": map[string]any{
+			"id": map[string]any{"type": "string"},
+			"items": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type":       "object",
+					"properties": map[string]any{"qty": map[string]any{"type": "integer", "minimum": 1}},
+				},
+			},
+		},
+	}
+	configure := func(message any) (*pb.ConfigureInteractionResponse, error) {
+		contentsConfig, err := structpb.NewStruct(map[string]any{"schemaId": 3, "schema": schema, "message": message})
+		if err != nil {
+			t.Fatalf("failed to build contents config: %v", err)
+		}
+		return client.ConfigureInteraction(context.Background(), &pb.ConfigureInteractionRequest{
+			ContentType:    JSON_SCHEMA_CONTENT_TYPE,
+			ContentsConfig: contentsConfig,
+		})
+	}
+
+	if _, err := configure(map[string]any{"id": "order-1", "items": []any{map[string]any{"qty": 0}}}); err == nil {
+		t.Error("ConfigureInteraction() expected error for a message not matching the schema")
+	}
+
+	configured, err := configure(map[string]any{
+		"id":    "matching(type,'order-1')",
+		"items": []any{map[string]any{"qty": 2}},
+	})
+	if err != nil {
+		t.Fatalf("ConfigureInteraction() unexpected error: %v", err)
+	}
+	interaction := configured.Interaction[0]
+	if markup := interaction.InteractionMarkup; !strings.Contains(markup, "## JSON schema") || !strings.Contains(markup, "- `$.id`: type") {
+		t.Errorf("ConfigureInteraction() interaction markup missing the schema or rules:\n%s", markup)
+	}
+	want := EncodeWireFormat(3, []byte(`{"id":"order-1","items":[{"qty":2}]}`))
+	if diff := cmp.Diff(want, interaction.Contents.Content.GetValue()); diff != "" {
+		t.Errorf("ConfigureInteraction() content mismatch (-want +got):\n%s", diff)
+	}
+
+	tests := []struct {
+		name   string
+		actual string
+		want   []string
+	}{
+		{name: "matches", actual: `{"id":"order-2","items":[{"qty":2}]}`},
+		{name: "mismatched value", actual: `{"id":"order-2","items":[{"qty":3}]}`, want: []string{"$.items[0].qty"}},
+		{name: "schema violation", actual: `{"id":"order-2","items":[{"qty":2},{"qty":-1}]}`, want: []string{"$.items", "$.items[1].qty"}},
+		{name: "missing required field", actual: `{"items":[{"qty":2}]}`, want: []string{"$", "$.id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.CompareContents(context.Background(), &pb.CompareContentsRequest{
+				Expected:            interaction.Contents,
+				Actual:              &pb.Body{ContentType: JSON_SCHEMA_CONTENT_TYPE, Content: wrapperspb.Bytes(EncodeWireFormat(3, []byte(tt.actual)))},
+				Rules:               interaction.Rules,
+				PluginConfiguration: pluginConfiguration(configured, 0),
+			})
+			if err != nil {
+				t.Fatalf("CompareContents() unexpected error: %v", err)
+			}
+			if resp.Error != "" {
+				t.Fatalf("CompareContents() error = %s", resp.Error)
+			}
+			if diff := cmp.Diff(tt.want, sortedKeys(resp.Results), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("CompareContents() mismatch paths (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestConfigureInteractionSchemaID tests resolving the schemaId with the configured schema registry
+func TestConfigureInteractionSchemaID(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	registry, _ := newTestRegistry(t)
+	useRegistryConfig(t, RegistryConfig{URL: registry.URL})
+
+	message := map[string]any{
+		"id":         "94af717d-1b04-4fad-9879-01dc828e410d",
+		"email":      "jane.doe@example.com",
+		"tags":       []any{"a"},
+		"attributes": map[string]any{"age": 30},
+	}
+	contentsConfig, err := structpb.NewStruct(map[string]any{"schemaId": 16, "message": message})
+	if err != nil {
+		t.Fatalf("failed to build contents config: %v", err)
+	}
+	resp, err := client.ConfigureInteraction(context.Background(), &pb.ConfigureInteractionRequest{
+		ContentType:    AVRO_SCHEMA_CONTENT_TYPE,
+		ContentsConfig: contentsConfig,
+	})
+	if err != nil {
+		t.Fatalf("ConfigureInteraction() unexpected error: %v", err)
+	}
+	want := encodeTestUser(t, 16, map[string]any{
+		"id":         "94af717d-1b04-4fad-9879-01dc828e410d",
+		"email":      "jane.doe@example.com",
+		"tags":       []any{"a"},
+		"attributes": map[string]any{"age": int64(30)},
+	})
+	if diff := cmp.Diff(want, resp.Interaction[0].Contents.Content.GetValue()); diff != "" {
+		t.Errorf("ConfigureInteraction() content mismatch (-want +got):\n%s", diff)
+	}
+
+	// a registered Avro schema can not be used for another content type
+	_, err = client.ConfigureInteraction(context.Background(), &pb.ConfigureInteractionRequest{
+		ContentType:    JSON_SCHEMA_CONTENT_TYPE,
+		ContentsConfig: contentsConfig,
+	})
+	if err == nil {
+		t.Error("ConfigureInteraction() expected error for a schema type mismatch")
+	}
+}
+
+// TestSchemaDirectory tests resolving the schemaId from a snapshot directory, both in ConfigureInteraction
+// and from the pact configuration in CompareContents
+func TestSchemaDirectory(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "16.avsc"), []byte(testUserSchema), 0644); err != nil {
+		t.Fatalf("failed to write schema file: %v", err)
+	}
+	user := map[string]any{
+		"id":         "94af717d-1b04-4fad-9879-01dc828e410d",
+		"email":      nil,
+		"tags":       []any{},
+		"attributes": map[string]any{},
+	}
+	resp := mustConfigureAvroInteraction(t, client, map[string]any{"schemaId": 16, "schemaDirectory": dir, "message": user})
+	want := encodeTestUser(t, 16, user)
+	if diff := cmp.Diff(want, resp.Interaction[0].Contents.Content.GetValue()); diff != "" {
+		t.Errorf("ConfigureInteraction() content mismatch (-want +got):\n%s", diff)
+	}
+	pactConfiguration := resp.GetPluginConfiguration().GetPactConfiguration()
+	if got := pactConfiguration.GetFields()["schemaDirectory"].GetStringValue(); got != dir {
+		t.Errorf("ConfigureInteraction() pact configuration schemaDirectory = %q, want %q", got, dir)
+	}
+
+	// an interaction without a persisted schema uses the snapshot directory of the pact
+	actual := encodeTestUser(t, 16, map[string]any{
+		"id":         "94af717d-1b04-4fad-9879-01dc828e410d",
+		"email":      map[string]any{"string": "jane.doe@example.com"},
+		"tags":       []any{},
+		"attributes": map[string]any{},
+	})
+	compared, err := client.CompareContents(context.Background(), &pb.CompareContentsRequest{
+		Expected:            &pb.Body{ContentType: AVRO_SCHEMA_CONTENT_TYPE, Content: wrapperspb.Bytes(want)},
+		Actual:              &pb.Body{ContentType: AVRO_SCHEMA_CONTENT_TYPE, Content: wrapperspb.Bytes(actual)},
+		PluginConfiguration: &pb.PluginConfiguration{PactConfiguration: pactConfiguration},
+	})
+	if err != nil {
+		t.Fatalf("CompareContents() unexpected error: %v", err)
+	}
+	if compared.Error != "" {
+		t.Fatalf("CompareContents() error = %s", compared.Error)
+	}
+	if diff := cmp.Diff([]string{"$.email"}, sortedKeys(compared.Results)); diff != "" {
+		t.Errorf("CompareContents() mismatch paths (-want +got):\n%s", diff)
+	}
+}
+
+// TestConfigureInteractionPluginConfiguration tests that the writer schema is persisted once in the pact
+// configuration, and referred to from the interaction configuration
+func TestConfigureInteractionPluginConfiguration(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	registry, _ := newTestRegistry(t)
+	message := map[string]any{"id": "1", "email": nil, "tags": []any{}, "attributes": map[string]any{}}
+	fromRegistry := mustConfigureAvroInteraction(t, client, map[string]any{
+		"schemaRegistry": map[string]any{"url": registry.URL, "subject": "users-value", "version": 1},
+		"message":        message,
+	})
+	inline := mustConfigureAvroInteraction(t, client, map[string]any{"schemaId": 16, "schema": testUserSchema, "message": message})
+	var schemaObject map[string]any
+	if err := json.Unmarshal([]byte(testUserSchema), &schemaObject); err != nil {
+		t.Fatalf("failed to parse schema: %v", err)
+	}
+	// the schema object is marshalled without the whitespace of testUserSchema, and with its keys sorted
+	reformatted := mustConfigureAvroInteraction(t, client, map[string]any{"schemaId": 16, "schema": schemaObject, "message": message})
+
+	interactionConfig := fromRegistry.Interaction[0].PluginConfiguration.InteractionConfiguration.AsMap()
+	fingerprint, _ := interactionConfig["schemaFingerprint"].(string)
+	want := map[string]any{
+		"schemaId":          float64(16),
+		"schemaFingerprint": fingerprint,
+		"subject":           "users-value",
+		"version":           float64(1),
+	}
+	if diff := cmp.Diff(want, interactionConfig); diff != "" {
+		t.Errorf("ConfigureInteraction() interaction configuration mismatch (-want +got):\n%s", diff)
+	}
+	if !strings.HasPrefix(fingerprint, "sha256:") {
+		t.Errorf("ConfigureInteraction() schema fingerprint = %q", fingerprint)
+	}
+
+	// the same schema has the same fingerprint, however it was declared and formatted
+	for name, resp := range map[string]*pb.ConfigureInteractionResponse{"inline": inline, "reformatted": reformatted} {
+		if got := resp.Interaction[0].PluginConfiguration.InteractionConfiguration.Fields["schemaFingerprint"].GetStringValue(); got != fingerprint {
+			t.Errorf("ConfigureInteraction() %s schema fingerprint = %q, want %q", name, got, fingerprint)
+		}
+	}
+	pactConfig := fromRegistry.PluginConfiguration.PactConfiguration.AsMap()
+	if diff := cmp.Diff([]string{fingerprint}, sortedKeys(pactConfig)); diff != "" {
+		t.Fatalf("ConfigureInteraction() pact configuration mismatch (-want +got):\n%s", diff)
+	}
+	format, err := loadInteractionFormat(AVRO_SCHEMA_CONTENT_TYPE, pluginConfiguration(fromRegistry, 0), nil)
+	if err != nil {
+		t.Fatalf("failed to load the persisted schema: %v", err)
+	}
+	if _, ok := format.(*avroFormat); !ok {
+		t.Errorf("loadInteractionFormat() = %T, want an Avro format", format)
+	}
+
+	_, err = loadInteractionFormat(AVRO_SCHEMA_CONTENT_TYPE, &pb.PluginConfiguration{
+		InteractionConfiguration: fromRegistry.Interaction[0].PluginConfiguration.InteractionConfiguration,
+	}, nil)
+	if err == nil {
+		t.Error("loadInteractionFormat() expected error without the pact configuration")
+	}
+}
+
+// TestConfigureInteractionKey tests configuring the record key as a separate part, and comparing it
+// independently of the message
+func TestConfigureInteractionKey(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	configure := func(key map[string]any) (*pb.ConfigureInteractionResponse, error) {
+		return configureAvroInteraction(t, client, map[string]any{
+			"schemaId": 16,
+			"schema":   testUserSchema,
+			"topic":    "users",
+			"message":  map[string]any{"id": "1", "email": nil, "tags": []any{}, "attributes": map[string]any{}},
+			"key":      key,
+		})
+	}
+
+	resp, err := configure(map[string]any{"schemaId": 5, "schema": `"string"`, "message": "matching(regex, 'user-\\d+', 'user-1')"})
+	if err != nil {
+		t.Fatalf("ConfigureInteraction() unexpected error: %v", err)
+	}
+	if len(resp.Interaction) != 2 || resp.Interaction[0].PartName != "message" || resp.Interaction[1].PartName != "key" {
+		t.Fatalf("ConfigureInteraction() expected a message and a key part, got %v", resp.Interaction)
+	}
+	key := resp.Interaction[1]
+	if diff := cmp.Diff(EncodeWireFormat(5, []byte{0x0C, 'u', 's', 'e', 'r', '-', '1'}), key.Contents.Content.GetValue()); diff != "" {
+		t.Errorf("ConfigureInteraction() key content mismatch (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(key.InteractionMarkup, "**Topic:** `users`") {
+		t.Errorf("ConfigureInteraction() key markup does not inherit the topic:\n%s", key.InteractionMarkup)
+	}
+
+	tests := []struct {
+		name   string
+		actual string
+		want   []string
+	}{
+		{name: "matching key", actual: "user-42"},
+		{name: "mismatched key", actual: "account-42", want: []string{"$"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := EncodeWireFormat(5, append([]byte{byte(len(tt.actual) * 2)}, tt.actual...))
+			compared, err := client.CompareContents(context.Background(), &pb.CompareContentsRequest{
+				Expected:            key.Contents,
+				Actual:              &pb.Body{ContentType: AVRO_SCHEMA_CONTENT_TYPE, Content: wrapperspb.Bytes(actual)},
+				Rules:               key.Rules,
+				PluginConfiguration: pluginConfiguration(resp, 1),
+			})
+			if err != nil {
+				t.Fatalf("CompareContents() unexpected error: %v", err)
+			}
+			if compared.Error != "" {
+				t.Fatalf("CompareContents() error = %s", compared.Error)
+			}
+			if diff := cmp.Diff(tt.want, sortedKeys(compared.Results), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("CompareContents() mismatch paths (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	// a string is a message of the schema, even if it is valid base64, unless it is marked as encoded
+	literals := []struct {
+		key  map[string]any
+		want []byte
+	}{
+		{key: map[string]any{"schemaId": 5, "schema": `"string"`, "message": "AA=="}, want: []byte{0x08, 'A', 'A', '=', '='}},
+		{key: map[string]any{"schemaId": 5, "schema": `"string"`, "message": "AA==", "encoded": true}, want: []byte{0x00}},
+	}
+	for _, literal := range literals {
+		resp, err = configure(literal.key)
+		if err != nil {
+			t.Fatalf("ConfigureInteraction() unexpected error for key %v: %v", literal.key, err)
+		}
+		if diff := cmp.Diff(EncodeWireFormat(5, literal.want), resp.Interaction[1].Contents.Content.GetValue()); diff != "" {
+			t.Errorf("ConfigureInteraction() key %v content mismatch (-want +got):\n%s", literal.key, diff)
+		}
+	}
+	if _, err := configure(map[string]any{"schemaId": 5, "schema": `"string"`, "message": "not base64!", "encoded": true}); err == nil {
+		t.Error("ConfigureInteraction() expected error for an encoded key that is not base64")
+	}
+
+	resp, err = configure(map[string]any{"serializer": "string", "message": "user-1"})
+	if err != nil {
+		t.Fatalf("ConfigureInteraction() unexpected error for a string key: %v", err)
+	}
+	if got := resp.Interaction[1].Contents; got.ContentType != STRING_KEY_CONTENT_TYPE || string(got.Content.GetValue()) != "user-1" {
+		t.Errorf("ConfigureInteraction() string key = %s %q", got.ContentType, got.Content.GetValue())
+	}
+
+	if _, err := configure(map[string]any{"serializer": "xml", "message": "user-1"}); err == nil {
+		t.Error("ConfigureInteraction() expected error for an unknown key serializer")
+	}
+}
+
+// TestConfigureInteractionMetadata tests the message metadata of the headers, topic, partition and
+// timestamp of the contentsConfig
+func TestConfigureInteractionMetadata(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	configure := func(fields map[string]any) (*pb.ConfigureInteractionResponse, error) {
+		config := map[string]any{
+			"schemaId": 16,
+			"schema":   testUserSchema,
+			"message":  map[string]any{"id": "1", "email": nil, "tags": []any{}, "attributes": map[string]any{}},
+		}
+		maps.Copy(config, fields)
+		return configureAvroInteraction(t, client, config)
+	}
+
+	resp, err := configure(map[string]any{
+		"topic":     "users",
+		"partition": 3,
+		"timestamp": "matching(regex, '\\d{4}-\\d{2}-\\d{2}T.+', '2024-01-02T03:04:05Z')",
+		"headers": map[string]any{
+			"event-type":  "matching(regex, 'user\\..+', 'user.created')",
+			"ce_id":       "fromProviderState('${eventId}', 'abc')",
+			"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+			"checksum":    map[string]any{"binary": base64.StdEncoding.EncodeToString([]byte{0xCA, 0xFE})},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ConfigureInteraction() unexpected error: %v", err)
+	}
+	interaction := resp.Interaction[0]
+
+	wantMetadata := map[string]any{
+		TOPIC_METADATA_KEY:     "users",
+		PARTITION_METADATA_KEY: float64(3),
+		TIMESTAMP_METADATA_KEY: "2024-01-02T03:04:05Z",
+		"event-type":           "user.created",
+		"ce_id":                "abc",
+		"traceparent":          "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"checksum":             base64.StdEncoding.EncodeToString([]byte{0xCA, 0xFE}),
+	}
+	if diff := cmp.Diff(wantMetadata, interaction.MessageMetadata.AsMap()); diff != "" {
+		t.Errorf("ConfigureInteraction() message metadata mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"event-type", TIMESTAMP_METADATA_KEY}, sortedKeys(interaction.MetadataRules)); diff != "" {
+		t.Errorf("ConfigureInteraction() metadata rules mismatch (-want +got):\n%s", diff)
+	}
+	if got := interaction.MetadataGenerators["ce_id"].GetType(); got != "ProviderState" {
+		t.Errorf("ConfigureInteraction() ce_id generator = %q, want ProviderState", got)
+	}
+	binary := binaryHeaders(interaction.PluginConfiguration.GetInteractionConfiguration())
+	if diff := cmp.Diff([]string{"checksum"}, binary); diff != "" {
+		t.Errorf("ConfigureInteraction() binary headers mismatch (-want +got):\n%s", diff)
+	}
+
+	// the configured metadata is verified against the metadata of the actual record
+	expected, err := decodeMetadata(interaction.MessageMetadata.AsMap(), binary)
+	if err != nil {
+		t.Fatalf("decodeMetadata() unexpected error: %v", err)
+	}
+	actual := map[string]*pb.MetadataValue{
+		TOPIC_METADATA_KEY:     {Value: &pb.MetadataValue_NonBinaryValue{NonBinaryValue: structpb.NewStringValue("users")}},
+		PARTITION_METADATA_KEY: {Value: &pb.MetadataValue_NonBinaryValue{NonBinaryValue: structpb.NewNumberValue(3)}},
+		TIMESTAMP_METADATA_KEY: {Value: &pb.MetadataValue_NonBinaryValue{NonBinaryValue: structpb.NewStringValue("2025-06-07T08:09:10Z")}},
+		"event-type":           {Value: &pb.MetadataValue_BinaryValue{BinaryValue: []byte("user.deleted")}},
+		"ce_id":                {Value: &pb.MetadataValue_BinaryValue{BinaryValue: []byte("abc")}},
+		"traceparent":          {Value: &pb.MetadataValue_BinaryValue{BinaryValue: []byte("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")}},
+		"checksum":             {Value: &pb.MetadataValue_BinaryValue{BinaryValue: []byte{0xBE, 0xEF}}},
+	}
+	results := CompareMetadata(expected, metadataValues(actual), interaction.MetadataRules)
+	if diff := cmp.Diff([]string{"checksum"}, sortedKeys(results)); diff != "" {
+		t.Errorf("CompareMetadata() mismatch keys (-want +got):\n%s", diff)
+	}
+	if got := results["checksum"][0].MismatchType; got != "metadata" {
+		t.Errorf("CompareMetadata() mismatch type = %q, want metadata", got)
+	}
+
+	invalid := []map[string]any{
+		{"headers": "event-type"},
+		{"headers": map[string]any{"event-type": 1}},
+		{"headers": map[string]any{"checksum": map[string]any{"binary": "not base64!"}}},
+		{"partition": -1},
+		{"timestamp": 1.5},
+	}
+	for _, fields := range invalid {
+		if _, err := configure(fields); err == nil {
+			t.Errorf("ConfigureInteraction() expected error for %v", fields)
+		}
+	}
+}
+
+// TestConfigureInteractionTombstone tests configuring and comparing a tombstone, a record with a null value
+func TestConfigureInteractionTombstone(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	config := map[string]any{
+		"tombstone": true,
+		"topic":     "users",
+		"headers":   map[string]any{"event-type": "user.deleted"},
+		"key":       map[string]any{"serializer": "string", "message": "user-1"},
+	}
+	resp := mustConfigureAvroInteraction(t, client, config)
+	if len(resp.Interaction) != 2 {
+		t.Fatalf("ConfigureInteraction() expected a message and a key part, got %d parts", len(resp.Interaction))
+	}
+	message := resp.Interaction[0]
+	if got := message.Contents.Content.GetValue(); len(got) != 0 {
+		t.Errorf("ConfigureInteraction() tombstone content = %v, want empty", got)
+	}
+	if got := message.MessageMetadata.AsMap()["event-type"]; got != "user.deleted" {
+		t.Errorf("ConfigureInteraction() event-type metadata = %v", got)
+	}
+	if !strings.Contains(message.InteractionMarkup, "**Tombstone:**") {
+		t.Errorf("ConfigureInteraction() markup does not describe the tombstone:\n%s", message.InteractionMarkup)
+	}
+
+	tests := []struct {
+		name   string
+		actual []byte
+		want   []string
+	}{
+		{name: "null value", actual: nil},
+		{name: "message", actual: encodeTestUser(t, 16, map[string]any{"id": "1", "email": nil, "tags": []any{}, "attributes": map[string]any{}}), want: []string{"$"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compared, err := client.CompareContents(context.Background(), &pb.CompareContentsRequest{
+				Expected:            message.Contents,
+				Actual:              &pb.Body{ContentType: AVRO_SCHEMA_CONTENT_TYPE, Content: wrapperspb.Bytes(tt.actual)},
+				PluginConfiguration: pluginConfiguration(resp, 0),
+			})
+			if err != nil {
+				t.Fatalf("CompareContents() unexpected error: %v", err)
+			}
+			if compared.Error != "" {
+				t.Fatalf("CompareContents() error = %s", compared.Error)
+			}
+			if diff := cmp.Diff(tt.want, sortedKeys(compared.Results), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("CompareContents() mismatch paths (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	config["message"] = "AA=="
+	if _, err := configureAvroInteraction(t, client, config); err == nil {
+		t.Error("ConfigureInteraction() expected error for a tombstone with a message")
+	}
+}
+
+// TestReaderSchema tests comparing the message of a provider written with a newer writer schema, as
+// read with the reader schema of the consumer
+func TestReaderSchema(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	const (
+		writerV3 = `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}, {"name": "name", "type": "string"}]}`
+		readerV3 = `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}, {"name": "name", "type": "string"}, {"name": "nickname", "type": "string", "default": ""}]}`
+		writerV4 = `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}, {"name": "name", "type": "string"}, {"name": "age", "type": "int", "default": 0}]}`
+		writerV5 = `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}, {"name": "name", "type": "long"}]}`
+	)
+	dir := t.TempDir()
+	for name, schema := range map[string]string{"4.avsc": writerV4, "5.avsc": writerV5} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(schema), 0644); err != nil {
+			t.Fatalf("failed to write schema file: %v", err)
+		}
+	}
+
+	config := map[string]any{
+		"schemaId":        3,
+		"schema":          writerV3,
+		"readerSchema":    readerV3,
+		"schemaDirectory": dir,
+		"message":         map[string]any{"id": "matching(type, '1')", "name": "Jane"},
+	}
+	resp := mustConfigureAvroInteraction(t, client, config)
+	interaction := resp.Interaction[0]
+	if got := interaction.PluginConfiguration.GetInteractionConfiguration().GetFields()["readerSchema"].GetStringValue(); got == "" {
+		t.Error("ConfigureInteraction() the reader schema is not persisted in the interaction configuration")
+	}
+
+	encode := func(schemaID uint32, schema string, value map[string]any) []byte {
+		data, err := testAvroAPI.Marshal(avro.MustParse(schema), value)
+		if err != nil {
+			t.Fatalf("failed to marshal avro message: %v", err)
+		}
+		return EncodeWireFormat(schemaID, data)
+	}
+	tests := []struct {
+		name   string
+		actual []byte
+		want   []string
+	}{
+		{name: "same writer schema", actual: encode(3, writerV3, map[string]any{"id": "2", "name": "Jane"})},
+		{name: "added field", actual: encode(4, writerV4, map[string]any{"id": "2", "name": "Jane", "age": 30})},
+		{name: "added field with different values", actual: encode(4, writerV4, map[string]any{"id": "2", "name": "John", "age": 30}), want: []string{"$.name"}},
+		{name: "changed type", actual: encode(5, writerV5, map[string]any{"id": "2", "name": int64(1)}), want: []string{"$.name"}},
+		{name: "unknown writer schema", actual: encode(6, writerV3, map[string]any{"id": "2", "name": "Jane"}), want: []string{"$"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compared, err := client.CompareContents(context.Background(), &pb.CompareContentsRequest{
+				Expected:            interaction.Contents,
+				Actual:              &pb.Body{ContentType: AVRO_SCHEMA_CONTENT_TYPE, Content: wrapperspb.Bytes(tt.actual)},
+				Rules:               interaction.Rules,
+				PluginConfiguration: pluginConfiguration(resp, 0),
+				// fields added by the provider are allowed, see TestAllowUnexpectedKeys
+				AllowUnexpectedKeys: true,
+			})
+			if err != nil {
+				t.Fatalf("CompareContents() unexpected error: %v", err)
+			}
+			if compared.Error != "" {
+				t.Fatalf("CompareContents() error = %s", compared.Error)
+			}
+			if diff := cmp.Diff(tt.want, sortedKeys(compared.Results), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("CompareContents() mismatch paths (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	config["readerSchema"] = `{"type": "record", "name": "User", "fields": [{"name": "email", "type": "string"}]}`
+	if _, err := configureAvroInteraction(t, client, config); err == nil {
+		t.Error("ConfigureInteraction() expected error for a message the reader schema can not read")
+	}
+}
+
+// TestSchemaCompatibility tests checking the current schema of the provider against the consumer's
+// schema during CompareContents
+func TestSchemaCompatibility(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	const (
+		consumerSchema = `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}, {"name": "name", "type": "string"}]}`
+		addedAge       = `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}, {"name": "name", "type": "string"}, {"name": "age", "type": "int"}]}`
+		changedName    = `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}, {"name": "name", "type": "long"}]}`
+		removedName    = `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}]}`
+		longID         = `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "long"}, {"name": "name", "type": "string"}]}`
+	)
+	// writeSnapshot writes a snapshot of the versions of users-value, the provider's current schema last
+	writeSnapshot := func(versions ...string) string {
+		dir := t.TempDir()
+		var schemas []any
+		for i, schema := range versions {
+			schemas = append(schemas, map[string]any{"id": i + 1, "subject": "users-value", "version": i + 1, "schema": schema})
+		}
+		manifest, err := json.Marshal(map[string]any{"schemas": schemas})
+		if err != nil {
+			t.Fatalf("failed to marshal registry manifest: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, REGISTRY_MANIFEST), manifest, 0644); err != nil {
+			t.Fatalf("failed to write registry manifest: %v", err)
+		}
+		return dir
+	}
+
+	tests := []struct {
+		name    string
+		mode    string
+		earlier []string
+		current string
+		want    []string
+	}{
+		{name: "forward compatible", mode: "FORWARD", current: addedAge},
+		{name: "backward incompatible", mode: "BACKWARD", current: addedAge, want: []string{"$.age"}},
+		{name: "full incompatible", mode: "FULL_TRANSITIVE", current: changedName, want: []string{"$.name"}},
+		// the consumer reads name, which the provider's schema no longer writes
+		{name: "removed field forward incompatible", mode: "FORWARD", current: removedName, want: []string{"$.name"}},
+		{name: "removed field full incompatible", mode: "FULL", current: removedName, want: []string{"$.name"}},
+		{name: "removed field backward compatible", mode: "BACKWARD", current: removedName},
+		// version 1 is incompatible with the current schema, but no consumer reads with it
+		{name: "transitive ignores earlier versions", mode: "FORWARD_TRANSITIVE", earlier: []string{longID, consumerSchema}, current: addedAge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contentsConfig, err := structpb.NewStruct(map[string]any{
+				"schemaId":        1,
+				"schema":          consumerSchema,
+				"schemaDirectory": writeSnapshot(append(tt.earlier, tt.current)...),
+				"compatibility":   map[string]any{"mode": tt.mode, "subject": "users-value"},
+				"message":         map[string]any{"id": "1", "name": "Jane"},
+			})
+			if err != nil {
+				t.Fatalf("failed to build contents config: %v", err)
+			}
+			resp, err := client.ConfigureInteraction(context.Background(), &pb.ConfigureInteractionRequest{
+				ContentType:    AVRO_SCHEMA_CONTENT_TYPE,
+				ContentsConfig: contentsConfig,
+			})
+			if err != nil {
+				t.Fatalf("ConfigureInteraction() unexpected error: %v", err)
+			}
+			interaction := resp.Interaction[0]
+			compared, err := client.CompareContents(context.Background(), &pb.CompareContentsRequest{
+				Expected:            interaction.Contents,
+				Actual:              interaction.Contents,
+				PluginConfiguration: pluginConfiguration(resp, 0),
+			})
+			if err != nil {
+				t.Fatalf("CompareContents() unexpected error: %v", err)
+			}
+			if compared.Error != "" {
+				t.Fatalf("CompareContents() error = %s", compared.Error)
+			}
+			if diff := cmp.Diff(tt.want, sortedKeys(compared.Results), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("CompareContents() mismatch paths (-want +got):\n%s", diff)
+			}
+			for _, results := range compared.Results {
+				for _, mismatch := range results.Mismatches {
+					if mismatch.MismatchType != "schema" {
+						t.Errorf("CompareContents() mismatch type = %q, want schema", mismatch.MismatchType)
+					}
+				}
+			}
+		})
+	}
+
+	contentsConfig, err := structpb.NewStruct(map[string]any{
+		"schemaId":      1,
+		"schema":        consumerSchema,
+		"compatibility": "BACKWARD",
+		"message":       map[string]any{"id": "1", "name": "Jane"},
+	})
+	if err != nil {
+		t.Fatalf("failed to build contents config: %v", err)
+	}
+	if _, err := client.ConfigureInteraction(context.Background(), &pb.ConfigureInteractionRequest{
+		ContentType:    AVRO_SCHEMA_CONTENT_TYPE,
+		ContentsConfig: contentsConfig,
+	}); err == nil {
+		t.Error("ConfigureInteraction() expected error for a compatibility check without a subject")
+	}
+}
+
+// TestLogicalTypes tests messages with Avro logical types, given and compared in their readable form
+func TestLogicalTypes(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	const paymentSchema = `{"type": "record", "name": "Payment", "fields": [
+		{"name": "id", "type": {"type": "string", "logicalType": "uuid"}},
+		{"name": "amount", "type": {"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}},
+		{"name": "createdAt", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+		{"name": "day", "type": {"type": "int", "logicalType": "date"}}
+	]}`
+	resp := mustConfigureAvroInteraction(t, client, map[string]any{
+		"schemaId": 40,
+		"schema":   paymentSchema,
+		"message": map[string]any{
+			"id":        "matching(type, '94af717d-1b04-4fad-9879-01dc828e410d')",
+			"amount":    "matching(decimal, 12.5)",
+			"createdAt": "matching(datetime, 'yyyy-MM-dd\\'T\\'HH:mm:ss.SSSX', '2024-03-01T09:20:30.123Z')",
+			"day":       "2024-03-01",
+		},
+	})
+	interaction := resp.Interaction[0]
+	for _, want := range []string{`"amount": "12.50"`, `"createdAt": "2024-03-01T09:20:30.123Z"`, `"day": "2024-03-01"`} {
+		if !strings.Contains(interaction.InteractionMarkup, want) {
+			t.Errorf("ConfigureInteraction() markup does not contain %s:\n%s", want, interaction.InteractionMarkup)
+		}
+	}
+
+	schema := avro.MustParse(paymentSchema)
+	actual, err := AvroFromJSON(schema, map[string]any{
+		"id":        "5b1e0a39-5d4c-4d43-9b5e-0c8e4f4a6b1f",
+		"amount":    map[string]any{"decimal": "99.99"},
+		"createdAt": "2025-01-02T03:04:05.678Z",
+		"day":       "2024-03-02",
+	})
+	if err != nil {
+		t.Fatalf("AvroFromJSON() unexpected error: %v", err)
+	}
+	data, err := EncodeAvro(schema, actual)
+	if err != nil {
+		t.Fatalf("EncodeAvro() unexpected error: %v", err)
+	}
+	compared, err := client.CompareContents(context.Background(), &pb.CompareContentsRequest{
+		Expected:            interaction.Contents,
+		Actual:              &pb.Body{ContentType: AVRO_SCHEMA_CONTENT_TYPE, Content: wrapperspb.Bytes(EncodeWireFormat(40, data))},
+		Rules:               interaction.Rules,
+		PluginConfiguration: pluginConfiguration(resp, 0),
+	})
+	if err != nil {
+		t.Fatalf("CompareContents() unexpected error: %v", err)
+	}
+	if compared.Error != "" {
+		t.Fatalf("CompareContents() error = %s", compared.Error)
+	}
+	if diff := cmp.Diff([]string{"$.day"}, sortedKeys(compared.Results)); diff != "" {
+		t.Fatalf("CompareContents() mismatch paths (-want +got):\n%s", diff)
+	}
+	mismatch := compared.Results["$.day"].Mismatches[0]
+	if got := string(mismatch.Expected.GetValue()) + " " + string(mismatch.Actual.GetValue()); got != `"2024-03-01" "2024-03-02"` {
+		t.Errorf("CompareContents() day mismatch = %s, want the readable dates", got)
+	}
+}
+
+// TestCollectionMatchers tests that collection matchers in the message are recorded as rules on the
+// array and map paths, and that CompareContents applies them to every element
+func TestCollectionMatchers(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	const orderSchema = `{"type": "record", "name": "Order", "fields": [
+		{"name": "items", "type": {"type": "array", "items": {"type": "record", "name": "Item", "fields": [
+			{"name": "sku", "type": "string"},
+			{"name": "quantity", "type": "int"}
+		]}}},
+		{"name": "attributes", "type": {"type": "map", "values": "string"}},
+		{"name": "labels", "type": ["null", {"type": "array", "items": "string"}], "default": null}
+	]}`
+	resp := mustConfigureAvroInteraction(t, client, map[string]any{
+		"schemaId": 41,
+		"schema":   orderSchema,
+		"message": map[string]any{
+			"items": map[string]any{
+				"pact:match": "eachLike",
+				"value":      map[string]any{"sku": "matching(regex, '^SKU-\\d+$', 'SKU-1')", "quantity": "matching(integer, 1)"},
+			},
+			"attributes": map[string]any{
+				"pact:match": "eachValue(matching(type, 'red'))",
+				"value":      map[string]any{"colour": "red"},
+			},
+			"labels": map[string]any{"pact:match": "arrayContains", "value": []any{"matching(regex, '^sale-\\d+$', 'sale-10')"}},
+		},
+	})
+	interaction := resp.Interaction[0]
+	if diff := cmp.Diff([]string{"$.attributes", "$.items", "$.items[*].quantity", "$.items[*].sku", "$.labels"}, sortedKeys(interaction.Rules)); diff != "" {
+		t.Errorf("ConfigureInteraction() rule paths mismatch (-want +got):\n%s", diff)
+	}
+
+	schema := avro.MustParse(orderSchema)
+	tests := []struct {
+		name      string
+		actual    map[string]any
+		wantPaths []string
+	}{
+		{
+			name: "matching",
+			actual: map[string]any{
+				"items":      []any{map[string]any{"sku": "SKU-2", "quantity": 3}, map[string]any{"sku": "SKU-3", "quantity": 1}},
+				"attributes": map[string]any{"size": "XL"},
+				"labels":     []any{"new", "sale-50"},
+			},
+		},
+		{
+			name: "mismatching",
+			actual: map[string]any{
+				"items":      []any{map[string]any{"sku": "SKU-2", "quantity": 3}, map[string]any{"sku": "2", "quantity": 1}},
+				"attributes": map[string]any{},
+				"labels":     nil,
+			},
+			wantPaths: []string{"$.items[1].sku", "$.labels"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := AvroFromJSON(schema, tt.actual)
+			if err != nil {
+				t.Fatalf("AvroFromJSON() unexpected error: %v", err)
+			}
+			data, err := EncodeAvro(schema, actual)
+			if err != nil {
+				t.Fatalf("EncodeAvro() unexpected error: %v", err)
+			}
+			compared, err := client.CompareContents(context.Background(), &pb.CompareContentsRequest{
+				Expected:            interaction.Contents,
+				Actual:              &pb.Body{ContentType: AVRO_SCHEMA_CONTENT_TYPE, Content: wrapperspb.Bytes(EncodeWireFormat(41, data))},
+				Rules:               interaction.Rules,
+				PluginConfiguration: pluginConfiguration(resp, 0),
+			})
+			if err != nil {
+				t.Fatalf("CompareContents() unexpected error: %v", err)
+			}
+			if compared.Error != "" {
+				t.Fatalf("CompareContents() error = %s", compared.Error)
+			}
+			if diff := cmp.Diff(tt.wantPaths, sortedKeys(compared.Results), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("CompareContents() mismatch paths (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestAllowUnexpectedKeys tests that fields added to the record by the provider are mismatches
+// unless the CompareContents request allows unexpected keys
+func TestAllowUnexpectedKeys(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	const (
+		schemaV1 = `{"type": "record", "name": "Account", "fields": [
+			{"name": "id", "type": "string"},
+			{"name": "owner", "type": {"type": "record", "name": "Owner", "fields": [{"name": "name", "type": "string"}]}}
+		]}`
+		schemaV2 = `{"type": "record", "name": "Account", "fields": [
+			{"name": "id", "type": "string"},
+			{"name": "owner", "type": {"type": "record", "name": "Owner", "fields": [
+				{"name": "name", "type": "string"},
+				{"name": "email", "type": ["null", "string"], "default": null}
+			]}},
+			{"name": "status", "type": "string", "default": "OPEN"}
+		]}`
+	)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "51.avsc"), []byte(schemaV2), 0644); err != nil {
+		t.Fatalf("failed to write schema file: %v", err)
+	}
+	resp := mustConfigureAvroInteraction(t, client, map[string]any{
+		"schemaId":        50,
+		"schema":          schemaV1,
+		"schemaDirectory": dir,
+		"message":         map[string]any{"id": "1", "owner": map[string]any{"name": "Jane"}},
+	})
+	interaction := resp.Interaction[0]
+
+	data, err := testAvroAPI.Marshal(avro.MustParse(schemaV2), map[string]any{
+		"id":     "1",
+		"owner":  map[string]any{"name": "Jane", "email": map[string]any{"string": "jane@example.com"}},
+		"status": "OPEN",
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal avro message: %v", err)
+	}
+	tests := []struct {
+		name                string
+		allowUnexpectedKeys bool
+		want                []string
+	}{
+		{name: "allowed", allowUnexpectedKeys: true},
+		{name: "not allowed", want: []string{"$.owner.email", "$.status"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compared, err := client.CompareContents(context.Background(), &pb.CompareContentsRequest{
+				Expected:            interaction.Contents,
+				Actual:              &pb.Body{ContentType: AVRO_SCHEMA_CONTENT_TYPE, Content: wrapperspb.Bytes(EncodeWireFormat(51, data))},
+				Rules:               interaction.Rules,
+				PluginConfiguration: pluginConfiguration(resp, 0),
+				AllowUnexpectedKeys: tt.allowUnexpectedKeys,
+			})
+			if err != nil {
+				t.Fatalf("CompareContents() unexpected error: %v", err)
+			}
+			if compared.Error != "" {
+				t.Fatalf("CompareContents() error = %s", compared.Error)
+			}
+			if diff := cmp.Diff(tt.want, sortedKeys(compared.Results), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("CompareContents() mismatch paths (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// testPact builds a V4 pact file with a message interaction for each configured interaction, keyed by
+// description, as the Pact framework writes them. The interaction keys are "key-" and the description.
+func testPact(t *testing.T, interactions map[string]*pb.ConfigureInteractionResponse) string {
+	t.Helper()
+	pactInteractions := make([]any, 0, len(interactions))
+	pactConfiguration := make(map[string]any)
+	for _, description := range sortedKeys(interactions) {
+		resp := interactions[description]
+		interaction := resp.Interaction[0]
+		pactRules := func(rules map[string]*pb.MatchingRules) map[string]any {
+			result := make(map[string]any, len(rules))
+			for path, rule := range rules {
+				result[path] = map[string]any{"combine": "AND", "matchers": ruleValues(rule.GetRule())}
+			}
+			return result
+		}
+		metadata := interaction.GetMessageMetadata().AsMap()
+		if metadata == nil {
+			metadata = make(map[string]any)
+		}
+		metadata["contentType"] = interaction.Contents.ContentType
+		pactInteractions = append(pactInteractions, map[string]any{
+			"type":        "Asynchronous/Messages",
+			"key":         "key-" + description,
+			"description": description,
+			"contents": map[string]any{
+				"content":     base64.StdEncoding.EncodeToString(interaction.Contents.Content.GetValue()),
+				"contentType": interaction.Contents.ContentType,
+				"encoded":     "base64",
+			},
+			"metadata":            metadata,
+			"matchingRules":       map[string]any{"body": pactRules(interaction.Rules), "metadata": pactRules(interaction.MetadataRules)},
+			"pluginConfiguration": map[string]any{PACT_PLUGIN_NAME: interaction.GetPluginConfiguration().GetInteractionConfiguration().AsMap()},
+		})
+		for key, value := range resp.GetPluginConfiguration().GetPactConfiguration().AsMap() {
+			pactConfiguration[key] = value
+		}
+	}
+	pact, err := json.Marshal(map[string]any{
+		"consumer":     map[string]any{"name": "Consumer"},
+		"provider":     map[string]any{"name": "Provider"},
+		"interactions": pactInteractions,
+		"metadata": map[string]any{
+			"pactSpecification": map[string]any{"version": "4.0"},
+			"plugins":           []any{map[string]any{"name": PACT_PLUGIN_NAME, "version": "0.1.0", "configuration": pactConfiguration}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal pact: %v", err)
+	}
+	return string(pact)
+}
+
+// TestVerifyInteraction tests verifying the message of a provider, given in the verification
+// configuration, against an interaction of the pact
+func TestVerifyInteraction(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	resp := mustConfigureAvroInteraction(t, client, map[string]any{
+		"schemaId": 60,
+		"schema":   testUserSchema,
+		"topic":    "users",
+		"headers":  map[string]any{"source": "matching(regex, 'svc-.+', 'svc-users')"},
+		"message": map[string]any{
+			"id":         "matching(type, '94af717d-1b04-4fad-9879-01dc828e410d')",
+			"email":      "jane@example.com",
+			"tags":       []any{},
+			"attributes": map[string]any{},
+		},
+	})
+	pact := testPact(t, map[string]*pb.ConfigureInteractionResponse{"a user created event": resp})
+
+	prepared, err := client.PrepareInteractionForVerification(context.Background(), &pb.VerificationPreparationRequest{
+		Pact:           pact,
+		InteractionKey: "key-a user created event",
+	})
+	if err != nil {
+		t.Fatalf("PrepareInteractionForVerification() unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(resp.Interaction[0].Contents.Content.GetValue(), prepared.GetInteractionData().GetBody().GetContent().GetValue()); diff != "" {
+		t.Errorf("PrepareInteractionForVerification() body mismatch (-want +got):\n%s", diff)
+	}
+	if got := prepared.GetInteractionData().GetMetadata()[TOPIC_METADATA_KEY].GetNonBinaryValue().GetStringValue(); got != "users" {
+		t.Errorf("PrepareInteractionForVerification() topic = %q, want users", got)
+	}
+
+	encode := func(email any) string {
+		data, err := EncodeAvro(avro.MustParse(testUserSchema), map[string]any{
+			"id":         "5b1e0a39-5d4c-4d43-9b5e-0c8e4f4a6b1f",
+			"email":      email,
+			"tags":       []any{},
+			"attributes": map[string]any{},
+		})
+		if err != nil {
+			t.Fatalf("EncodeAvro() unexpected error: %v", err)
+		}
+		return base64.StdEncoding.EncodeToString(EncodeWireFormat(60, data))
+	}
+	valueFile := filepath.Join(t.TempDir(), "user.bin")
+	value, _ := base64.StdEncoding.DecodeString(encode(map[string]any{"string": "jane@example.com"}))
+	if err := os.WriteFile(valueFile, value, 0644); err != nil {
+		t.Fatalf("failed to write value file: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		message     map[string]any
+		wantSuccess bool
+		wantPaths   []string
+		wantErr     bool
+		wantError   string
+	}{
+		{
+			name:        "matching",
+			message:     map[string]any{"value": encode(map[string]any{"string": "jane@example.com"}), "topic": "users", "headers": map[string]any{"source": "svc-accounts"}},
+			wantSuccess: true,
+		},
+		{
+			name:        "value file",
+			message:     map[string]any{"valueFile": valueFile, "topic": "users", "headers": map[string]any{"source": "svc-accounts"}},
+			wantSuccess: true,
+		},
+		{
+			name:      "mismatching",
+			message:   map[string]any{"value": encode(nil), "topic": "accounts", "headers": map[string]any{"source": "accounts"}},
+			wantPaths: []string{"$.email", "kafka_topic", "source"},
+		},
+		{
+			name:      "tombstone",
+			message:   map[string]any{"value": nil, "topic": "users", "headers": map[string]any{"source": "svc-accounts"}},
+			wantPaths: []string{"$"},
+		},
+		{name: "invalid value", message: map[string]any{"value": "not base64!"}, wantErr: true},
+		{name: "binary header without binary", message: map[string]any{"value": nil, "headers": map[string]any{"source": map[string]any{}}}, wantErr: true, wantError: "header source must be a string or {\"binary\": \"<base64>\"}, got {}"},
+		{name: "partition of the wrong type", message: map[string]any{"value": nil, "partition": true}, wantErr: true, wantError: "partition must be a number or a string, got a boolean"},
+		{name: "timestamp of the wrong type", message: map[string]any{"value": nil, "timestamp": []any{1}}, wantErr: true, wantError: "timestamp must be a number or a string, got an array"},
+		{name: "relative value file", message: map[string]any{"valueFile": "user.bin"}, wantErr: true, wantError: "valueFile field must be an absolute path"},
+		{name: "value file of the wrong type", message: map[string]any{"valueFile": 1}, wantErr: true, wantError: "valueFile field must be a string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := structpb.NewStruct(map[string]any{"messages": map[string]any{"a user created event": tt.message}})
+			if err != nil {
+				t.Fatalf("failed to build config: %v", err)
+			}
+			verified, err := client.VerifyInteraction(context.Background(), &pb.VerifyInteractionRequest{
+				InteractionData: prepared.GetInteractionData(),
+				Config:          config,
+				Pact:            pact,
+				InteractionKey:  "key-a user created event",
+			})
+			if err != nil {
+				t.Fatalf("VerifyInteraction() unexpected error: %v", err)
+			}
+			if (verified.GetError() != "") != tt.wantErr {
+				t.Fatalf("VerifyInteraction() error = %q, wantErr %v", verified.GetError(), tt.wantErr)
+			}
+			if !strings.Contains(verified.GetError(), tt.wantError) {
+				t.Errorf("VerifyInteraction() error = %q, want %q", verified.GetError(), tt.wantError)
+			}
+			if tt.wantErr {
+				return
+			}
+			result := verified.GetResult()
+			if result.Success != tt.wantSuccess {
+				t.Errorf("VerifyInteraction() success = %v, want %v: %v", result.Success, tt.wantSuccess, result.Mismatches)
+			}
+			var paths []string
+			for _, item := range result.Mismatches {
+				paths = append(paths, item.GetMismatch().GetPath())
+			}
+			if diff := cmp.Diff(tt.wantPaths, paths, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("VerifyInteraction() mismatch paths (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	verified, err := client.VerifyInteraction(context.Background(), &pb.VerifyInteractionRequest{Pact: pact, InteractionKey: "unknown"})
+	if err != nil {
+		t.Fatalf("VerifyInteraction() unexpected error: %v", err)
+	}
+	if verified.GetError() == "" {
+		t.Error("VerifyInteraction() expected an error for an unknown interaction")
+	}
+}
+
+// TestVerifyInteractionKey tests verifying the record key of a provider's message against the key
+// persisted with the interaction, for Avro and string keys
+func TestVerifyInteractionKey(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	configure := func(key map[string]any) *pb.ConfigureInteractionResponse {
+		return mustConfigureAvroInteraction(t, client, map[string]any{
+			"schemaId": 16,
+			"schema":   testUserSchema,
+			"message":  map[string]any{"id": "1", "email": nil, "tags": []any{}, "attributes": map[string]any{}},
+			"key":      key,
+		})
+	}
+	avroKey := configure(map[string]any{"schemaId": 5, "schema": `"string"`, "message": "matching(regex, 'user-\\d+', 'user-1')"})
+	stringKey := configure(map[string]any{"serializer": "string", "message": "user-1"})
+	pact := testPact(t, map[string]*pb.ConfigureInteractionResponse{"an avro key": avroKey, "a string key": stringKey})
+	value := base64.StdEncoding.EncodeToString(avroKey.Interaction[0].Contents.Content.GetValue())
+	encodeAvroKey := func(key string) string {
+		return base64.StdEncoding.EncodeToString(EncodeWireFormat(5, append([]byte{byte(len(key) * 2)}, key...)))
+	}
+
+	tests := []struct {
+		name        string
+		interaction string
+		key         any
+		wantSuccess bool
+		wantPaths   []string
+	}{
+		{name: "matching avro key", interaction: "an avro key", key: encodeAvroKey("user-42"), wantSuccess: true},
+		{name: "mismatching avro key", interaction: "an avro key", key: encodeAvroKey("account-42"), wantPaths: []string{"$"}},
+		{name: "missing avro key", interaction: "an avro key", key: nil, wantPaths: []string{"$"}},
+		{name: "matching string key", interaction: "a string key", key: base64.StdEncoding.EncodeToString([]byte("user-1")), wantSuccess: true},
+		{name: "mismatching string key", interaction: "a string key", key: base64.StdEncoding.EncodeToString([]byte("user-2")), wantPaths: []string{"$"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := structpb.NewStruct(map[string]any{"messages": map[string]any{tt.interaction: map[string]any{"value": value, "key": tt.key}}})
+			if err != nil {
+				t.Fatalf("failed to build config: %v", err)
+			}
+			verified, err := client.VerifyInteraction(context.Background(), &pb.VerifyInteractionRequest{
+				Config:         config,
+				Pact:           pact,
+				InteractionKey: "key-" + tt.interaction,
+			})
+			if err != nil {
+				t.Fatalf("VerifyInteraction() unexpected error: %v", err)
+			}
+			result := verified.GetResult()
+			if result.GetSuccess() != tt.wantSuccess {
+				t.Errorf("VerifyInteraction() success = %v, want %v: %v %s", result.GetSuccess(), tt.wantSuccess, result.GetMismatches(), verified.GetError())
+			}
+			var paths []string
+			for _, item := range result.GetMismatches() {
+				if item.GetMismatch().GetMismatchType() != "key" {
+					t.Errorf("VerifyInteraction() mismatch type = %q, want key", item.GetMismatch().GetMismatchType())
+				}
+				paths = append(paths, item.GetMismatch().GetPath())
+			}
+			if diff := cmp.Diff(tt.wantPaths, paths, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("VerifyInteraction() mismatch paths (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestVerifyInteractionProducer tests verifying the messages returned by the message producer endpoint
+// of a provider, in the JSON and binary envelopes
+func TestVerifyInteractionProducer(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	resp := mustConfigureAvroInteraction(t, client, map[string]any{
+		"schemaId": 61,
+		"schema":   testUserSchema,
+		"topic":    "users",
+		"headers":  map[string]any{"event-type": "user.created"},
+		"message": map[string]any{
+			"id":         "matching(type, '94af717d-1b04-4fad-9879-01dc828e410d')",
+			"email":      "jane@example.com",
+			"tags":       []any{},
+			"attributes": map[string]any{},
+		},
+	})
+	var pactFields map[string]any
+	if err := json.Unmarshal([]byte(testPact(t, map[string]*pb.ConfigureInteractionResponse{"a user created event": resp})), &pactFields); err != nil {
+		t.Fatalf("failed to parse pact: %v", err)
+	}
+	states := []any{map[string]any{"name": "a user exists", "params": map[string]any{"id": "1"}}}
+	pactFields["interactions"].([]any)[0].(map[string]any)["providerStates"] = states
+	pact, err := json.Marshal(pactFields)
+	if err != nil {
+		t.Fatalf("failed to marshal pact: %v", err)
+	}
+
+	data, err := EncodeAvro(avro.MustParse(testUserSchema), map[string]any{
+		"id":         "5b1e0a39-5d4c-4d43-9b5e-0c8e4f4a6b1f",
+		"email":      map[string]any{"string": "jane@example.com"},
+		"tags":       []any{},
+		"attributes": map[string]any{},
+	})
+	if err != nil {
+		t.Fatalf("EncodeAvro() unexpected error: %v", err)
+	}
+	value := EncodeWireFormat(61, data)
+	metadata := map[string]any{"key": base64.StdEncoding.EncodeToString([]byte("user-1")), "topic": "users", "headers": map[string]any{"event-type": "user.created"}}
+
+	tests := []struct {
+		name        string
+		respond     func(w http.ResponseWriter)
+		timeout     float64
+		wantSuccess bool
+		wantPaths   []string
+		wantErr     bool
+	}{
+		{
+			name: "json envelope",
+			respond: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				envelope := map[string]any{"value": base64.StdEncoding.EncodeToString(value)}
+				maps.Copy(envelope, metadata)
+				_ = json.NewEncoder(w).Encode(envelope)
+			},
+			wantSuccess: true,
+		},
+		{
+			name: "binary envelope",
+			respond: func(w http.ResponseWriter) {
+				encoded, _ := json.Marshal(metadata)
+				w.Header().Set("Content-Type", AVRO_SCHEMA_CONTENT_TYPE)
+				w.Header().Set(PRODUCER_METADATA_HEADER, base64.StdEncoding.EncodeToString(encoded))
+				_, _ = w.Write(value)
+			},
+			wantSuccess: true,
+		},
+		{
+			name: "binary envelope without metadata",
+			respond: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				_, _ = w.Write(value)
+			},
+			wantPaths: []string{"event-type", "kafka_topic"},
+		},
+		{
+			name: "producer error",
+			respond: func(w http.ResponseWriter) {
+				http.Error(w, "unknown description", http.StatusNotFound)
+			},
+			wantErr: true,
+		},
+		{
+			name: "slow producer",
+			respond: func(w http.ResponseWriter) {
+				time.Sleep(500 * time.Millisecond)
+			},
+			timeout: 50,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received producerRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer token" {
+					http.Error(w, "unexpected request", http.StatusBadRequest)
+					return
+				}
+				if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				tt.respond(w)
+			}))
+			defer server.Close()
+
+			fields := map[string]any{
+				"producerUrl":     server.URL,
+				"producerHeaders": map[string]any{"Authorization": "Bearer token"},
+			}
+			if tt.timeout > 0 {
+				fields["producerTimeout"] = tt.timeout
+			}
+			config, err := structpb.NewStruct(fields)
+			if err != nil {
+				t.Fatalf("failed to build config: %v", err)
+			}
+			verified, err := client.VerifyInteraction(context.Background(), &pb.VerifyInteractionRequest{
+				Config:         config,
+				Pact:           string(pact),
+				InteractionKey: "key-a user created event",
+			})
+			if err != nil {
+				t.Fatalf("VerifyInteraction() unexpected error: %v", err)
+			}
+			wantRequest := producerRequest{Description: "a user created event", ProviderStates: []map[string]any{{"name": "a user exists", "params": map[string]any{"id": "1"}}}}
+			if diff := cmp.Diff(wantRequest, received); diff != "" {
+				t.Errorf("VerifyInteraction() producer request mismatch (-want +got):\n%s", diff)
+			}
+			if (verified.GetError() != "") != tt.wantErr {
+				t.Fatalf("VerifyInteraction() error = %q, wantErr %v", verified.GetError(), tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			result := verified.GetResult()
+			if result.Success != tt.wantSuccess {
+				t.Errorf("VerifyInteraction() success = %v, want %v: %v", result.Success, tt.wantSuccess, result.Mismatches)
+			}
+			var paths []string
+			for _, item := range result.Mismatches {
+				paths = append(paths, item.GetMismatch().GetPath())
+			}
+			if diff := cmp.Diff(tt.wantPaths, paths, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("VerifyInteraction() mismatch paths (-want +got):\n%s", diff)
+			}
+			if tt.wantSuccess {
+				if key := result.ResponseData.GetMetadata()[KEY_METADATA_KEY].GetBinaryValue(); string(key) != "user-1" {
+					t.Errorf("VerifyInteraction() response key = %q, want user-1", key)
+				}
+			}
+		})
+	}
+}
+
+// TestStartMockServer tests consuming the messages of a pact from the Kafka mock server with a
+// Kafka client, in a consumer group and from assigned partitions, and the results of the mock server
+func TestStartMockServer(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	created := mustConfigureAvroInteraction(t, client, map[string]any{
+		"schemaId":  62,
+		"schema":    testUserSchema,
+		"topic":     "users",
+		"timestamp": 1700000000000,
+		"headers":   map[string]any{"event-type": "user.created", "checksum": map[string]any{"binary": "yv4="}},
+		"message": map[string]any{
+			"id":         "94af717d-1b04-4fad-9879-01dc828e410d",
+			"email":      "jane@example.com",
+			"tags":       []any{"admin"},
+			"attributes": map[string]any{},
+		},
+	})
+	// the tombstone is keyed, as those deleting the keys of log-compacted topics are
+	deleted := mustConfigureAvroInteraction(t, client, map[string]any{"tombstone": true, "topic": "users", "partition": 1, "key": map[string]any{"serializer": "string", "message": "user-1"}})
+	pact := testPact(t, map[string]*pb.ConfigureInteractionResponse{"a user created event": created, "a user deleted event": deleted})
+
+	type consumedRecord struct {
+		Topic     string
+		Partition int32
+		Offset    int64
+		Timestamp int64
+		Key       []byte
+		Value     []byte
+		Headers   map[string]string
+	}
+	createdRecord := consumedRecord{
+		Topic:     "users",
+		Timestamp: 1700000000000,
+		Value:     created.Interaction[0].Contents.Content.GetValue(),
+		Headers:   map[string]string{"checksum": "\xca\xfe", "event-type": "user.created"},
+	}
+	deletedRecord := consumedRecord{Topic: "users", Partition: 1, Key: []byte("user-1")}
+
+	unconsumed := &pb.MockServerResult{Path: "Fetch users/1", Error: "Expected message 'a user deleted event' at offset 0 was not consumed"}
+	tests := []struct {
+		name        string
+		group       string
+		opts        []kgo.Opt
+		want        []consumedRecord
+		wantOk      bool
+		wantResults []*pb.MockServerResult
+	}{
+		{
+			name:   "consumer group",
+			group:  "users-service",
+			opts:   []kgo.Opt{kgo.ConsumeTopics("users")},
+			want:   []consumedRecord{createdRecord, deletedRecord},
+			wantOk: true,
+			wantResults: []*pb.MockServerResult{
+				{Path: "Fetch users/0"},
+				{Path: "Fetch users/1"},
+				{Path: "OffsetCommit users-service users/0 offset 1"},
+				{Path: "OffsetCommit users-service users/1 offset 1"},
+			},
+		},
+		{
+			name:        "assigned partition",
+			opts:        []kgo.Opt{kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{"users": {0: kgo.NewOffset().AtStart()}})},
+			want:        []consumedRecord{createdRecord},
+			wantResults: []*pb.MockServerResult{{Path: "Fetch users/0"}, unconsumed},
+		},
+		{
+			name: "unexpected topic",
+			opts: []kgo.Opt{kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{
+				"users":  {0: kgo.NewOffset().AtStart()},
+				"orders": {0: kgo.NewOffset().AtStart()},
+			})},
+			want: []consumedRecord{createdRecord},
+			wantResults: []*pb.MockServerResult{
+				{Path: "Fetch users/0"},
+				unconsumed,
+				{Path: "Metadata orders", Error: "Unexpected subscription to topic orders, which has no messages in the pact"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started, err := client.StartMockServer(context.Background(), &pb.StartMockServerRequest{Pact: pact})
+			if err != nil {
+				t.Fatalf("StartMockServer() unexpected error: %v", err)
+			}
+			details := started.GetDetails()
+			if details == nil {
+				t.Fatalf("StartMockServer() error: %s", started.GetError())
+			}
+
+			opts := append([]kgo.Opt{
+				kgo.SeedBrokers(net.JoinHostPort(details.Address, strconv.Itoa(int(details.Port)))),
+				kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+				kgo.FetchMaxWait(100 * time.Millisecond),
+			}, tt.opts...)
+			if tt.group != "" {
+				opts = append(opts, kgo.ConsumerGroup(tt.group))
+			}
+			consumer, err := kgo.NewClient(opts...)
+			if err != nil {
+				t.Fatalf("failed to create the Kafka client: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			var consumed []consumedRecord
+			for len(consumed) < len(tt.want) && ctx.Err() == nil {
+				fetches := consumer.PollFetches(ctx)
+				for _, fetchErr := range fetches.Errors() {
+					if ctx.Err() == nil && fetchErr.Topic != "orders" {
+						t.Errorf("PollFetches() unexpected error: %v", fetchErr.Err)
+					}
+				}
+				fetches.EachRecord(func(r *kgo.Record) {
+					record := consumedRecord{Topic: r.Topic, Partition: r.Partition, Offset: r.Offset, Key: r.Key, Value: r.Value}
+					if r.Value != nil {
+						record.Timestamp = r.Timestamp.UnixMilli()
+					}
+					for _, header := range r.Headers {
+						if record.Headers == nil {
+							record.Headers = make(map[string]string)
+						}
+						record.Headers[header.Key] = string(header.Value)
+					}
+					consumed = append(consumed, record)
+				})
+			}
+			if tt.group != "" {
+				if err := consumer.CommitUncommittedOffsets(ctx); err != nil {
+					t.Errorf("CommitUncommittedOffsets() unexpected error: %v", err)
+				}
+			}
+			consumer.Close()
+
+			sort.Slice(consumed, func(i, j int) bool { return consumed[i].Partition < consumed[j].Partition })
+			if diff := cmp.Diff(tt.want, consumed); diff != "" {
+				t.Errorf("consumed records mismatch (-want +got):\n%s", diff)
+			}
+
+			results, err := client.GetMockServerResults(context.Background(), &pb.MockServerRequest{ServerKey: details.Key})
+			if err != nil {
+				t.Fatalf("GetMockServerResults() unexpected error: %v", err)
+			}
+			if results.Ok != tt.wantOk {
+				t.Errorf("GetMockServerResults() ok = %v, want %v", results.Ok, tt.wantOk)
+			}
+			if diff := cmp.Diff(tt.wantResults, results.Results, protocmp.Transform()); diff != "" {
+				t.Errorf("GetMockServerResults() results mismatch (-want +got):\n%s", diff)
+			}
+
+			shutdown, err := client.ShutdownMockServer(context.Background(), &pb.ShutdownMockServerRequest{ServerKey: details.Key})
+			if err != nil {
+				t.Fatalf("ShutdownMockServer() unexpected error: %v", err)
+			}
+			if shutdown.Ok != tt.wantOk {
+				t.Errorf("ShutdownMockServer() ok = %v, want %v", shutdown.Ok, tt.wantOk)
+			}
+			if diff := cmp.Diff(tt.wantResults, shutdown.Results, protocmp.Transform()); diff != "" {
+				t.Errorf("ShutdownMockServer() results mismatch (-want +got):\n%s", diff)
+			}
+			if _, err := client.GetMockServerResults(context.Background(), &pb.MockServerRequest{ServerKey: details.Key}); err == nil {
+				t.Error("GetMockServerResults() expected an error after the mock server was shut down")
+			}
+		})
+	}
+
+	// an interaction without a topic can not be served
+	untopiced := mustConfigureAvroInteraction(t, client, map[string]any{"schemaId": 62, "schema": `"string"`, "message": "jane"})
+	started, err := client.StartMockServer(context.Background(), &pb.StartMockServerRequest{
+		Pact: testPact(t, map[string]*pb.ConfigureInteractionResponse{"a name": untopiced}),
+	})
+	if err != nil {
+		t.Fatalf("StartMockServer() unexpected error: %v", err)
+	}
+	if !strings.Contains(started.GetError(), "has no topic") {
+		t.Errorf("StartMockServer() error = %q, want an interaction without a topic error", started.GetError())
+	}
+}
+
+// TestMockServerProduce tests producing the messages of a pact to the Kafka mock server in producer
+// mode with a Kafka client, and matching the produced records against the pact in the results of the
+// mock server
+func TestMockServerProduce(t *testing.T) {
+	client, conn := getClient(t)
+	// nolint:errcheck
+	defer conn.Close()
+
+	created := mustConfigureAvroInteraction(t, client, map[string]any{
+		"schemaId": 62,
+		"schema":   testUserSchema,
+		"topic":    "users",
+		"headers":  map[string]any{"event-type": "user.created"},
+		"message": map[string]any{
+			"id":         "94af717d-1b04-4fad-9879-01dc828e410d",
+			"email":      "jane@example.com",
+			"tags":       []any{"admin"},
+			"attributes": map[string]any{},
+		},
+	})
+	deleted := mustConfigureAvroInteraction(t, client, map[string]any{"tombstone": true, "topic": "users", "partition": 1})
+	pact := testPact(t, map[string]*pb.ConfigureInteractionResponse{"a user created event": created, "a user deleted event": deleted})
+
+	createdRecord := &kgo.Record{
+		Topic:   "users",
+		Value:   created.Interaction[0].Contents.Content.GetValue(),
+		Headers: []kgo.RecordHeader{{Key: "event-type", Value: []byte("user.created")}, {Key: "trace-id", Value: []byte("abc")}},
+	}
+	deletedRecord := &kgo.Record{Topic: "users", Partition: 1}
+	notDeleted := &pb.MockServerResult{Path: "Produce users/1", Error: "Expected message 'a user deleted event' was not produced"}
+
+	// a producer whose schema has evolved adds fields to the consumer's message
+	const accountSchema = `{"type": "record", "name": "Account", "fields": [{"name": "id", "type": "string"}]}`
+	dir := t.TempDir()
+	evolvedSchema := `{"type": "record", "name": "Account", "fields": [{"name": "id", "type": "string"}, {"name": "status", "type": "string"}]}`
+	if err := os.WriteFile(filepath.Join(dir, "71.avsc"), []byte(evolvedSchema), 0644); err != nil {
+		t.Fatalf("failed to write schema file: %v", err)
+	}
+	opened := mustConfigureAvroInteraction(t, client, map[string]any{"schemaId": 70, "schema": accountSchema, "schemaDirectory": dir, "topic": "accounts", "message": map[string]any{"id": "1"}})
+	accounts := testPact(t, map[string]*pb.ConfigureInteractionResponse{"an account opened event": opened})
+	evolved, err := testAvroAPI.Marshal(avro.MustParse(evolvedSchema), map[string]any{"id": "1", "status": "OPEN"})
+	if err != nil {
+		t.Fatalf("failed to marshal avro message: %v", err)
+	}
+	evolvedRecord := &kgo.Record{Topic: "accounts", Value: EncodeWireFormat(71, evolved)}
+
+	tests := []struct {
+		name        string
+		pact        string
+		mode        string
+		config      map[string]any
+		records     []*kgo.Record
+		wantOk      bool
+		wantResults []*pb.MockServerResult
+	}{
+		{
+			name:        "expected messages",
+			records:     []*kgo.Record{createdRecord, deletedRecord},
+			wantOk:      true,
+			wantResults: []*pb.MockServerResult{{Path: "Produce users/0"}, {Path: "Produce users/1"}},
+		},
+		{
+			name: "mismatching record",
+			records: []*kgo.Record{{
+				Topic:   "users",
+				Value:   createdRecord.Value,
+				Headers: []kgo.RecordHeader{{Key: "event-type", Value: []byte("user.updated")}},
+			}},
+			wantResults: []*pb.MockServerResult{
+				{Path: "Produce users/0", Error: "Produced record at offset 1 does not match any expected message, the closest is 'a user created event'"},
+				{Path: "Produce users/0", Error: "Expected message 'a user created event' was not produced"},
+				notDeleted,
+			},
+		},
+		{
+			name:    "message produced twice",
+			records: []*kgo.Record{createdRecord, deletedRecord, createdRecord},
+			wantResults: []*pb.MockServerResult{
+				{Path: "Produce users/0"},
+				{Path: "Produce users/1"},
+				{Path: "Produce users/0", Error: "Unexpected record at offset 2, the expected messages of topic users were already produced"},
+			},
+		},
+		{
+			name:    "unexpected topic",
+			records: []*kgo.Record{createdRecord, {Topic: "orders", Value: []byte("order")}},
+			wantResults: []*pb.MockServerResult{
+				{Path: "Produce users/0"},
+				notDeleted,
+				{Path: "Metadata orders", Error: "Unexpected subscription to topic orders, which has no messages in the pact"},
+			},
+		},
+		{
+			name:        "nothing produced",
+			wantResults: []*pb.MockServerResult{{Path: "Produce users/0", Error: "Expected message 'a user created event' was not produced"}, notDeleted},
+		},
+		{
+			name:        "fields added by the producer",
+			pact:        accounts,
+			records:     []*kgo.Record{evolvedRecord},
+			wantOk:      true,
+			wantResults: []*pb.MockServerResult{{Path: "Produce accounts/0"}},
+		},
+		{
+			name:    "fields added by the producer not allowed",
+			pact:    accounts,
+			config:  map[string]any{"allowUnexpectedKeys": false},
+			records: []*kgo.Record{evolvedRecord},
+			wantResults: []*pb.MockServerResult{
+				{Path: "Produce accounts/0", Error: "Produced record at offset 1 does not match any expected message, the closest is 'an account opened event'"},
+				{Path: "Produce accounts/0", Error: "Expected message 'an account opened event' was not produced"},
+			},
+		},
+		{
+			name:    "consumer mode",
+			mode:    CONSUMER_MODE,
+			records: []*kgo.Record{createdRecord},
+			wantResults: []*pb.MockServerResult{
+				{Path: "Fetch users/0", Error: "Expected message 'a user created event' at offset 0 was not consumed"},
+				{Path: "Fetch users/1", Error: "Expected message 'a user deleted event' at offset 0 was not consumed"},
+				{Path: "Produce users/0", Error: "Unexpected produce to partition users/0, the mock server is not in producer mode"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, serverPact := tt.mode, tt.pact
+			if mode == "" {
+				mode = PRODUCER_MODE
+			}
+			if serverPact == "" {
+				serverPact = pact
+			}
+			config := map[string]any{"mode": mode}
+			maps.Copy(config, tt.config)
+			testContext, err := structpb.NewStruct(config)
+			if err != nil {
+				t.Fatalf("failed to build test context: %v", err)
+			}
+			started, err := client.StartMockServer(context.Background(), &pb.StartMockServerRequest{Pact: serverPact, TestContext: testContext})
+			if err != nil {
+				t.Fatalf("StartMockServer() unexpected error: %v", err)
+			}
+			details := started.GetDetails()
+			if details == nil {
+				t.Fatalf("StartMockServer() error: %s", started.GetError())
+			}
+
+			producer, err := kgo.NewClient(
+				kgo.SeedBrokers(net.JoinHostPort(details.Address, strconv.Itoa(int(details.Port)))),
+				kgo.RecordPartitioner(kgo.ManualPartitioner()),
+				kgo.RecordDeliveryTimeout(time.Second),
+			)
+			if err != nil {
+				t.Fatalf("failed to create the Kafka client: %v", err)
+			}
+			for _, record := range tt.records {
+				record := *record
+				if err := producer.ProduceSync(context.Background(), &record).FirstErr(); err != nil && record.Topic != "orders" && mode == PRODUCER_MODE {
+					t.Errorf("ProduceSync() unexpected error: %v", err)
+				}
+			}
+			producer.Close()
+
+			results, err := client.ShutdownMockServer(context.Background(), &pb.ShutdownMockServerRequest{ServerKey: details.Key})
+			if err != nil {
+				t.Fatalf("ShutdownMockServer() unexpected error: %v", err)
+			}
+			if results.Ok != tt.wantOk {
+				t.Errorf("ShutdownMockServer() ok = %v, want %v", results.Ok, tt.wantOk)
+			}
+			if diff := cmp.Diff(tt.wantResults, results.Results, protocmp.Transform(), protocmp.IgnoreFields(&pb.MockServerResult{}, "mismatches")); diff != "" {
+				t.Errorf("ShutdownMockServer() results mismatch (-want +got):\n%s", diff)
+			}
+			for _, result := range results.Results {
+				if strings.Contains(result.Error, "closest") && len(result.Mismatches) == 0 {
+					t.Errorf("ShutdownMockServer() result %q has no mismatches", result.Error)
+				}
+			}
+		})
+	}
+
+	// the mode of the mock server is either consumer or producer
+	testContext, err := structpb.NewStruct(map[string]any{"mode": "both"})
+	if err != nil {
+		t.Fatalf("failed to build test context: %v", err)
+	}
+	started, err := client.StartMockServer(context.Background(), &pb.StartMockServerRequest{Pact: pact, TestContext: testContext})
+	if err != nil {
+		t.Fatalf("StartMockServer() unexpected error: %v", err)
+	}
+	if !strings.Contains(started.GetError(), "mode must be consumer or producer") {
+		t.Errorf("StartMockServer() error = %q, want an invalid mode error", started.GetError())
+	}
+}
+
+// TestMockBrokerUnhandledRequest tests that a request the mock broker has no handler for closes the
+// connection and is reported as unexpected, instead of failing the plugin
+func TestMockBrokerUnhandledRequest(t *testing.T) {
+	mockBrokerVersions[kmsg.DeleteTopics] = [2]int16{0, 0}
+	t.Cleanup(func() { delete(mockBrokerVersions, kmsg.DeleteTopics) })
+
+	broker, err := newMockBroker(&pactFile{}, &mockServerConfig{mode: CONSUMER_MODE, allowUnexpectedKeys: true})
+	if err != nil {
+		t.Fatalf("newMockBroker() unexpected error: %v", err)
+	}
+	client, server := net.Pipe()
+	// nolint:errcheck
+	defer client.Close()
+	broker.conns[server] = struct{}{}
+	done := make(chan struct{})
+	go func() {
+		broker.handle(server)
+		close(done)
+	}()
+
+	req := kmsg.NewPtrDeleteTopicsRequest()
+	data := binary.BigEndian.AppendUint16(nil, uint16(req.Key()))
+	data = binary.BigEndian.AppendUint16(data, 0)
+	data = binary.BigEndian.AppendUint32(data, 1)
+	data = binary.BigEndian.AppendUint16(data, 0)
+	data = req.AppendTo(data)
+	if _, err := client.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data)))); err != nil {
+		t.Fatalf("failed to write request size: %v", err)
+	}
+	if _, err := client.Write(data); err != nil {
+		t.Fatalf("failed to write request: %v", err)
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read() error = %v, want the connection to be closed", err)
+	}
+	<-done
+
+	want := map[string]*pb.MockServerResult{
+		"request DeleteTopics": {Path: "DeleteTopics", Error: "Unexpected DeleteTopics request, which the mock server does not support"},
+	}
+	if diff := cmp.Diff(want, broker.unexpected, protocmp.Transform()); diff != "" {
+		t.Errorf("handle() unexpected results mismatch (-want +got):\n%s", diff)
+	}
+}
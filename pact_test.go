@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+// TestParsePact tests reading the interactions, rules and plugin configuration of a pact file
+func TestParsePact(t *testing.T) {
+	pact, err := parsePact(`{
+		"interactions": [{
+			"type": "Asynchronous/Messages",
+			"key": "1a2b",
+			"description": "an order event",
+			"contents": {"content": "AAAAAAEC", "contentType": "application/vnd.kafka.avro.v2", "encoded": "base64"},
+			"metadata": {"contentType": "application/vnd.kafka.avro.v2", "kafka_partition": 2, "trace": "AQI="},
+			"matchingRules": {
+				"body": {"$.id": {"combine": "AND", "matchers": [{"match": "regex", "regex": "^[0-9]+$"}]}},
+				"metadata": {"kafka_partition": {"combine": "AND", "matchers": [{"match": "integer"}]}}
+			},
+			"pluginConfiguration": {"kafka": {"schemaId": 1, "binaryHeaders": ["trace"]}}
+		}],
+		"metadata": {"plugins": [
+			{"name": "kafka", "version": "0.1.0", "configuration": {"schemaDirectory": "schemas"}},
+			{"name": "protobuf", "version": "0.3.0", "configuration": {"other": true}}
+		]}
+	}`)
+	if err != nil {
+		t.Fatalf("parsePact() unexpected error: %v", err)
+	}
+	interaction, err := pact.interaction("1a2b")
+	if err != nil {
+		t.Fatalf("interaction() unexpected error: %v", err)
+	}
+	if byDescription, err := pact.interaction("an order event"); err != nil || byDescription != interaction {
+		t.Errorf("interaction() by description = %v, %v", byDescription, err)
+	}
+	if _, err := pact.interaction("unknown"); err == nil {
+		t.Error("interaction() expected an error for an unknown interaction")
+	}
+
+	body, err := interaction.body()
+	if err != nil {
+		t.Fatalf("body() unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]byte{0, 0, 0, 0, 1, 2}, body.Content.GetValue()); diff != "" {
+		t.Errorf("body() mismatch (-want +got):\n%s", diff)
+	}
+
+	rules, err := interaction.rules("body")
+	if err != nil {
+		t.Fatalf("rules() unexpected error: %v", err)
+	}
+	want := ruleSet{"$.id": {Rule: []*pb.MatchingRule{newMatchingRule("regex", map[string]any{"regex": "^[0-9]+$"})}}}
+	if diff := cmp.Diff(want, rules, protocmp.Transform()); diff != "" {
+		t.Errorf("rules() mismatch (-want +got):\n%s", diff)
+	}
+
+	config, err := pact.pluginConfiguration(interaction)
+	if err != nil {
+		t.Fatalf("pluginConfiguration() unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(map[string]any{"schemaDirectory": "schemas"}, config.PactConfiguration.AsMap()); diff != "" {
+		t.Errorf("pluginConfiguration() pact configuration mismatch (-want +got):\n%s", diff)
+	}
+	metadata, err := interaction.expectedMetadata(config)
+	if err != nil {
+		t.Fatalf("expectedMetadata() unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(map[string]any{"kafka_partition": int64(2), "trace": []byte{1, 2}}, metadata); diff != "" {
+		t.Errorf("expectedMetadata() mismatch (-want +got):\n%s", diff)
+	}
+}
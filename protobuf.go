@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// PROTOBUF_SCHEMA_FILE is the name the inline .proto source is compiled under
+const PROTOBUF_SCHEMA_FILE = "schema.proto"
+
+// newProtobufFormat creates the protobuf message format from the contentsConfig. The schema is either
+// .proto source (schema, schemaFile or schemaRegistry) or a FileDescriptorSet given base64 encoded
+// with "descriptorSet" or as a file with "descriptorSetFile". The message type is selected with
+// "messageType" and defaults to the first message of the schema.
+func newProtobufFormat(source *schemaSource, fields map[string]*structpb.Value) (*protobufFormat, error) {
+	config := map[string]*structpb.Value{}
+	for _, key := range []string{"descriptorSet", "messageType"} {
+		if v, ok := fields[key]; ok {
+			if _, ok := v.Kind.(*structpb.Value_StringValue); !ok {
+				return nil, fmt.Errorf("%s field must be a string", key)
+			}
+			config[key] = v
+		}
+	}
+	if v, ok := fields["descriptorSetFile"]; ok {
+		path, err := absolutePath("descriptorSetFile", v)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read descriptor set file: %w", err)
+		}
+		config["descriptorSet"] = structpb.NewStringValue(base64.StdEncoding.EncodeToString(data))
+	}
+	if source != nil {
+		if _, ok := config["descriptorSet"]; ok {
+			return nil, fmt.Errorf("only one of a protobuf schema or a descriptor set may be given")
+		}
+		config["schema"] = structpb.NewStringValue(source.text)
+	}
+	return loadProtobufFormat(config)
+}
+
+// loadProtobufFormat loads the protobuf message format from an interaction configuration
+func loadProtobufFormat(config map[string]*structpb.Value) (*protobufFormat, error) {
+	var (
+		files []protoreflect.FileDescriptor
+		err   error
+	)
+	switch {
+	case config["schema"].GetStringValue() != "":
+		files, err = compileProtobufSchema(config["schema"].GetStringValue())
+	case config["descriptorSet"].GetStringValue() != "":
+		files, err = loadDescriptorSet(config["descriptorSet"].GetStringValue())
+	default:
+		err = fmt.Errorf("a protobuf schema or descriptor set is required")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := findMessageType(files, config["messageType"].GetStringValue())
+	if err != nil {
+		return nil, err
+	}
+	config["messageType"] = structpb.NewStringValue(string(message.FullName()))
+	config["schemaType"] = structpb.NewStringValue("PROTOBUF")
+
+	return &protobufFormat{message: message, indexes: messageIndexes(message), config: config}, nil
+}
+
+// compileProtobufSchema compiles .proto source. Only the standard imports, such as the well known
+// types, can be imported.
+func compileProtobufSchema(source string) ([]protoreflect.FileDescriptor, error) {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(map[string]string{PROTOBUF_SCHEMA_FILE: source}),
+		}),
+	}
+	compiled, err := compiler.Compile(context.Background(), PROTOBUF_SCHEMA_FILE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile protobuf schema: %w", err)
+	}
+	return []protoreflect.FileDescriptor{compiled[0]}, nil
+}
+
+// loadDescriptorSet loads the files of a base64 encoded FileDescriptorSet. The schema is
+// the last file of the set, the others being its imports.
+func loadDescriptorSet(encoded string) ([]protoreflect.FileDescriptor, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode descriptor set: %w", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse descriptor set: %w", err)
+	}
+	if len(set.File) == 0 {
+		return nil, fmt.Errorf("descriptor set contains no files")
+	}
+	registry, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load descriptor set: %w", err)
+	}
+
+	files := make([]protoreflect.FileDescriptor, len(set.File))
+	for i, fileProto := range set.File {
+		if files[i], err = registry.FindFileByPath(fileProto.GetName()); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// findMessageType finds a message type by its full name, defaulting to the first message of the schema
+func findMessageType(files []protoreflect.FileDescriptor, name string) (protoreflect.MessageDescriptor, error) {
+	if name == "" {
+		schema := files[len(files)-1]
+		if schema.Messages().Len() == 0 {
+			return nil, fmt.Errorf("protobuf schema %s defines no messages", schema.Path())
+		}
+		return schema.Messages().Get(0), nil
+	}
+
+	var found protoreflect.MessageDescriptor
+	for _, file := range files {
+		walkMessages(file.Messages(), func(desc protoreflect.MessageDescriptor) {
+			if string(desc.FullName()) == name {
+				found = desc
+			}
+		})
+	}
+	if found == nil {
+		return nil, fmt.Errorf("message type %s not found in the protobuf schema", name)
+	}
+	return found, nil
+}
+
+func walkMessages(messages protoreflect.MessageDescriptors, fn func(protoreflect.MessageDescriptor)) {
+	for i := 0; i < messages.Len(); i++ {
+		fn(messages.Get(i))
+		walkMessages(messages.Get(i).Messages(), fn)
+	}
+}
+
+// messageIndexes returns the Confluent message indexes locating a message type within its file
+func messageIndexes(desc protoreflect.MessageDescriptor) []int {
+	indexes := []int{desc.Index()}
+	for parent, ok := desc.Parent().(protoreflect.MessageDescriptor); ok; parent, ok = parent.Parent().(protoreflect.MessageDescriptor) {
+		indexes = append([]int{parent.Index()}, indexes...)
+	}
+	return indexes
+}
+
+// protobufFormat is the message format of application/vnd.kafka.protobuf.v2
+type protobufFormat struct {
+	message protoreflect.MessageDescriptor
+	indexes []int
+	config  map[string]*structpb.Value
+}
+
+func (f *protobufFormat) Frame(schemaID uint32, payload []byte) []byte {
+	return EncodeWireFormat(schemaID, append(EncodeMessageIndexes(f.indexes), payload...))
+}
+
+func (f *protobufFormat) Unframe(data []byte) (uint32, []byte, error) {
+	schemaID, payload, err := DecodeWireFormat(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	indexes, payload, err := DecodeMessageIndexes(payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !slices.Equal(indexes, f.indexes) {
+		return 0, nil, fmt.Errorf("message indexes %v do not select message type %s (%v)", indexes, f.message.FullName(), f.indexes)
+	}
+	return schemaID, payload, nil
+}
+
+func (f *protobufFormat) ParseMessage(value any) (any, ruleSet, map[string]*pb.Generator, error) {
+	extractor := &definitionExtractor{rules: make(ruleSet), generators: make(map[string]*pb.Generator)}
+	cleaned, err := extractor.protobuf("$", f.message, value)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	msg, err := f.fromJSON(cleaned)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return protoToGeneric(msg.ProtoReflect()), extractor.rules, extractor.generators, nil
+}
+
+func (f *protobufFormat) Decode(payload []byte) (any, error) {
+	msg := dynamicpb.NewMessage(f.message)
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return nil, fmt.Errorf("failed to decode protobuf message: %w", err)
+	}
+	return protoToGeneric(msg), nil
+}
+
+func (f *protobufFormat) Encode(value any) ([]byte, error) {
+	msg, err := f.fromJSON(value)
+	if err != nil {
+		return nil, err
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+}
+
+func (f *protobufFormat) Compare(expected, actual any, rules ruleSet, allowUnexpectedKeys bool) mismatches {
+	return CompareJSON(expected, actual, rules, allowUnexpectedKeys)
+}
+
+func (f *protobufFormat) ApplyGenerators(value any, generators map[string]*pb.Generator, testContext map[string]any) (any, error) {
+	return ApplyJSONGenerators(value, generators, testContext)
+}
+
+func (f *protobufFormat) Configuration() map[string]*structpb.Value {
+	return f.config
+}
+
+// fromJSON builds a message from a value in the generic representation, using the protobuf JSON mapping
+func (f *protobufFormat) fromJSON(value any) (*dynamicpb.Message, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	msg := dynamicpb.NewMessage(f.message)
+	if err := protojson.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("message does not match protobuf message type %s: %w", f.message.FullName(), err)
+	}
+	return msg, nil
+}
+
+// isWellKnownType returns true for the google.protobuf types with a special JSON mapping
+func isWellKnownType(desc protoreflect.MessageDescriptor) bool {
+	return strings.HasPrefix(string(desc.FullName()), "google.protobuf.")
+}
+
+// protoToGeneric converts a message into the generic representation of JSON values, keyed by
+// the proto field names. Unset message fields and proto3 optional fields are null, and oneof
+// fields are only present when set. Well known types use their protobuf JSON mapping.
+func protoToGeneric(msg protoreflect.Message) any {
+	if isWellKnownType(msg.Descriptor()) {
+		data, err := protojson.Marshal(msg.Interface())
+		if err != nil {
+			return nil
+		}
+		var value any
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil
+		}
+		return value
+	}
+
+	result := make(map[string]any)
+	fields := msg.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		name := string(fd.Name())
+		if oneof := fd.ContainingOneof(); oneof != nil && !oneof.IsSynthetic() && !msg.Has(fd) {
+			continue
+		}
+		if fd.HasPresence() && !msg.Has(fd) {
+			result[name] = nil
+			continue
+		}
+
+		value := msg.Get(fd)
+		switch {
+		case fd.IsList():
+			list := value.List()
+			items := make([]any, list.Len())
+			for j := range items {
+				items[j] = protoValueToGeneric(fd, list.Get(j))
+			}
+			result[name] = items
+		case fd.IsMap():
+			entries := make(map[string]any)
+			value.Map().Range(func(key protoreflect.MapKey, v protoreflect.Value) bool {
+				entries[key.String()] = protoValueToGeneric(fd.MapValue(), v)
+				return true
+			})
+			result[name] = entries
+		default:
+			result[name] = protoValueToGeneric(fd, value)
+		}
+	}
+	return result
+}
+
+func protoValueToGeneric(fd protoreflect.FieldDescriptor, value protoreflect.Value) any {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return value.Bool()
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return value.Int()
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if v := value.Uint(); v <= math.MaxInt64 {
+			return int64(v)
+		}
+		return float64(value.Uint())
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return value.Float()
+	case protoreflect.StringKind:
+		return value.String()
+	case protoreflect.BytesKind:
+		return value.Bytes()
+	case protoreflect.EnumKind:
+		if enum := fd.Enum().Values().ByNumber(value.Enum()); enum != nil {
+			return string(enum.Name())
+		}
+		return int64(value.Enum())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return protoToGeneric(value.Message())
+	}
+	return nil
+}
+
+// protobuf walks a JSON message following the message descriptor, so that fields given with
+// their JSON name are recorded under the proto field name
+func (e *definitionExtractor) protobuf(path string, desc protoreflect.MessageDescriptor, value any) (any, error) {
+	value, err := e.extract(path, value)
+	if err != nil {
+		return nil, err
+	}
+	object, ok := value.(map[string]any)
+	if !ok || isWellKnownType(desc) {
+		return value, nil
+	}
+
+	result := make(map[string]any, len(object))
+	for key, item := range object {
+		fd := desc.Fields().ByName(protoreflect.Name(key))
+		if fd == nil {
+			fd = desc.Fields().ByJSONName(key)
+		}
+		if fd == nil {
+			return nil, fmt.Errorf("%s: field is not defined in message %s", PathField(path, key), desc.FullName())
+		}
+		fieldPath := PathField(path, string(fd.Name()))
+
+		converted, err := e.protobufField(fieldPath, fd, item)
+		if err != nil {
+			return nil, err
+		}
+		result[string(fd.Name())] = converted
+	}
+	return result, nil
+}
+
+func (e *definitionExtractor) protobufField(path string, fd protoreflect.FieldDescriptor, value any) (any, error) {
+	value, err := e.extract(path, value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case fd.IsList():
+		items, ok := value.([]any)
+		if !ok {
+			return value, nil
+		}
+		result := make([]any, len(items))
+		for i, item := range items {
+			if result[i], err = e.protobufValue(PathIndex(path, i), fd, item); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	case fd.IsMap():
+		entries, ok := value.(map[string]any)
+		if !ok {
+			return value, nil
+		}
+		result := make(map[string]any, len(entries))
+		for key, item := range entries {
+			if result[key], err = e.protobufValue(PathField(path, key), fd.MapValue(), item); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+	return e.protobufValue(path, fd, value)
+}
+
+func (e *definitionExtractor) protobufValue(path string, fd protoreflect.FieldDescriptor, value any) (any, error) {
+	if fd.Message() != nil {
+		return e.protobuf(path, fd.Message(), value)
+	}
+	return e.extract(path, value)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// TestProtobufMessageType tests selecting the message type and its message indexes
+func TestProtobufMessageType(t *testing.T) {
+	tests := []struct {
+		messageType string
+		want        string
+		indexes     []int
+	}{
+		{messageType: "", want: "shop.Order", indexes: []int{0}},
+		{messageType: "shop.Order", want: "shop.Order", indexes: []int{0}},
+		{messageType: "shop.Order.Line", want: "shop.Order.Line", indexes: []int{0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			format, err := loadProtobufFormat(map[string]*structpb.Value{
+				"schema":      structpb.NewStringValue(testOrderProto),
+				"messageType": structpb.NewStringValue(tt.messageType),
+			})
+			if err != nil {
+				t.Fatalf("loadProtobufFormat() unexpected error: %v", err)
+			}
+			if got := string(format.message.FullName()); got != tt.want {
+				t.Errorf("loadProtobufFormat() message type = %s, want %s", got, tt.want)
+			}
+			if !slices.Equal(format.indexes, tt.indexes) {
+				t.Errorf("loadProtobufFormat() indexes = %v, want %v", format.indexes, tt.indexes)
+			}
+		})
+	}
+
+	if _, err := loadProtobufFormat(map[string]*structpb.Value{
+		"schema":      structpb.NewStringValue(testOrderProto),
+		"messageType": structpb.NewStringValue("shop.Missing"),
+	}); err == nil {
+		t.Error("loadProtobufFormat() expected error for an unknown message type")
+	}
+}
+
+// TestProtobufDescriptorSet tests loading the schema from a FileDescriptorSet and decoding a message
+func TestProtobufDescriptorSet(t *testing.T) {
+	files, err := compileProtobufSchema(testOrderProto)
+	if err != nil {
+		t.Fatalf("compileProtobufSchema() unexpected error: %v", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	for i := 0; i < files[0].Imports().Len(); i++ {
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(files[0].Imports().Get(i).FileDescriptor))
+	}
+	set.File = append(set.File, protodesc.ToFileDescriptorProto(files[0]))
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatalf("failed to marshal descriptor set: %v", err)
+	}
+
+	format, err := newProtobufFormat(nil, map[string]*structpb.Value{
+		"descriptorSet": structpb.NewStringValue(base64.StdEncoding.EncodeToString(data)),
+	})
+	if err != nil {
+		t.Fatalf("newProtobufFormat() unexpected error: %v", err)
+	}
+
+	order := map[string]any{
+		"id":          "order-1",
+		"customer_id": int64(42),
+		"lines":       []any{map[string]any{"sku": "ABC-1", "quantity": int64(2)}},
+		"status":      "SHIPPED",
+		"placed_at":   "2024-01-01T00:00:00Z",
+		"note":        nil,
+	}
+	payload, err := format.Encode(order)
+	if err != nil {
+		t.Fatalf("Encode() unexpected error: %v", err)
+	}
+	decoded, err := format.Decode(payload)
+	if err != nil {
+		t.Fatalf("Decode() unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(order, decoded); diff != "" {
+		t.Errorf("Decode() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, _, err := format.Unframe(EncodeWireFormat(1, append(EncodeMessageIndexes([]int{1}), payload...))); err == nil {
+		t.Error("Unframe() expected error for the indexes of another message type")
+	}
+
+	descriptorSetFile := filepath.Join(t.TempDir(), "order.pb")
+	if err := os.WriteFile(descriptorSetFile, data, 0644); err != nil {
+		t.Fatalf("failed to write descriptor set file: %v", err)
+	}
+	tests := []struct {
+		name    string
+		file    *structpb.Value
+		wantErr bool
+	}{
+		{name: "absolute path", file: structpb.NewStringValue(descriptorSetFile)},
+		{name: "relative path", file: structpb.NewStringValue("order.pb"), wantErr: true},
+		{name: "not a string", file: structpb.NewNumberValue(1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newProtobufFormat(nil, map[string]*structpb.Value{"descriptorSetFile": tt.file})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newProtobufFormat() with descriptorSetFile error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// PLUGIN_MANIFEST is the plugin manifest, read once at startup from the directory of the plugin executable
+	PLUGIN_MANIFEST = "pact-plugin.json"
+	// REGISTRY_URL_ENV overrides the schema registry URL of the plugin manifest
+	REGISTRY_URL_ENV = "PACT_KAFKA_SCHEMA_REGISTRY_URL"
+	// REGISTRY_USERNAME_ENV overrides the schema registry basic auth username of the plugin manifest
+	REGISTRY_USERNAME_ENV = "PACT_KAFKA_SCHEMA_REGISTRY_USERNAME"
+	// REGISTRY_PASSWORD_ENV overrides the schema registry basic auth password of the plugin manifest
+	REGISTRY_PASSWORD_ENV = "PACT_KAFKA_SCHEMA_REGISTRY_PASSWORD"
+	// REGISTRY_DIR_ENV overrides the local registry directory of the plugin manifest
+	REGISTRY_DIR_ENV = "PACT_KAFKA_SCHEMA_REGISTRY_DIR"
+	// REGISTRY_CONTENT_TYPE is the media type of the Confluent Schema Registry REST API
+	REGISTRY_CONTENT_TYPE = "application/vnd.schemaregistry.v1+json"
+	// REGISTRY_TIMEOUT bounds each request to the schema registry, so that a registry that does not
+	// respond fails the plugin request instead of blocking it
+	REGISTRY_TIMEOUT = 30 * time.Second
+)
+
+// RegistrySchema is a schema as returned by the Confluent Schema Registry REST API
+type RegistrySchema struct {
+	Subject    string `json:"subject,omitempty"`
+	ID         uint32 `json:"id"`
+	Version    int    `json:"version,omitempty"`
+	SchemaType string `json:"schemaType,omitempty"`
+	Schema     string `json:"schema"`
+}
+
+// Type returns the schema type, which the registry omits for Avro schemas
+func (s *RegistrySchema) Type() string {
+	if s.SchemaType == "" {
+		return "AVRO"
+	}
+	return s.SchemaType
+}
+
+// RegistryConfig configures the schema registry client. Without a URL, a local registry serving
+// the schemas of Directory is started by the plugin, see LoadLocalRegistry.
+type RegistryConfig struct {
+	URL       string `json:"url"`
+	Username  string `json:"username,omitempty"`
+	Password  string `json:"password,omitempty"`
+	Directory string `json:"directory,omitempty"`
+}
+
+// LoadRegistryConfig loads the schema registry configuration from the schemaRegistry entry of the
+// pluginConfig in the plugin manifest. A relative directory of the manifest is resolved against the
+// directory of the manifest. The environment variables take precedence over the manifest. A missing
+// manifest is not an error.
+func LoadRegistryConfig(manifestPath string) (RegistryConfig, error) {
+	var config RegistryConfig
+
+	data, err := os.ReadFile(manifestPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return config, fmt.Errorf("failed to read plugin manifest: %w", err)
+	}
+	if err == nil {
+		var manifest struct {
+			PluginConfig struct {
+				SchemaRegistry *RegistryConfig `json:"schemaRegistry"`
+			} `json:"pluginConfig"`
+		}
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return config, fmt.Errorf("failed to parse plugin manifest: %w", err)
+		}
+		if manifest.PluginConfig.SchemaRegistry != nil {
+			config = *manifest.PluginConfig.SchemaRegistry
+		}
+		if config.Directory != "" && !filepath.IsAbs(config.Directory) {
+			config.Directory = filepath.Join(filepath.Dir(manifestPath), config.Directory)
+		}
+	}
+
+	if v, ok := os.LookupEnv(REGISTRY_URL_ENV); ok {
+		config.URL = v
+	}
+	if v, ok := os.LookupEnv(REGISTRY_USERNAME_ENV); ok {
+		config.Username = v
+	}
+	if v, ok := os.LookupEnv(REGISTRY_PASSWORD_ENV); ok {
+		config.Password = v
+	}
+	if v, ok := os.LookupEnv(REGISTRY_DIR_ENV); ok {
+		config.Directory = v
+	}
+	return config, nil
+}
+
+// RegistryError is an error response of the schema registry
+type RegistryError struct {
+	StatusCode int    `json:"-"`
+	ErrorCode  int    `json:"error_code"`
+	Message    string `json:"message"`
+}
+
+func (e *RegistryError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("schema registry returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("schema registry returned %d: %s (error code %d)", e.StatusCode, e.Message, e.ErrorCode)
+}
+
+// RegistryClient is a client of the Confluent Schema Registry REST API. Schemas are cached in
+// memory: by ID, and by subject and version number. The latest version of a subject is always
+// looked up.
+type RegistryClient struct {
+	config     RegistryConfig
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	ids      map[uint32]*RegistrySchema
+	versions map[string]*RegistrySchema
+}
+
+// NewRegistryClient creates a client of the schema registry at config.URL
+func NewRegistryClient(config RegistryConfig) *RegistryClient {
+	config.URL = strings.TrimSuffix(config.URL, "/")
+	return &RegistryClient{
+		config:     config,
+		httpClient: &http.Client{Timeout: REGISTRY_TIMEOUT},
+		ids:        make(map[uint32]*RegistrySchema),
+		versions:   make(map[string]*RegistrySchema),
+	}
+}
+
+// SchemaByID fetches a schema by its ID with GET /schemas/ids/{id}
+func (c *RegistryClient) SchemaByID(ctx context.Context, id uint32) (*RegistrySchema, error) {
+	c.mu.Lock()
+	cached, ok := c.ids[id]
+	c.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	var schema RegistrySchema
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/schemas/ids/%d", id), nil, &schema); err != nil {
+		return nil, fmt.Errorf("failed to fetch schema %d: %w", id, err)
+	}
+	schema.ID = id
+
+	c.mu.Lock()
+	c.ids[id] = &schema
+	c.mu.Unlock()
+	return &schema, nil
+}
+
+// SubjectVersion fetches a version of a subject with GET /subjects/{subject}/versions/{version}.
+// The version may be a version number or "latest".
+func (c *RegistryClient) SubjectVersion(ctx context.Context, subject, version string) (*RegistrySchema, error) {
+	key := subject + "/" + version
+	c.mu.Lock()
+	cached, ok := c.versions[key]
+	c.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	var schema RegistrySchema
+	path := fmt.Sprintf("/subjects/%s/versions/%s", url.PathEscape(subject), url.PathEscape(version))
+	if err := c.do(ctx, http.MethodGet, path, nil, &schema); err != nil {
+		return nil, fmt.Errorf("failed to fetch subject %s version %s: %w", subject, version, err)
+	}
+
+	c.mu.Lock()
+	if _, err := strconv.Atoi(version); err == nil {
+		c.versions[key] = &schema
+	}
+	c.versions[fmt.Sprintf("%s/%d", subject, schema.Version)] = &schema
+	c.ids[schema.ID] = &schema
+	c.mu.Unlock()
+	return &schema, nil
+}
+
+// SubjectVersions lists the version numbers of a subject with GET /subjects/{subject}/versions
+func (c *RegistryClient) SubjectVersions(ctx context.Context, subject string) ([]int, error) {
+	var versions []int
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/subjects/%s/versions", url.PathEscape(subject)), nil, &versions); err != nil {
+		return nil, fmt.Errorf("failed to list the versions of subject %s: %w", subject, err)
+	}
+	return versions, nil
+}
+
+// CompatibilityResult is the result of a compatibility check
+type CompatibilityResult struct {
+	IsCompatible bool     `json:"is_compatible"`
+	Messages     []string `json:"messages,omitempty"`
+}
+
+// CheckCompatibility checks if a schema is compatible with a version of a subject, with
+// POST /compatibility/subjects/{subject}/versions/{version}, using the compatibility level
+// configured in the registry for the subject
+func (c *RegistryClient) CheckCompatibility(ctx context.Context, subject, version string, schema *RegistrySchema) (*CompatibilityResult, error) {
+	body := RegistrySchema{Schema: schema.Schema}
+	if schema.Type() != "AVRO" {
+		body.SchemaType = schema.Type()
+	}
+	var result CompatibilityResult
+	path := fmt.Sprintf("/compatibility/subjects/%s/versions/%s?verbose=true", url.PathEscape(subject), url.PathEscape(version))
+	if err := c.do(ctx, http.MethodPost, path, body, &result); err != nil {
+		return nil, fmt.Errorf("failed to check compatibility with subject %s version %s: %w", subject, version, err)
+	}
+	return &result, nil
+}
+
+func (c *RegistryClient) do(ctx context.Context, method, path string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.config.URL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", REGISTRY_CONTENT_TYPE)
+	if body != nil {
+		req.Header.Set("Content-Type", REGISTRY_CONTENT_TYPE)
+	}
+	if c.config.Username != "" {
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	// nolint:errcheck
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		registryErr := &RegistryError{StatusCode: resp.StatusCode}
+		// the body is only informative, the status code is the error
+		_ = json.NewDecoder(resp.Body).Decode(registryErr)
+		return registryErr
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode schema registry response: %w", err)
+	}
+	return nil
+}
+
+// registryClients holds the registry configuration loaded at startup, a client, and so a cache, per
+// schema registry URL, and the URLs of the local registries started by the plugin by directory
+type registryClients struct {
+	mu      sync.Mutex
+	config  RegistryConfig
+	clients map[string]*RegistryClient
+	local   map[string]string
+}
+
+// schemaRegistries are the schema registry clients shared by all requests to the plugin
+var schemaRegistries = &registryClients{clients: make(map[string]*RegistryClient), local: make(map[string]string)}
+
+// configure sets the registry configuration of the plugin manifest and environment, loaded once when
+// the plugin starts, see LoadRegistryConfig
+func (r *registryClients) configure(config RegistryConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.config = config
+}
+
+// client returns the client of the registry at registryURL. If registryURL is empty, the configured
+// registry is used, and nil is returned if there is none.
+func (r *registryClients) client(registryURL string) (*RegistryClient, error) {
+	r.mu.Lock()
+	config := r.config
+	r.mu.Unlock()
+	if config.URL == "" && config.Directory != "" {
+		var err error
+		if config.URL, err = r.startLocal(config.Directory); err != nil {
+			return nil, err
+		}
+	}
+	if registryURL == "" {
+		if config.URL == "" {
+			return nil, nil
+		}
+		registryURL = config.URL
+	}
+	// the credentials only apply to the configured registry
+	if strings.TrimSuffix(registryURL, "/") != strings.TrimSuffix(config.URL, "/") {
+		config = RegistryConfig{URL: registryURL}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := strings.TrimSuffix(registryURL, "/") + "|" + config.Username
+	client, ok := r.clients[key]
+	if !ok {
+		client = NewRegistryClient(config)
+		r.clients[key] = client
+	}
+	return client, nil
+}
+
+// startLocal starts a local registry serving the schemas of a directory, unless it is already running
+func (r *registryClients) startLocal(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if registryURL, ok := r.local[abs]; ok {
+		return registryURL, nil
+	}
+	registryURL, err := StartLocalRegistry(abs, "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to start the local schema registry: %w", err)
+	}
+	r.local[abs] = registryURL
+	return registryURL, nil
+}
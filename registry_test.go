@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// newTestRegistry starts a schema registry stand-in serving the users-value subject, counting
+// the requests it receives by path
+func newTestRegistry(t *testing.T) (*httptest.Server, map[string]int) {
+	requests := make(map[string]int)
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		if user, password, ok := r.BasicAuth(); ok && (user != "user" || password != "secret") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", REGISTRY_CONTENT_TYPE)
+		switch r.URL.Path {
+		case "/schemas/ids/16":
+			// nolint:errcheck
+			json.NewEncoder(w).Encode(map[string]any{"schema": testUserSchema})
+		case "/subjects/users-value/versions/1", "/subjects/users-value/versions/latest":
+			// nolint:errcheck
+			json.NewEncoder(w).Encode(RegistrySchema{Subject: "users-value", ID: 16, Version: 1, Schema: testUserSchema})
+		case "/compatibility/subjects/users-value/versions/latest":
+			var body RegistrySchema
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || r.URL.Query().Get("verbose") != "true" {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+			result := CompatibilityResult{IsCompatible: body.Schema == testUserSchema}
+			if !result.IsCompatible {
+				result.Messages = []string{"reader schema is missing field id"}
+			}
+			// nolint:errcheck
+			json.NewEncoder(w).Encode(result)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			// nolint:errcheck
+			json.NewEncoder(w).Encode(RegistryError{ErrorCode: 40403, Message: "Schema not found"})
+		}
+	}))
+	t.Cleanup(registry.Close)
+	return registry, requests
+}
+
+// TestRegistryClient tests fetching and caching schemas
+func TestRegistryClient(t *testing.T) {
+	registry, requests := newTestRegistry(t)
+	client := NewRegistryClient(RegistryConfig{URL: registry.URL + "/", Username: "user", Password: "secret"})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		schema, err := client.SchemaByID(ctx, 16)
+		if err != nil {
+			t.Fatalf("SchemaByID() unexpected error: %v", err)
+		}
+		if schema.ID != 16 || schema.Type() != "AVRO" || schema.Schema != testUserSchema {
+			t.Errorf("SchemaByID() = %+v", schema)
+		}
+		if _, err := client.SubjectVersion(ctx, "users-value", "latest"); err != nil {
+			t.Fatalf("SubjectVersion(latest) unexpected error: %v", err)
+		}
+		if _, err := client.SubjectVersion(ctx, "users-value", "1"); err != nil {
+			t.Fatalf("SubjectVersion(1) unexpected error: %v", err)
+		}
+	}
+
+	want := map[string]int{
+		"/schemas/ids/16":                       1,
+		"/subjects/users-value/versions/latest": 2,
+	}
+	if diff := cmp.Diff(want, requests); diff != "" {
+		t.Errorf("registry requests mismatch (-want +got):\n%s", diff)
+	}
+
+	_, err := client.SchemaByID(ctx, 17)
+	var registryErr *RegistryError
+	if !errors.As(err, &registryErr) || registryErr.StatusCode != http.StatusNotFound || registryErr.ErrorCode != 40403 {
+		t.Errorf("SchemaByID(17) error = %v, want a 404 registry error", err)
+	}
+
+	unauthorized := NewRegistryClient(RegistryConfig{URL: registry.URL, Username: "user", Password: "wrong"})
+	if _, err := unauthorized.SchemaByID(ctx, 16); err == nil {
+		t.Error("SchemaByID() expected error with the wrong credentials")
+	}
+}
+
+// TestCheckCompatibility tests checking schemas against the compatibility of a subject
+func TestCheckCompatibility(t *testing.T) {
+	registry, _ := newTestRegistry(t)
+	client := NewRegistryClient(RegistryConfig{URL: registry.URL})
+
+	tests := []struct {
+		name   string
+		schema string
+		want   *CompatibilityResult
+	}{
+		{name: "compatible", schema: testUserSchema, want: &CompatibilityResult{IsCompatible: true}},
+		{
+			name:   "incompatible",
+			schema: `{"type": "record", "name": "User", "fields": []}`,
+			want:   &CompatibilityResult{Messages: []string{"reader schema is missing field id"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.CheckCompatibility(context.Background(), "users-value", "latest", &RegistrySchema{Schema: tt.schema})
+			if err != nil {
+				t.Fatalf("CheckCompatibility() unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("CheckCompatibility() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestLoadRegistryConfig tests loading the registry configuration from the plugin manifest and environment
+func TestLoadRegistryConfig(t *testing.T) {
+	manifest := filepath.Join(t.TempDir(), PLUGIN_MANIFEST)
+	data := `{"name": "kafka", "pluginConfig": {"schemaRegistry": {"url": "http://registry:8081", "username": "user", "password": "secret", "directory": "schemas"}}}`
+	if err := os.WriteFile(manifest, []byte(data), 0644); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+
+	config, err := LoadRegistryConfig(manifest)
+	if err != nil {
+		t.Fatalf("LoadRegistryConfig() unexpected error: %v", err)
+	}
+	schemas := filepath.Join(filepath.Dir(manifest), "schemas")
+	if diff := cmp.Diff(RegistryConfig{URL: "http://registry:8081", Username: "user", Password: "secret", Directory: schemas}, config); diff != "" {
+		t.Errorf("LoadRegistryConfig() mismatch (-want +got):\n%s", diff)
+	}
+
+	t.Setenv(REGISTRY_URL_ENV, "http://localhost:8081")
+	t.Setenv(REGISTRY_PASSWORD_ENV, "")
+	config, err = LoadRegistryConfig(manifest)
+	if err != nil {
+		t.Fatalf("LoadRegistryConfig() unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(RegistryConfig{URL: "http://localhost:8081", Username: "user", Directory: schemas}, config); diff != "" {
+		t.Errorf("LoadRegistryConfig() with environment mismatch (-want +got):\n%s", diff)
+	}
+
+	config, err = LoadRegistryConfig(filepath.Join(t.TempDir(), PLUGIN_MANIFEST))
+	if err != nil {
+		t.Fatalf("LoadRegistryConfig() unexpected error for a missing manifest: %v", err)
+	}
+	if config.URL != "http://localhost:8081" {
+		t.Errorf("LoadRegistryConfig() URL = %q, want the environment URL", config.URL)
+	}
+}
+
+// useRegistryConfig configures the schema registries shared by the plugin for the duration of a test
+func useRegistryConfig(t *testing.T, config RegistryConfig) {
+	t.Helper()
+	schemaRegistries.configure(config)
+	t.Cleanup(func() { schemaRegistries.configure(RegistryConfig{}) })
+}
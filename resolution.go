@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/hamba/avro/v2"
+)
+
+// ResolutionError is a failure to resolve a value written with a writer schema to a reader schema,
+// at a Pact path of the value
+type ResolutionError struct {
+	Path    string
+	Message string
+}
+
+func (e *ResolutionError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ResolveAvro resolves a decoded value written with the writer schema to the reader schema,
+// following the schema resolution rules of the Avro specification: record fields are matched by
+// name or reader alias, fields missing from the writer take their reader default, fields missing
+// from the reader are dropped, numbers and strings are promoted, enum symbols unknown to the
+// reader take the reader default and union branches are resolved to the first matching reader branch.
+func ResolveAvro(writer, reader avro.Schema, value any) (any, error) {
+	return avroResolver{}.resolve("$", writer, reader, value)
+}
+
+// avroResolver resolves values written with a writer schema to a reader schema, see ResolveAvro
+type avroResolver struct {
+	// keepUnknownFields keeps the fields of a record unknown to the reader as they were written,
+	// instead of dropping them, so that they can be reported when unexpected keys are not allowed
+	keepUnknownFields bool
+}
+
+func (r avroResolver) resolve(path string, writer, reader avro.Schema, value any) (any, error) {
+	writer = derefAvroSchema(writer)
+	reader = derefAvroSchema(reader)
+	fail := func(format string, args ...any) error {
+		return &ResolutionError{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+
+	// the branch written is resolved, so a writer union resolves like the schema of its branch
+	if union, ok := writer.(*avro.UnionSchema); ok {
+		branch, branchValue, ok := unionBranch(union, value)
+		if !ok {
+			return nil, fail("%s is not a value of the writer union %s", renderValue(value), union.String())
+		}
+		return r.resolve(path, branch, reader, branchValue)
+	}
+	if union, ok := reader.(*avro.UnionSchema); ok {
+		for _, branch := range union.Types() {
+			if !avroSchemasMatch(writer, derefAvroSchema(branch)) {
+				continue
+			}
+			if branch.Type() == avro.Null {
+				return nil, nil
+			}
+			resolved, err := r.resolve(path, writer, branch, value)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{AvroTypeName(branch): resolved}, nil
+		}
+		return nil, fail("the writer type %s is not a branch of the reader union %s", AvroTypeName(writer), union.String())
+	}
+	if !avroSchemasMatch(writer, reader) {
+		return nil, fail("the writer type %s can not be read as %s", AvroTypeName(writer), AvroTypeName(reader))
+	}
+
+	switch rs := reader.(type) {
+	case *avro.PrimitiveSchema:
+		return promoteAvroValue(writer.Type(), rs.Type(), value), nil
+	case *avro.RecordSchema:
+		w := writer.(*avro.RecordSchema)
+		written, _ := value.(map[string]any)
+		record := make(map[string]any, len(rs.Fields()))
+		for _, field := range rs.Fields() {
+			fieldPath := PathField(path, field.Name())
+			writerField := findWriterField(w, field)
+			if writerField == nil {
+				if !field.HasDefault() {
+					return nil, &ResolutionError{Path: fieldPath, Message: fmt.Sprintf("the writer schema has no field %s, and the reader field has no default", field.Name())}
+				}
+				def, err := avroDefault(field.Type(), field.Default(), fieldPath)
+				if err != nil {
+					return nil, err
+				}
+				record[field.Name()] = def
+				continue
+			}
+			resolved, err := r.resolve(fieldPath, writerField.Type(), field.Type(), written[writerField.Name()])
+			if err != nil {
+				return nil, err
+			}
+			record[field.Name()] = resolved
+		}
+		if r.keepUnknownFields {
+			for _, field := range w.Fields() {
+				_, exists := record[field.Name()]
+				if !exists && !slices.ContainsFunc(rs.Fields(), func(f *avro.Field) bool { return findWriterField(w, f) == field }) {
+					record[field.Name()] = written[field.Name()]
+				}
+			}
+		}
+		return record, nil
+	case *avro.EnumSchema:
+		symbol, _ := value.(string)
+		if slices.Contains(rs.Symbols(), symbol) {
+			return symbol, nil
+		}
+		if rs.Default() != "" {
+			return rs.Default(), nil
+		}
+		return nil, fail("the symbol %s is not defined in the reader enum %s, which has no default", symbol, rs.FullName())
+	case *avro.ArraySchema:
+		items, _ := value.([]any)
+		result := make([]any, len(items))
+		for i, item := range items {
+			resolved, err := r.resolve(PathIndex(path, i), writer.(*avro.ArraySchema).Items(), rs.Items(), item)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = resolved
+		}
+		return result, nil
+	case *avro.MapSchema:
+		values, _ := value.(map[string]any)
+		result := make(map[string]any, len(values))
+		for key, item := range values {
+			resolved, err := r.resolve(PathField(path, key), writer.(*avro.MapSchema).Values(), rs.Values(), item)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = resolved
+		}
+		return result, nil
+	}
+	// null and fixed values need no resolution
+	return value, nil
+}
+
+// avroSchemasMatch returns true if data written with the writer schema can be read with the reader
+// schema, without looking into records, arrays or maps
+func avroSchemasMatch(writer, reader avro.Schema) bool {
+	if _, ok := reader.(*avro.UnionSchema); ok {
+		return true
+	}
+	switch r := reader.(type) {
+	case *avro.NullSchema:
+		return writer.Type() == avro.Null
+	case *avro.PrimitiveSchema:
+		_, ok := writer.(*avro.PrimitiveSchema)
+		return ok && (writer.Type() == r.Type() || isAvroPromotion(writer.Type(), r.Type()))
+	case *avro.RecordSchema:
+		w, ok := writer.(*avro.RecordSchema)
+		return ok && avroNamesMatch(w, r)
+	case *avro.EnumSchema:
+		w, ok := writer.(*avro.EnumSchema)
+		return ok && avroNamesMatch(w, r)
+	case *avro.FixedSchema:
+		w, ok := writer.(*avro.FixedSchema)
+		return ok && avroNamesMatch(w, r) && w.Size() == r.Size()
+	case *avro.ArraySchema:
+		_, ok := writer.(*avro.ArraySchema)
+		return ok
+	case *avro.MapSchema:
+		_, ok := writer.(*avro.MapSchema)
+		return ok
+	}
+	return false
+}
+
+// avroNamesMatch returns true if the reader named type has the name of the writer, either its
+// unqualified name or one of the aliases of the reader
+func avroNamesMatch(writer, reader avro.NamedSchema) bool {
+	if writer.Name() == reader.Name() {
+		return true
+	}
+	aliased, ok := reader.(interface{ Aliases() []string })
+	return ok && (slices.Contains(aliased.Aliases(), writer.FullName()) || slices.Contains(aliased.Aliases(), writer.Name()))
+}
+
+// findWriterField finds the writer field of a reader field, by its name or one of its aliases
+func findWriterField(writer *avro.RecordSchema, field *avro.Field) *avro.Field {
+	for _, f := range writer.Fields() {
+		if f.Name() == field.Name() || slices.Contains(field.Aliases(), f.Name()) {
+			return f
+		}
+	}
+	return nil
+}
+
+// isAvroPromotion returns true if values of the writer type may be promoted to the reader type
+func isAvroPromotion(writer, reader avro.Type) bool {
+	switch writer {
+	case avro.Int:
+		return reader == avro.Long || reader == avro.Float || reader == avro.Double
+	case avro.Long:
+		return reader == avro.Float || reader == avro.Double
+	case avro.Float:
+		return reader == avro.Double
+	case avro.String:
+		return reader == avro.Bytes
+	case avro.Bytes:
+		return reader == avro.String
+	}
+	return false
+}
+
+// promoteAvroValue converts a generic value of the writer type to the reader type
+func promoteAvroValue(writer, reader avro.Type, value any) any {
+	switch {
+	case writer == reader:
+		return value
+	case reader == avro.Float || reader == avro.Double:
+		if i, ok := value.(int64); ok {
+			return float64(i)
+		}
+	case reader == avro.Bytes:
+		if s, ok := value.(string); ok {
+			return []byte(s)
+		}
+	case reader == avro.String:
+		if b, ok := value.([]byte); ok {
+			return string(b)
+		}
+	}
+	return value
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestResolveAvro tests resolving values written with a writer schema to a reader schema
+func TestResolveAvro(t *testing.T) {
+	tests := []struct {
+		name     string
+		writer   string
+		reader   string
+		value    any
+		want     any
+		wantPath string
+	}{
+		{
+			name:   "added field takes the reader default",
+			writer: `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}]}`,
+			reader: `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}, {"name": "age", "type": "int", "default": 18}]}`,
+			value:  map[string]any{"id": "1"},
+			want:   map[string]any{"id": "1", "age": int64(18)},
+		},
+		{
+			name:   "removed field is dropped",
+			writer: `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}, {"name": "age", "type": "int"}]}`,
+			reader: `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}]}`,
+			value:  map[string]any{"id": "1", "age": int64(30)},
+			want:   map[string]any{"id": "1"},
+		},
+		{
+			name:   "numbers and strings are promoted",
+			writer: `{"type": "record", "name": "User", "fields": [{"name": "age", "type": "int"}, {"name": "score", "type": "long"}, {"name": "avatar", "type": "string"}]}`,
+			reader: `{"type": "record", "name": "User", "fields": [{"name": "age", "type": "long"}, {"name": "score", "type": "double"}, {"name": "avatar", "type": "bytes"}]}`,
+			value:  map[string]any{"age": int64(30), "score": int64(7), "avatar": "ab"},
+			want:   map[string]any{"age": int64(30), "score": float64(7), "avatar": []byte("ab")},
+		},
+		{
+			name:   "renamed record and field are matched by alias",
+			writer: `{"type": "record", "name": "Customer", "fields": [{"name": "mail", "type": "string"}]}`,
+			reader: `{"type": "record", "name": "User", "aliases": ["Customer"], "fields": [{"name": "email", "type": "string", "aliases": ["mail"]}]}`,
+			value:  map[string]any{"mail": "jane@example.com"},
+			want:   map[string]any{"email": "jane@example.com"},
+		},
+		{
+			name:   "unknown enum symbol takes the reader default",
+			writer: `{"type": "enum", "name": "Status", "symbols": ["ACTIVE", "SUSPENDED"]}`,
+			reader: `{"type": "enum", "name": "Status", "symbols": ["ACTIVE", "UNKNOWN"], "default": "UNKNOWN"}`,
+			value:  "SUSPENDED",
+			want:   "UNKNOWN",
+		},
+		{
+			name:   "union branch is resolved to the reader union",
+			writer: `{"type": "record", "name": "User", "fields": [{"name": "email", "type": ["null", "string"]}, {"name": "age", "type": ["null", "int"]}]}`,
+			reader: `{"type": "record", "name": "User", "fields": [{"name": "email", "type": "string"}, {"name": "age", "type": ["null", "long"]}]}`,
+			value:  map[string]any{"email": map[string]any{"string": "jane@example.com"}, "age": nil},
+			want:   map[string]any{"email": "jane@example.com", "age": nil},
+		},
+		{
+			name:   "arrays and maps are resolved item by item",
+			writer: `{"type": "record", "name": "User", "fields": [{"name": "scores", "type": {"type": "array", "items": "int"}}, {"name": "attributes", "type": {"type": "map", "values": "float"}}]}`,
+			reader: `{"type": "record", "name": "User", "fields": [{"name": "scores", "type": {"type": "array", "items": "long"}}, {"name": "attributes", "type": {"type": "map", "values": "double"}}]}`,
+			value:  map[string]any{"scores": []any{int64(1)}, "attributes": map[string]any{"height": float64(1.5)}},
+			want:   map[string]any{"scores": []any{int64(1)}, "attributes": map[string]any{"height": float64(1.5)}},
+		},
+		{
+			name:     "added field without a default",
+			writer:   `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}]}`,
+			reader:   `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}, {"name": "age", "type": "int"}]}`,
+			value:    map[string]any{"id": "1"},
+			wantPath: "$.age",
+		},
+		{
+			name:     "changed type",
+			writer:   `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "long"}]}`,
+			reader:   `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}]}`,
+			value:    map[string]any{"id": int64(1)},
+			wantPath: "$.id",
+		},
+		{
+			name:     "null written for a reader without a null branch",
+			writer:   `{"type": "record", "name": "User", "fields": [{"name": "email", "type": ["null", "string"]}]}`,
+			reader:   `{"type": "record", "name": "User", "fields": [{"name": "email", "type": "string"}]}`,
+			value:    map[string]any{"email": nil},
+			wantPath: "$.email",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer, err := ParseAvroSchema(tt.writer)
+			if err != nil {
+				t.Fatalf("failed to parse writer schema: %v", err)
+			}
+			reader, err := ParseAvroSchema(tt.reader)
+			if err != nil {
+				t.Fatalf("failed to parse reader schema: %v", err)
+			}
+			got, err := ResolveAvro(writer, reader, tt.value)
+			if tt.wantPath != "" {
+				resolutionErr, ok := err.(*ResolutionError)
+				if !ok {
+					t.Fatalf("ResolveAvro() error = %v, want a resolution error", err)
+				}
+				if resolutionErr.Path != tt.wantPath {
+					t.Errorf("ResolveAvro() error path = %s, want %s", resolutionErr.Path, tt.wantPath)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveAvro() unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ResolveAvro() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
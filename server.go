@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
@@ -73,9 +73,21 @@ func (s *pactPluginServer) InitPlugin(ctx context.Context, req *pb.InitPluginReq
 				Type: pb.CatalogueEntry_CONTENT_MATCHER,
 				Key:  PLUGIN_NAME,
 				Values: map[string]string{
-					"content-types": AVRO_SCHEMA_CONTENT_TYPE,
+					"content-types": CONTENT_TYPES,
 				},
 			},
+			{
+				Type: pb.CatalogueEntry_CONTENT_GENERATOR,
+				Key:  PLUGIN_NAME,
+				Values: map[string]string{
+					"content-types": CONTENT_TYPES,
+				},
+			},
+			// StartMockServer serves the messages of a pact from an in-memory Kafka broker
+			{
+				Type: pb.CatalogueEntry_TRANSPORT,
+				Key:  PLUGIN_NAME,
+			},
 		},
 	}, nil
 }
@@ -88,43 +100,276 @@ func (s *pactPluginServer) UpdateCatalogue(ctx context.Context, req *pb.Catalogu
 func (s *pactPluginServer) ConfigureInteraction(ctx context.Context, req *pb.ConfigureInteractionRequest) (*pb.ConfigureInteractionResponse, error) {
 	slog.Info("Received ConfigureInteraction request")
 
-	// parse the required fields. The fields should include the schemaID and the message in []byte
-	schemaID, ok := req.ContentsConfig.Fields["schemaId"]
-	if !ok {
-		return nil, fmt.Errorf("schemaId field is required")
+	fields := req.ContentsConfig.GetFields()
+	pactFields := make(map[string]*structpb.Value)
+
+	// a tombstone has no message, only a key and headers
+	tombstone, err := isTombstone(fields)
+	if err != nil {
+		return nil, err
+	}
+	var interaction *pb.InteractionResponse
+	if tombstone {
+		interaction, err = configureTombstone(req.ContentType, fields)
+	} else {
+		interaction, err = configurePart(ctx, req.ContentType, "message", fields, pactFields)
+	}
+	if err != nil {
+		return nil, err
+	}
+	interactions := []*pb.InteractionResponse{interaction}
+
+	// the headers, topic, partition and timestamp of the record are the message metadata
+	metadata, err := parseRecordMetadata(fields)
+	if err != nil {
+		return nil, err
+	}
+	if err := setMessageMetadata(interaction, metadata); err != nil {
+		return nil, err
+	}
+
+	// the record key is a separate part, with its own schema and serializer
+	if keyValue, ok := fields["key"]; ok {
+		keyFields := keyValue.GetStructValue().GetFields()
+		if keyFields == nil {
+			return nil, fmt.Errorf("key field must be an object")
+		}
+		key, err := configureKey(ctx, req.ContentType, fields, keyFields, pactFields)
+		if err != nil {
+			return nil, fmt.Errorf("key: %w", err)
+		}
+		// the key part is returned for the pact framework, and persisted with the message so that
+		// it can be verified and served by the mock server
+		if err := persistKey(interaction, key); err != nil {
+			return nil, err
+		}
+		interactions = append(interactions, key)
+	}
+
+	// the snapshot directory is shared by the pact, to resolve schemas not persisted with an interaction
+	if dir, ok := fields["schemaDirectory"]; ok {
+		if _, err := absolutePath("schemaDirectory", dir); err != nil {
+			return nil, err
+		}
+		pactFields["schemaDirectory"] = dir
+	}
+	var pactConfiguration *pb.PluginConfiguration
+	if len(pactFields) > 0 {
+		pactConfiguration = &pb.PluginConfiguration{PactConfiguration: &structpb.Struct{Fields: pactFields}}
+	}
+
+	return &pb.ConfigureInteractionResponse{
+		Interaction:         interactions,
+		PluginConfiguration: pactConfiguration,
+	}, nil
+}
+
+func (s *pactPluginServer) CompareContents(ctx context.Context, req *pb.CompareContentsRequest) (*pb.CompareContentsResponse, error) {
+	slog.Info("Received CompareContents request")
+
+	if req.Expected == nil || req.Actual == nil {
+		return &pb.CompareContentsResponse{Error: "both expected and actual contents are required"}, nil
+	}
+	if req.Expected.ContentType != req.Actual.ContentType {
+		return &pb.CompareContentsResponse{
+			TypeMismatch: &pb.ContentTypeMismatch{
+				Expected: req.Expected.ContentType,
+				Actual:   req.Actual.ContentType,
+			},
+		}, nil
+	}
+
+	// a tombstone is verified by the provider sending a null value, and a message by it not doing so
+	results := make(mismatches)
+	actualContent := req.Actual.Content.GetValue()
+	if req.GetPluginConfiguration().GetInteractionConfiguration().GetFields()["tombstone"].GetBoolValue() {
+		if len(actualContent) > 0 {
+			results.add("$", nil, actualContent, "Expected a tombstone (null value) but received a message of %d bytes", len(actualContent))
+		}
+		return &pb.CompareContentsResponse{Results: results.results()}, nil
+	}
+	if len(actualContent) == 0 {
+		results.add("$", nil, nil, "Expected a message but received a tombstone (null value)")
+		return &pb.CompareContentsResponse{Results: results.results()}, nil
+	}
+
+	// the writer schema is persisted in the interaction configuration by ConfigureInteraction, or
+	// found in the schema snapshot directory of the pact
+	format, err := loadInteractionFormat(req.Expected.ContentType, req.GetPluginConfiguration(), req.Expected.Content.GetValue())
+	if err != nil {
+		return &pb.CompareContentsResponse{Error: err.Error()}, nil
+	}
+
+	expected, err := decodeContents(ctx, format, req.GetPluginConfiguration(), req.Expected.Content.GetValue())
+	if err != nil {
+		return &pb.CompareContentsResponse{Error: fmt.Sprintf("failed to decode expected contents: %v", err)}, nil
 	}
-	_, ok = schemaID.Kind.(*structpb.Value_NumberValue)
-	if !ok {
-		return nil, fmt.Errorf("schemaId field must be a number")
+
+	// a message that can not be resolved to the reader schema of the consumer is a mismatch at the
+	// path that failed to resolve
+	actual, err := decodeContents(ctx, format, req.GetPluginConfiguration(), actualContent)
+	if resolutionErr := (*ResolutionError)(nil); errors.As(err, &resolutionErr) {
+		results.add(resolutionErr.Path, nil, nil, "Failed to resolve the actual message with the reader schema: %s", resolutionErr.Message)
+		return &pb.CompareContentsResponse{Results: results.results()}, nil
+	} else if err != nil {
+		results.add("$", nil, nil, "Failed to decode actual contents: %v", err)
+		return &pb.CompareContentsResponse{Results: results.results()}, nil
 	}
-	slog.Info("schemaId", "value", int64(schemaID.GetNumberValue()))
 
-	// parse the []byte base64EncodedMessage
-	base64EncodedMessage, ok := req.ContentsConfig.Fields["message"]
-	if !ok {
-		return nil, fmt.Errorf("message field is required")
+	results = format.Compare(expected, actual, req.Rules, req.AllowUnexpectedKeys)
+
+	// the provider's current writer schema is checked against the consumer's schema
+	if avroFormat, ok := format.(*avroFormat); ok && req.GetPluginConfiguration().GetInteractionConfiguration().GetFields()["compatibility"] != nil {
+		for path, items := range checkSchemaCompatibility(ctx, avroFormat, req.GetPluginConfiguration()) {
+			results[path] = append(results[path], items...)
+		}
+	}
+	return &pb.CompareContentsResponse{Results: results.results()}, nil
+}
+
+func (s *pactPluginServer) GenerateContent(ctx context.Context, req *pb.GenerateContentRequest) (*pb.GenerateContentResponse, error) {
+	slog.Info("Received GenerateContent request", "testMode", req.TestMode.String())
+
+	if len(req.Generators) == 0 || len(req.GetContents().GetContent().GetValue()) == 0 {
+		return &pb.GenerateContentResponse{Contents: req.Contents}, nil
+	}
+
+	format, err := loadInteractionFormat(req.Contents.GetContentType(), req.GetPluginConfiguration(), req.GetContents().GetContent().GetValue())
+	if err != nil {
+		return nil, err
+	}
+
+	schemaID, payload, err := format.Unframe(req.GetContents().GetContent().GetValue())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode contents: %w", err)
 	}
-	_, ok = base64EncodedMessage.Kind.(*structpb.Value_StringValue)
-	if !ok {
-		return nil, fmt.Errorf("message field must be a string")
+	value, err := format.Decode(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	generated, err := format.ApplyGenerators(value, req.Generators, req.GetTestContext().AsMap())
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply generators: %w", err)
 	}
-	message, err := base64.StdEncoding.DecodeString(base64EncodedMessage.GetStringValue())
+	encoded, err := format.Encode(generated)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode base64 message: %w", err)
+		return nil, err
 	}
 
-	content := fmt.Sprintf("%X%04X%s", 0, int64(schemaID.GetNumberValue()), message)
-	var interactions = make([]*pb.InteractionResponse, 0)
-	interaction := &pb.InteractionResponse{
+	return &pb.GenerateContentResponse{
 		Contents: &pb.Body{
-			ContentType: AVRO_SCHEMA_CONTENT_TYPE,
-			Content:     wrapperspb.Bytes([]byte(content)),
+			ContentType:     req.Contents.ContentType,
+			Content:         wrapperspb.Bytes(format.Frame(schemaID, encoded)),
+			ContentTypeHint: req.Contents.ContentTypeHint,
 		},
-		PartName: "message",
+	}, nil
+}
+
+func (s *pactPluginServer) PrepareInteractionForVerification(ctx context.Context, req *pb.VerificationPreparationRequest) (*pb.VerificationPreparationResponse, error) {
+	slog.Info("Received PrepareInteractionForVerification request", "interactionKey", req.InteractionKey)
+
+	data, err := prepareVerification(req.Pact, req.InteractionKey)
+	if err != nil {
+		return &pb.VerificationPreparationResponse{Response: &pb.VerificationPreparationResponse_Error{Error: err.Error()}}, nil
 	}
-	interactions = append(interactions, interaction)
+	return &pb.VerificationPreparationResponse{Response: &pb.VerificationPreparationResponse_InteractionData{InteractionData: data}}, nil
+}
 
-	return &pb.ConfigureInteractionResponse{
-		Interaction: interactions,
-	}, nil
+func (s *pactPluginServer) VerifyInteraction(ctx context.Context, req *pb.VerifyInteractionRequest) (*pb.VerifyInteractionResponse, error) {
+	slog.Info("Received VerifyInteraction request", "interactionKey", req.InteractionKey)
+
+	verificationError := func(err error) (*pb.VerifyInteractionResponse, error) {
+		return &pb.VerifyInteractionResponse{Response: &pb.VerifyInteractionResponse_Error{Error: err.Error()}}, nil
+	}
+	pact, err := parsePact(req.Pact)
+	if err != nil {
+		return verificationError(err)
+	}
+	interaction, err := pact.interaction(req.InteractionKey)
+	if err != nil {
+		return verificationError(err)
+	}
+
+	// the provider's message is obtained from the source in the verification configuration
+	config := req.GetConfig().AsMap()
+	source, err := newMessageSource(config)
+	if err != nil {
+		return verificationError(err)
+	}
+	message, err := source.Message(ctx, interaction)
+	if err != nil {
+		return verificationError(fmt.Errorf("failed to get the provider message for %q: %w", interaction.Description, err))
+	}
+
+	// fields added by the provider are allowed unless the configuration says otherwise
+	allowUnexpectedKeys := true
+	if allow, ok := config["allowUnexpectedKeys"].(bool); ok {
+		allowUnexpectedKeys = allow
+	}
+	result, err := s.verifyMessage(ctx, pact, interaction, message, allowUnexpectedKeys)
+	if err != nil {
+		return verificationError(err)
+	}
+	return &pb.VerifyInteractionResponse{Response: &pb.VerifyInteractionResponse_Result{Result: result}}, nil
+}
+
+func (s *pactPluginServer) StartMockServer(ctx context.Context, req *pb.StartMockServerRequest) (*pb.StartMockServerResponse, error) {
+	slog.Info("Received StartMockServer request", "hostInterface", req.HostInterface, "port", req.Port)
+
+	mockServerError := func(err error) (*pb.StartMockServerResponse, error) {
+		return &pb.StartMockServerResponse{Response: &pb.StartMockServerResponse_Error{Error: err.Error()}}, nil
+	}
+	if req.Tls {
+		return mockServerError(errors.New("TLS is not supported by the Kafka mock server"))
+	}
+	pact, err := parsePact(req.Pact)
+	if err != nil {
+		return mockServerError(err)
+	}
+
+	config, err := parseMockServerConfig(req.TestContext)
+	if err != nil {
+		return mockServerError(err)
+	}
+
+	// the broker serves the pact's messages until the mock server is shut down
+	broker, err := newMockBroker(pact, config)
+	if err != nil {
+		return mockServerError(err)
+	}
+	if err := broker.start(req.HostInterface, req.Port); err != nil {
+		return mockServerError(err)
+	}
+	mockServers.add(broker)
+	slog.Info("Kafka mock server listening", "key", broker.key, "address", broker.host, "port", broker.port)
+
+	return &pb.StartMockServerResponse{Response: &pb.StartMockServerResponse_Details{Details: &pb.MockServerDetails{
+		Key:     broker.key,
+		Port:    uint32(broker.port),
+		Address: broker.host,
+	}}}, nil
+}
+
+func (s *pactPluginServer) ShutdownMockServer(ctx context.Context, req *pb.ShutdownMockServerRequest) (*pb.ShutdownMockServerResponse, error) {
+	slog.Info("Received ShutdownMockServer request", "serverKey", req.ServerKey)
+
+	broker, err := mockServers.remove(req.ServerKey)
+	if err != nil {
+		return nil, err
+	}
+	broker.shutdown()
+	ok, results := broker.results(ctx, s)
+	return &pb.ShutdownMockServerResponse{Ok: ok, Results: results}, nil
+}
+
+func (s *pactPluginServer) GetMockServerResults(ctx context.Context, req *pb.MockServerRequest) (*pb.MockServerResults, error) {
+	slog.Info("Received GetMockServerResults request", "serverKey", req.ServerKey)
+
+	broker, err := mockServers.get(req.ServerKey)
+	if err != nil {
+		return nil, err
+	}
+	ok, results := broker.results(ctx, s)
+	return &pb.MockServerResults{Ok: ok, Results: results}, nil
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SchemaSnapshot is a checked in directory of schemas, resolving schema IDs without a schema registry.
+// A schema is either a file named after its ID, such as schemas/16.avsc, or listed in a registry.json
+// index, see readRegistryManifest.
+type SchemaSnapshot struct {
+	dir     string
+	schemas map[uint32]*RegistrySchema
+}
+
+// LoadSchemaSnapshot loads the schemas of a snapshot directory
+func LoadSchemaSnapshot(dir string) (*SchemaSnapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema directory: %w", err)
+	}
+	snapshot := &SchemaSnapshot{dir: dir, schemas: make(map[uint32]*RegistrySchema)}
+
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		id, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), ext), 10, 32)
+		schemaType, ok := schemaFileTypes[strings.ToLower(ext)]
+		if entry.IsDir() || err != nil || !ok {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema file: %w", err)
+		}
+		if err := snapshot.add(&RegistrySchema{ID: uint32(id), SchemaType: schemaType, Schema: string(data)}); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, REGISTRY_MANIFEST)); err == nil {
+		manifest, err := readRegistryManifest(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, schema := range manifest.schemas {
+			if err := snapshot.add(schema); err != nil {
+				return nil, err
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (s *SchemaSnapshot) add(schema *RegistrySchema) error {
+	if schema.SchemaType == "AVRO" {
+		schema.SchemaType = ""
+	}
+	if existing, ok := s.schemas[schema.ID]; ok {
+		if existing.Schema != schema.Schema {
+			return fmt.Errorf("schema ID %d is assigned to different schemas in %s", schema.ID, s.dir)
+		}
+		// the registry.json index adds the subject and version of a schema file
+		if existing.Subject == "" {
+			s.schemas[schema.ID] = schema
+		}
+		return nil
+	}
+	s.schemas[schema.ID] = schema
+	return nil
+}
+
+// SchemaByID returns a schema by its ID
+func (s *SchemaSnapshot) SchemaByID(id uint32) (*RegistrySchema, error) {
+	schema, ok := s.schemas[id]
+	if !ok {
+		return nil, fmt.Errorf("schema %d not found in %s", id, s.dir)
+	}
+	return schema, nil
+}
+
+// SubjectVersions returns the schemas of a subject listed in the registry.json index, oldest first
+func (s *SchemaSnapshot) SubjectVersions(subject string) []*RegistrySchema {
+	var versions []*RegistrySchema
+	for _, schema := range s.schemas {
+		if schema.Subject == subject {
+			versions = append(versions, schema)
+		}
+	}
+	slices.SortFunc(versions, func(a, b *RegistrySchema) int { return a.Version - b.Version })
+	return versions
+}
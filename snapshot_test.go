@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLoadSchemaSnapshot tests resolving schema IDs from files named after them and the registry.json index
+func TestLoadSchemaSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"16.avsc":         testUserSchema,
+		"order.proto":     testOrderProto,
+		"notes.txt":       "not a schema",
+		REGISTRY_MANIFEST: `{"16": {"subject": "users-value", "version": 3, "file": "16.avsc"}, "20": {"subject": "orders-value", "file": "order.proto"}}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	snapshot, err := LoadSchemaSnapshot(dir)
+	if err != nil {
+		t.Fatalf("LoadSchemaSnapshot() unexpected error: %v", err)
+	}
+	user, err := snapshot.SchemaByID(16)
+	if err != nil {
+		t.Fatalf("SchemaByID(16) unexpected error: %v", err)
+	}
+	if user.Type() != "AVRO" || user.Subject != "users-value" || user.Version != 3 || user.Schema != testUserSchema {
+		t.Errorf("SchemaByID(16) = %+v", user)
+	}
+	order, err := snapshot.SchemaByID(20)
+	if err != nil {
+		t.Fatalf("SchemaByID(20) unexpected error: %v", err)
+	}
+	if order.Type() != "PROTOBUF" {
+		t.Errorf("SchemaByID(20) schema type = %s, want PROTOBUF", order.Type())
+	}
+	if _, err := snapshot.SchemaByID(17); err == nil {
+		t.Error("SchemaByID(17) expected error")
+	}
+
+	conflicting := map[string]string{
+		"16.avsc":         testUserSchema,
+		REGISTRY_MANIFEST: `{"16": {"subject": "users-value", "schema": "\"string\""}}`,
+	}
+	dir = t.TempDir()
+	for name, content := range conflicting {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	if _, err := LoadSchemaSnapshot(dir); err == nil {
+		t.Error("LoadSchemaSnapshot() expected error for an ID assigned to different schemas")
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	pb "github.com/rob0t7/pact-kafka-plugin/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// PRODUCER_METADATA_HEADER is the response header of a message producer holding the key and metadata
+// of a binary message, as base64 encoded JSON, like Pact message verification does
+const PRODUCER_METADATA_HEADER = "Pact-Message-Metadata"
+
+// PRODUCER_TIMEOUT is the default time a message producer has to respond, so that an endpoint that
+// hangs fails the verification of the interaction instead of blocking it
+const PRODUCER_TIMEOUT = 30 * time.Second
+
+// providerMessage is the record a provider publishes for an interaction. A nil value is a tombstone.
+// The metadata holds the headers, as strings or []byte, and the topic, partition and timestamp keyed
+// like the message metadata of the pact, see parseRecordMetadata.
+type providerMessage struct {
+	value    []byte
+	key      []byte
+	metadata map[string]any
+}
+
+// messageSource obtains the message a provider publishes for an interaction during verification
+type messageSource interface {
+	Message(ctx context.Context, interaction *pactInteraction) (*providerMessage, error)
+}
+
+// newMessageSource creates the message source of the verification configuration: the message producer
+// endpoint of the provider at producerUrl, called with a producerTimeout in milliseconds, or the
+// messages given in its messages field, keyed by interaction description or key
+func newMessageSource(config map[string]any) (messageSource, error) {
+	if producerURL, ok := config["producerUrl"]; ok {
+		url, ok := producerURL.(string)
+		if !ok || url == "" {
+			return nil, fmt.Errorf("producerUrl must be a URL")
+		}
+		headers := make(map[string]string)
+		producerHeaders, _ := config["producerHeaders"].(map[string]any)
+		for name, value := range producerHeaders {
+			headers[name] = fmt.Sprint(value)
+		}
+		timeout := PRODUCER_TIMEOUT
+		if value, ok := config["producerTimeout"]; ok {
+			ms, isNumber := value.(float64)
+			if !isNumber || ms <= 0 {
+				return nil, fmt.Errorf("producerTimeout must be a positive number of milliseconds, got %v", value)
+			}
+			timeout = time.Duration(ms * float64(time.Millisecond))
+		}
+		return &messageProducer{url: url, headers: headers, httpClient: &http.Client{Timeout: timeout}}, nil
+	}
+	if messages, ok := config["messages"]; ok {
+		fields, ok := messages.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("messages must be an object keyed by interaction description")
+		}
+		return configuredMessages(fields), nil
+	}
+	return nil, fmt.Errorf("no message source is configured for verification, see producerUrl and messages")
+}
+
+// configuredMessages are the messages of the verification configuration, keyed by interaction
+// description or key
+type configuredMessages map[string]any
+
+func (m configuredMessages) Message(ctx context.Context, interaction *pactInteraction) (*providerMessage, error) {
+	fields, ok := m[interaction.Description].(map[string]any)
+	if !ok && interaction.Key != "" {
+		fields, ok = m[interaction.Key].(map[string]any)
+	}
+	if !ok {
+		return nil, fmt.Errorf("no message is configured for interaction %q", interaction.Description)
+	}
+	return parseProviderMessage(fields)
+}
+
+// messageProducer is the test-only HTTP endpoint of a provider that returns the message it would
+// publish for an interaction, like the message producers of Pact message verification
+type messageProducer struct {
+	url string
+	// headers are added to every request, for example for authentication
+	headers    map[string]string
+	httpClient *http.Client
+}
+
+// producerRequest is the body POSTed to a message producer
+type producerRequest struct {
+	Description    string           `json:"description"`
+	ProviderStates []map[string]any `json:"providerStates"`
+}
+
+// Message POSTs the description and provider states of the interaction to the producer. A JSON
+// response is an envelope like the messages of the verification configuration, see
+// parseProviderMessage. Any other response is the framed record value, with the key, headers, topic,
+// partition and timestamp in the envelope fields of the base64 encoded JSON of its
+// PRODUCER_METADATA_HEADER. An empty response is a tombstone.
+func (p *messageProducer) Message(ctx context.Context, interaction *pactInteraction) (*providerMessage, error) {
+	states := interaction.ProviderStates
+	if states == nil {
+		states = []map[string]any{}
+	}
+	body, err := json.Marshal(producerRequest{Description: interaction.Description, ProviderStates: states})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range p.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call the message producer: %w", err)
+	}
+	// nolint:errcheck
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the message producer response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("message producer returned %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/json" {
+		fields := make(map[string]any)
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, fmt.Errorf("failed to parse the message producer response: %w", err)
+		}
+		return parseProviderMessage(fields)
+	}
+
+	fields := make(map[string]any)
+	if encoded := resp.Header.Get(PRODUCER_METADATA_HEADER); encoded != "" {
+		metadata, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%s header must be base64 encoded: %w", PRODUCER_METADATA_HEADER, err)
+		}
+		if err := json.Unmarshal(metadata, &fields); err != nil {
+			return nil, fmt.Errorf("failed to parse the %s header: %w", PRODUCER_METADATA_HEADER, err)
+		}
+		delete(fields, "value")
+		delete(fields, "valueFile")
+	}
+	message, err := parseProviderMessage(fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		message.value = data
+	}
+	return message, nil
+}
+
+// parseProviderMessage parses a provider message given as JSON. The value is the base64 encoded record
+// value, framed like the provider's serializer does, or null for a tombstone. It may be read from a
+// valueFile instead. The key is base64 encoded, header values are strings or {"binary": "<base64>"},
+// and the timestamp is epoch milliseconds.
+func parseProviderMessage(fields map[string]any) (*providerMessage, error) {
+	message := &providerMessage{metadata: make(map[string]any)}
+
+	switch value := fields["value"].(type) {
+	case nil:
+		if file, ok := fields["valueFile"]; ok && file != nil {
+			value, err := structpb.NewValue(file)
+			if err != nil {
+				return nil, fmt.Errorf("valueFile must be a string: %w", err)
+			}
+			path, err := absolutePath("valueFile", value)
+			if err != nil {
+				return nil, err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the message value: %w", err)
+			}
+			message.value = data
+		}
+	case string:
+		data, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("value must be base64 encoded: %w", err)
+		}
+		message.value = data
+	default:
+		return nil, fmt.Errorf("value must be a base64 encoded string or null")
+	}
+
+	if key, ok := fields["key"]; ok && key != nil {
+		text, isString := key.(string)
+		data, err := base64.StdEncoding.DecodeString(text)
+		if !isString || err != nil {
+			return nil, fmt.Errorf("key must be a base64 encoded string")
+		}
+		message.key = data
+	}
+
+	headers, _ := fields["headers"].(map[string]any)
+	for name, value := range headers {
+		switch v := value.(type) {
+		case string:
+			message.metadata[name] = v
+		case map[string]any:
+			text, ok := v["binary"].(string)
+			if !ok {
+				return nil, fmt.Errorf("header %s must be a string or {\"binary\": \"<base64>\"}, got %s", name, renderValue(v))
+			}
+			data, err := base64.StdEncoding.DecodeString(text)
+			if err != nil {
+				return nil, fmt.Errorf("header %s: binary must be base64 encoded: %w", name, err)
+			}
+			message.metadata[name] = data
+		default:
+			return nil, fmt.Errorf("header %s must be a string or {\"binary\": \"<base64>\"}, got %s", name, jsonType(v))
+		}
+	}
+	switch topic := fields["topic"].(type) {
+	case nil:
+	case string:
+		message.metadata[TOPIC_METADATA_KEY] = topic
+	default:
+		return nil, fmt.Errorf("topic must be a string, got %s", jsonType(topic))
+	}
+	for key, metadataKey := range map[string]string{"partition": PARTITION_METADATA_KEY, "timestamp": TIMESTAMP_METADATA_KEY} {
+		switch v := fields[key].(type) {
+		case nil:
+		case float64:
+			if v != math.Trunc(v) {
+				return nil, fmt.Errorf("%s must be an integer, got %v", key, v)
+			}
+			message.metadata[metadataKey] = int64(v)
+		case string:
+			message.metadata[metadataKey] = v
+		default:
+			return nil, fmt.Errorf("%s must be a number or a string, got %s", key, jsonType(v))
+		}
+	}
+	return message, nil
+}
+
+// jsonType returns the name of the JSON type of a value parsed from JSON, for error messages
+func jsonType(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a boolean"
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case []any:
+		return "an array"
+	case map[string]any:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// prepareVerification returns the expected message of an interaction of the pact, as the interaction
+// data of PrepareInteractionForVerification
+func prepareVerification(pactJSON, interactionKey string) (*pb.InteractionData, error) {
+	pact, err := parsePact(pactJSON)
+	if err != nil {
+		return nil, err
+	}
+	interaction, err := pact.interaction(interactionKey)
+	if err != nil {
+		return nil, err
+	}
+	config, err := pact.pluginConfiguration(interaction)
+	if err != nil {
+		return nil, err
+	}
+	body, err := interaction.body()
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := interaction.expectedMetadata(config)
+	if err != nil {
+		return nil, err
+	}
+	return interactionData(body, metadata)
+}
+
+// verifyMessage verifies the message a provider publishes for an interaction of the pact, comparing
+// its value and key with CompareContents and its metadata with CompareMetadata. The result has a
+// mismatch for every differing field, key and metadata value, and output describing what was verified.
+func (s *pactPluginServer) verifyMessage(ctx context.Context, pact *pactFile, interaction *pactInteraction, message *providerMessage, allowUnexpectedKeys bool) (*pb.VerificationResult, error) {
+	config, err := pact.pluginConfiguration(interaction)
+	if err != nil {
+		return nil, err
+	}
+	body, err := interaction.body()
+	if err != nil {
+		return nil, err
+	}
+	bodyRules, err := interaction.rules("body")
+	if err != nil {
+		return nil, err
+	}
+	expectedMetadata, err := interaction.expectedMetadata(config)
+	if err != nil {
+		return nil, err
+	}
+	metadataRules, err := interaction.rules("metadata")
+	if err != nil {
+		return nil, err
+	}
+	expectedKey, err := loadRecordKey(config)
+	if err != nil {
+		return nil, err
+	}
+
+	actualBody := &pb.Body{ContentType: body.ContentType, Content: wrapperspb.Bytes(message.value)}
+	// the key of the record is returned with its metadata, as interaction data has no key
+	responseMetadata := maps.Clone(message.metadata)
+	if message.key != nil {
+		responseMetadata[KEY_METADATA_KEY] = message.key
+	}
+	responseData, err := interactionData(actualBody, responseMetadata)
+	if err != nil {
+		return nil, err
+	}
+	result := &pb.VerificationResult{ResponseData: responseData, Output: []string{"generates a message which"}}
+
+	compared, err := s.CompareContents(ctx, &pb.CompareContentsRequest{
+		Expected:            body,
+		Actual:              actualBody,
+		AllowUnexpectedKeys: allowUnexpectedKeys,
+		Rules:               bodyRules,
+		PluginConfiguration: config,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if compared.Error != "" {
+		result.Mismatches = append(result.Mismatches, &pb.VerificationResultItem{Result: &pb.VerificationResultItem_Error{Error: compared.Error}})
+	}
+	bodyResults := make(mismatches)
+	for path, items := range compared.Results {
+		for _, item := range items.GetMismatches() {
+			// schema incompatibilities keep their own type, see checkSchemaCompatibility
+			if item.MismatchType == "" {
+				item.MismatchType = "body"
+			}
+			bodyResults[path] = append(bodyResults[path], item)
+		}
+	}
+	appendMismatches(result, bodyResults)
+	result.Output = append(result.Output, "  has a matching body "+verificationStatus(compared.Error == "" && len(bodyResults) == 0))
+
+	if expectedKey != nil {
+		keyResults, keyError, err := s.compareKey(ctx, expectedKey, message.key, allowUnexpectedKeys)
+		if err != nil {
+			return nil, err
+		}
+		if keyError != "" {
+			result.Mismatches = append(result.Mismatches, &pb.VerificationResultItem{Result: &pb.VerificationResultItem_Error{Error: "key: " + keyError}})
+		}
+		appendMismatches(result, keyResults)
+		result.Output = append(result.Output, "  has a matching key "+verificationStatus(keyError == "" && len(keyResults) == 0))
+	}
+
+	metadataResults := CompareMetadata(expectedMetadata, message.metadata, metadataRules)
+	appendMismatches(result, metadataResults)
+	if len(expectedMetadata) > 0 {
+		result.Output = append(result.Output, "  includes metadata")
+		for _, key := range sortedKeys(expectedMetadata) {
+			result.Output = append(result.Output, fmt.Sprintf("    %q with value %s %s", key, renderValue(expectedMetadata[key]), verificationStatus(len(metadataResults[key]) == 0)))
+		}
+	}
+
+	result.Success = len(result.Mismatches) == 0
+	return result, nil
+}
+
+// compareKey compares the record key of a provider's message with the expected key. Keys of the
+// Schema Registry serializers are compared with CompareContents, like the message, and string keys
+// as a single value with the rules of the $ path. It returns the mismatches, of the key type, and
+// the error of CompareContents if the key could not be compared.
+func (s *pactPluginServer) compareKey(ctx context.Context, key *recordKey, actual []byte, allowUnexpectedKeys bool) (mismatches, string, error) {
+	results := make(mismatches)
+	expected := key.body.Content.GetValue()
+	switch {
+	case actual == nil:
+		results.add("$", nil, nil, "Expected a record key but received a null key")
+	case key.body.ContentType == STRING_KEY_CONTENT_TYPE:
+		keyRules := key.rules["$"].GetRule()
+		if len(keyRules) == 0 && !bytes.Equal(expected, actual) {
+			results.add("$", string(expected), string(actual), "Expected key %s but received %s", renderValue(string(expected)), renderValue(string(actual)))
+		}
+		for _, rule := range keyRules {
+			if mismatch := matchRule(rule, string(expected), string(actual)); mismatch != "" {
+				results.add("$", string(expected), string(actual), "Key: %s", mismatch)
+			}
+		}
+	default:
+		compared, err := s.CompareContents(ctx, &pb.CompareContentsRequest{
+			Expected:            key.body,
+			Actual:              &pb.Body{ContentType: key.body.ContentType, Content: wrapperspb.Bytes(actual)},
+			AllowUnexpectedKeys: allowUnexpectedKeys,
+			Rules:               key.rules,
+			PluginConfiguration: key.config,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		if compared.Error != "" {
+			return results, compared.Error, nil
+		}
+		for path, items := range compared.Results {
+			results[path] = append(results[path], items.GetMismatches()...)
+		}
+	}
+	return results.withType("key"), "", nil
+}
+
+// appendMismatches adds the mismatches to a verification result, ordered by path
+func appendMismatches(result *pb.VerificationResult, results mismatches) {
+	for _, path := range sortedKeys(results) {
+		for _, mismatch := range results[path] {
+			result.Mismatches = append(result.Mismatches, &pb.VerificationResultItem{Result: &pb.VerificationResultItem_Mismatch{Mismatch: mismatch}})
+		}
+	}
+}
+
+func verificationStatus(ok bool) string {
+	if ok {
+		return "(OK)"
+	}
+	return "(FAILED)"
+}
+
+// interactionData converts a message body and its record metadata to InteractionData
+func interactionData(body *pb.Body, metadata map[string]any) (*pb.InteractionData, error) {
+	data := &pb.InteractionData{Body: body, Metadata: make(map[string]*pb.MetadataValue, len(metadata))}
+	for key, value := range metadata {
+		v, err := metadataValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata value %s: %w", key, err)
+		}
+		data.Metadata[key] = v
+	}
+	return data, nil
+}